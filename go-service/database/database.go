@@ -39,6 +39,14 @@ func InitDB() {
 		&models.ChatFolder{},
 		&models.ChatNotification{},
 		&models.ChatModerationLog{},
+		&models.ChatModerationDecision{},
+		&models.ChatModerationAppeal{},
+		&models.ChatSanction{},
+		&models.ChatMessageEntity{},
+		&models.ChatMessageRevision{},
+		&models.ChatPinnedMessage{},
+		&models.ChatMention{},
+		&models.ChatExportJob{},
 		// Financial models
 		&models.SaleTransaction{},
 		&models.LeaseContract{},
@@ -48,10 +56,39 @@ func InitDB() {
 		&models.TransactionDocument{},
 		&models.LeaseDocument{},
 		&models.FinancialReport{},
+		// Storage models
+		&models.StoredBlob{},
+		&models.QuarantinedFile{},
+		&models.StorageQuota{},
+		&models.RetentionRule{},
+		// Event outbox, processed events and dead letters
+		&models.OutboxEvent{},
+		&models.ProcessedEvent{},
+		&models.DeadLetter{},
 	)
+	if err := MigrateChatSearch(DB); err != nil {
+		log.Println("Chat search migration failed:", err)
+	}
 	log.Println("Database migrated")
 }
 
+// MigrateChatSearch adds generated English and Arabic tsvector columns with GIN indexes for chat search
+func MigrateChatSearch(db *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE chat_messages
+		ADD COLUMN IF NOT EXISTS search_en tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED,
+		ADD COLUMN IF NOT EXISTS search_ar tsvector GENERATED ALWAYS AS (to_tsvector('arabic', coalesce(content, ''))) STORED;`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_search_en ON chat_messages USING GIN (search_en);`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_search_ar ON chat_messages USING GIN (search_ar);`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Migration for multilingual support
 func MigratePropertyTranslations(db *gorm.DB) error {
 	return db.Exec(`ALTER TABLE properties 
@@ -11,6 +11,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/graphql-go/graphql v0.8.1
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/image v0.25.0
 	gorm.io/driver/postgres v1.5.2
 	gorm.io/gorm v1.25.7
 	nhooyr.io/websocket v1.8.7
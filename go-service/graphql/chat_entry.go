@@ -1,23 +1,65 @@
 package graphql
 
 import (
+	"context"
+	"log"
 	"my-property/go-service/database"
+	"my-property/go-service/handlers"
 	"my-property/go-service/services"
 	"my-property/go-service/utils"
+	"os"
+	"strconv"
+	"time"
 )
 
 var ChatService *services.ChatService
 var ChatResolverInstance *ChatResolver
 
-func InitChatModule() {
-	// Initialize Kafka and Encryption services
-	kafkaService, err := utils.NewKafkaService([]string{"kafka:9092"})
+// InitChatModule builds the chat service and starts the background jobs of chat and of the event outbox,
+// which also carries financial events
+func InitChatModule(encryptionService *utils.EncryptionService) {
+	// Initialize the message broker
+	broker, err := utils.NewBrokerFromEnv()
 	if err != nil {
-		panic("Failed to initialize Kafka: " + err.Error())
+		// Relaying the outbox to a broker no other replica reads would mark events as sent that nobody receives
+		log.Fatalf("Failed to initialize message broker %q: %v", os.Getenv("MESSAGE_BROKER"), err)
+	}
+	// Handlers shared by all replicas skip events any replica already handled, also after a restart
+	broker.UseProcessedEventStore(services.NewProcessedEventStore(database.DB))
+	for _, topic := range append([]string{utils.ChatEventsTopic, utils.FinancialEventsTopic}, services.DeadLetterTopics()...) {
+		if err := broker.EnsureTopic(topic); err != nil {
+			log.Printf("Failed to create %s topic: %v", topic, err)
+		}
+	}
+	if kafkaService, ok := broker.(*utils.KafkaService); ok {
+		if migrate, _ := strconv.ParseBool(os.Getenv("KAFKA_DELETE_LEGACY_ROOM_TOPICS")); migrate {
+			deleted, err := kafkaService.DeleteLegacyRoomTopics()
+			if err != nil {
+				log.Printf("Failed to delete legacy room topics: %v", err)
+			}
+			log.Printf("Deleted %d legacy room topics", deleted)
+		}
 	}
-	encryptionService := utils.NewEncryptionService("your-secret-key-from-env")
 
 	// Initialize ChatService
-	ChatService = services.NewChatService(database.DB, kafkaService, encryptionService)
+	ChatService = services.NewChatService(database.DB, broker, encryptionService)
 	ChatResolverInstance = NewChatResolver(ChatService)
-} 
\ No newline at end of file
+	handlers.ChatService = ChatService
+
+	// Apply attachment retention rules in the background
+	ChatService.StartRetentionJob(context.Background(), time.Hour)
+
+	// Publish chat and financial events written to the outbox
+	services.NewOutboxRelay(database.DB, broker).StartRelayJob(context.Background(), time.Second)
+
+	// Keep messages handlers gave up on for inspection and replay
+	if err := ChatService.StartDeadLetterCollector(); err != nil {
+		log.Printf("Failed to start dead letter collector: %v", err)
+	}
+
+	// Pick up exports a restart left pending or running
+	ChatService.StartExportRecoveryJob(context.Background(), 5*time.Minute)
+
+	// Re-moderate content queued while the AI service was unavailable
+	ChatService.StartRemoderationJob(context.Background(), time.Minute)
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"mime/multipart"
 	"my-property/go-service/models"
+	"my-property/go-service/presence"
+	"my-property/go-service/pubsub"
 	"my-property/go-service/services"
 	"my-property/go-service/utils"
 	"strconv"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
 )
@@ -25,13 +28,28 @@ func NewChatResolver(chatService *services.ChatService) *ChatResolver {
 func (r *ChatResolver) CreateRoom(ctx context.Context, input CreateRoomInput) (*models.ChatRoom, error) {
 	// Get user ID from context (assuming it's set by middleware)
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	// Bare participant IDs are regular users
+	var participants []services.ParticipantRef
+	for _, id := range input.ParticipantIDs {
+		participants = append(participants, services.ParticipantRef{UserID: id, UserType: "user"})
+	}
+	for _, participant := range input.Participants {
+		ref, err := parseParticipantInput(participant)
+		if err != nil {
+			return nil, err
+		}
+		participants = append(participants, ref)
+	}
 
 	room, err := r.chatService.CreateRoom(
 		input.Name,
 		input.Description,
 		input.Type,
 		userID,
-		input.ParticipantIDs,
+		userType,
+		participants,
 	)
 	if err != nil {
 		return nil, err
@@ -40,10 +58,35 @@ func (r *ChatResolver) CreateRoom(ctx context.Context, input CreateRoomInput) (*
 	return room, nil
 }
 
+// StartInquiry opens (or reopens) the caller's direct room with a listing's landlord or company
+func (r *ChatResolver) StartInquiry(ctx context.Context, input StartInquiryInput) (*models.ChatRoom, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	var propertyID, buildingID *uint
+	if input.PropertyID != nil {
+		id, err := strconv.ParseUint(*input.PropertyID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		propertyID = &[]uint{uint(id)}[0]
+	}
+	if input.BuildingID != nil {
+		id, err := strconv.ParseUint(*input.BuildingID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		buildingID = &[]uint{uint(id)}[0]
+	}
+
+	return r.chatService.StartInquiry(userID, userType, propertyID, buildingID)
+}
+
 func (r *ChatResolver) GetRoomsByUser(ctx context.Context) ([]*models.ChatRoom, error) {
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
-	rooms, err := r.chatService.GetRoomsByUser(userID)
+	rooms, err := r.chatService.GetRoomsByUser(userID, userType)
 	if err != nil {
 		return nil, err
 	}
@@ -58,12 +101,15 @@ func (r *ChatResolver) GetRoomsByUser(ctx context.Context) ([]*models.ChatRoom,
 }
 
 func (r *ChatResolver) GetRoomByID(ctx context.Context, roomID string) (*models.ChatRoom, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	id, err := strconv.ParseUint(roomID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	room, err := r.chatService.GetRoomByID(uint(id))
+	room, err := r.chatService.GetRoomByID(uint(id), userID, userType)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +152,7 @@ func (r *ChatResolver) SendMessage(ctx context.Context, input SendMessageInput)
 		input.MessageType,
 		replyToID,
 		referenceID,
+		input.ThreadOnly != nil && *input.ThreadOnly,
 	)
 	if err != nil {
 		return nil, err
@@ -115,6 +162,9 @@ func (r *ChatResolver) SendMessage(ctx context.Context, input SendMessageInput)
 }
 
 func (r *ChatResolver) GetMessages(ctx context.Context, roomID string, limit *int, offset *int) ([]*models.ChatMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	id, err := strconv.ParseUint(roomID, 10, 32)
 	if err != nil {
 		return nil, err
@@ -130,7 +180,57 @@ func (r *ChatResolver) GetMessages(ctx context.Context, roomID string, limit *in
 		o = *offset
 	}
 
-	messages, err := r.chatService.GetMessages(uint(id), l, o)
+	messages, err := r.chatService.GetMessages(uint(id), userID, userType, l, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var messagePtrs []*models.ChatMessage
+	for i := range messages {
+		messagePtrs = append(messagePtrs, &messages[i])
+	}
+
+	return messagePtrs, nil
+}
+
+func (r *ChatResolver) Thread(ctx context.Context, rootMessageID string, limit *int, offset *int) (*services.MessageThread, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(rootMessageID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	l := 50 // default limit
+	if limit != nil {
+		l = *limit
+	}
+
+	o := 0 // default offset
+	if offset != nil {
+		o = *offset
+	}
+
+	return r.chatService.GetThread(uint(id), userID, userType, l, o)
+}
+
+func (r *ChatResolver) Mentions(ctx context.Context, limit *int, offset *int) ([]*models.ChatMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	l := 50 // default limit
+	if limit != nil {
+		l = *limit
+	}
+
+	o := 0 // default offset
+	if offset != nil {
+		o = *offset
+	}
+
+	messages, err := r.chatService.GetMentions(userID, userType, l, o)
 	if err != nil {
 		return nil, err
 	}
@@ -146,13 +246,14 @@ func (r *ChatResolver) GetMessages(ctx context.Context, roomID string, limit *in
 
 func (r *ChatResolver) EditMessage(ctx context.Context, input EditMessageInput) (*models.ChatMessage, error) {
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
 	messageID, err := strconv.ParseUint(input.MessageID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	message, err := r.chatService.EditMessage(uint(messageID), userID, input.NewContent)
+	message, err := r.chatService.EditMessage(uint(messageID), userID, userType, input.NewContent)
 	if err != nil {
 		return nil, err
 	}
@@ -160,15 +261,39 @@ func (r *ChatResolver) EditMessage(ctx context.Context, input EditMessageInput)
 	return message, nil
 }
 
+func (r *ChatResolver) GetMessageRevisions(ctx context.Context, messageID string) ([]*models.ChatMessageRevision, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(messageID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := r.chatService.GetMessageRevisions(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var revisionPtrs []*models.ChatMessageRevision
+	for i := range revisions {
+		revisionPtrs = append(revisionPtrs, &revisions[i])
+	}
+
+	return revisionPtrs, nil
+}
+
 func (r *ChatResolver) DeleteMessage(ctx context.Context, messageID string) (bool, error) {
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
 	id, err := strconv.ParseUint(messageID, 10, 32)
 	if err != nil {
 		return false, err
 	}
 
-	err = r.chatService.DeleteMessage(uint(id), userID)
+	err = r.chatService.DeleteMessage(uint(id), userID, userType)
 	if err != nil {
 		return false, err
 	}
@@ -178,6 +303,9 @@ func (r *ChatResolver) DeleteMessage(ctx context.Context, messageID string) (boo
 
 // File Upload Resolvers
 func (r *ChatResolver) UploadFile(ctx context.Context, input UploadFileInput) (*models.ChatAttachment, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	messageID, err := strconv.ParseUint(input.MessageID, 10, 32)
 	if err != nil {
 		return nil, err
@@ -191,7 +319,16 @@ func (r *ChatResolver) UploadFile(ctx context.Context, input UploadFileInput) (*
 	}
 	fileHeader.Header.Set("Content-Type", input.File.ContentType)
 
-	attachment, err := r.chatService.UploadFile(uint(messageID), fileHeader, input.File.File)
+	var folderID *uint
+	if input.FolderID != nil {
+		id, err := strconv.ParseUint(*input.FolderID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		folderID = &[]uint{uint(id)}[0]
+	}
+
+	attachment, err := r.chatService.UploadFile(uint(messageID), userID, userType, folderID, fileHeader, input.File.File)
 	if err != nil {
 		return nil, err
 	}
@@ -200,12 +337,15 @@ func (r *ChatResolver) UploadFile(ctx context.Context, input UploadFileInput) (*
 }
 
 func (r *ChatResolver) DownloadFile(ctx context.Context, attachmentID string) (*FileDownload, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	id, err := strconv.ParseUint(attachmentID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	data, filename, err := r.chatService.DownloadFile(uint(id))
+	data, filename, err := r.chatService.DownloadFile(uint(id), userID, userType)
 	if err != nil {
 		return nil, err
 	}
@@ -216,6 +356,15 @@ func (r *ChatResolver) DownloadFile(ctx context.Context, attachmentID string) (*
 	}, nil
 }
 
+// ThumbnailURL resolves ChatAttachment.thumbnailUrl to a short-lived signed URL
+func (r *ChatResolver) ThumbnailURL(ctx context.Context, obj *models.ChatAttachment) (*string, error) {
+	url := r.chatService.ThumbnailURL(obj)
+	if url == "" {
+		return nil, nil
+	}
+	return &url, nil
+}
+
 // Reaction Resolvers
 func (r *ChatResolver) AddReaction(ctx context.Context, input AddReactionInput) (*models.ChatReaction, error) {
 	userID := ctx.Value("user_id").(uint)
@@ -251,203 +400,1275 @@ func (r *ChatResolver) RemoveReaction(ctx context.Context, input RemoveReactionI
 	return true, nil
 }
 
-// Folder Resolvers
-func (r *ChatResolver) CreateFolder(ctx context.Context, input CreateFolderInput) (*models.ChatFolder, error) {
+// Pin Resolvers
+func (r *ChatResolver) PinMessage(ctx context.Context, input PinMessageInput) ([]*models.ChatPinnedMessage, error) {
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
-	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
+	messageID, err := strconv.ParseUint(input.MessageID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	var parentID *uint
-	if input.ParentID != nil {
-		id, err := strconv.ParseUint(*input.ParentID, 10, 32)
-		if err != nil {
-			return nil, err
-		}
-		parentID = &[]uint{uint(id)}[0]
+	pins, err := r.chatService.PinMessage(uint(messageID), userID, userType, input.Position)
+	if err != nil {
+		return nil, err
 	}
 
-	folder, err := r.chatService.CreateFolder(uint(roomID), input.Name, input.Description, parentID, userID)
+	return toPinPtrs(pins), nil
+}
+
+func (r *ChatResolver) UnpinMessage(ctx context.Context, messageID string) ([]*models.ChatPinnedMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(messageID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	return folder, nil
+	pins, err := r.chatService.UnpinMessage(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPinPtrs(pins), nil
 }
 
-func (r *ChatResolver) GetFolders(ctx context.Context, roomID string) ([]*models.ChatFolder, error) {
+func (r *ChatResolver) PinnedMessages(ctx context.Context, roomID string) ([]*models.ChatPinnedMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	id, err := strconv.ParseUint(roomID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	folders, err := r.chatService.GetFolders(uint(id))
+	pins, err := r.chatService.GetPinnedMessages(uint(id), userID, userType)
 	if err != nil {
 		return nil, err
 	}
 
+	return toPinPtrs(pins), nil
+}
+
+func toPinPtrs(pins []models.ChatPinnedMessage) []*models.ChatPinnedMessage {
 	// Convert to pointers
-	var folderPtrs []*models.ChatFolder
-	for i := range folders {
-		folderPtrs = append(folderPtrs, &folders[i])
+	var pinPtrs []*models.ChatPinnedMessage
+	for i := range pins {
+		pinPtrs = append(pinPtrs, &pins[i])
 	}
-
-	return folderPtrs, nil
+	return pinPtrs
 }
 
-// Notification Resolvers
-func (r *ChatResolver) GetNotifications(ctx context.Context) ([]*models.ChatNotification, error) {
+// Read Receipt Resolvers
+func (r *ChatResolver) MarkRoomRead(ctx context.Context, input MarkRoomReadInput) (*models.ChatParticipant, error) {
 	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
-	notifications, err := r.chatService.GetNotifications(userID)
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert to pointers
-	var notificationPtrs []*models.ChatNotification
-	for i := range notifications {
-		notificationPtrs = append(notificationPtrs, &notifications[i])
+	var messageID *uint
+	if input.MessageID != nil {
+		id, err := strconv.ParseUint(*input.MessageID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		messageID = &[]uint{uint(id)}[0]
 	}
 
-	return notificationPtrs, nil
+	return r.chatService.MarkRoomRead(uint(roomID), userID, userType, messageID)
 }
 
-func (r *ChatResolver) MarkNotificationAsRead(ctx context.Context, notificationID string) (bool, error) {
-	id, err := strconv.ParseUint(notificationID, 10, 32)
+// Presence Resolvers
+func (r *ChatResolver) SetTyping(ctx context.Context, roomID string, isTyping bool) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
 	if err != nil {
 		return false, err
 	}
 
-	err = r.chatService.MarkNotificationAsRead(uint(id))
-	if err != nil {
+	if err := r.chatService.SetTyping(uint(id), userID, userType, isTyping); err != nil {
 		return false, err
 	}
 
 	return true, nil
 }
 
-// Search Resolvers
-func (r *ChatResolver) SearchMessages(ctx context.Context, roomID string, query string) ([]*models.ChatMessage, error) {
+// Membership Resolvers
+func (r *ChatResolver) GetParticipants(ctx context.Context, roomID string) ([]*models.ChatParticipant, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
 	id, err := strconv.ParseUint(roomID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	messages, err := r.chatService.SearchMessages(uint(id), query)
+	participants, err := r.chatService.GetParticipants(uint(id), userID, userType)
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to pointers
-	var messagePtrs []*models.ChatMessage
-	for i := range messages {
-		messagePtrs = append(messagePtrs, &messages[i])
+	var participantPtrs []*models.ChatParticipant
+	for i := range participants {
+		participantPtrs = append(participantPtrs, &participants[i])
 	}
 
-	return messagePtrs, nil
+	return participantPtrs, nil
 }
 
-// Statistics Resolvers
-func (r *ChatResolver) GetMessageStats(ctx context.Context, roomID string) (*MessageStats, error) {
-	id, err := strconv.ParseUint(roomID, 10, 32)
+func (r *ChatResolver) InviteParticipants(ctx context.Context, input InviteParticipantsInput) ([]*models.ChatParticipant, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
 	if err != nil {
 		return nil, err
 	}
 
-	stats, err := r.chatService.GetMessageStats(uint(id))
+	var invitees []services.ParticipantRef
+	for _, participant := range input.Participants {
+		ref, err := parseParticipantInput(participant)
+		if err != nil {
+			return nil, err
+		}
+		invitees = append(invitees, ref)
+	}
+
+	participants, err := r.chatService.InviteParticipants(uint(roomID), userID, userType, invitees)
 	if err != nil {
 		return nil, err
 	}
 
-	return &MessageStats{
-		TotalMessages: int(stats["total_messages"].(int64)),
-		TodayMessages: int(stats["today_messages"].(int64)),
-		TotalFiles:    int(stats["total_files"].(int64)),
-	}, nil
+	// Convert to pointers
+	var participantPtrs []*models.ChatParticipant
+	for i := range participants {
+		participantPtrs = append(participantPtrs, &participants[i])
+	}
+
+	return participantPtrs, nil
 }
 
-// Subscription Resolvers
-func (r *ChatResolver) MessageAdded(ctx context.Context, roomID string) (<-chan *models.ChatMessage, error) {
-	id, err := strconv.ParseUint(roomID, 10, 32)
+func (r *ChatResolver) RemoveParticipant(ctx context.Context, input RoomParticipantInput) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, target, err := parseRoomParticipantInput(input)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	// Create channel for real-time messages
-	messageChan := make(chan *models.ChatMessage)
+	if err := r.chatService.RemoveParticipant(roomID, userID, userType, target); err != nil {
+		return false, err
+	}
 
-	// Subscribe to Kafka topic for this room
-	err = r.chatService.KafkaService().SubscribeToRoom(uint(id), func(message *utils.ChatMessage) error {
-		// Convert Kafka message to model
-		chatMessage := &models.ChatMessage{
-			ID:          message.ID,
-			RoomID:      message.RoomID,
-			SenderID:    message.SenderID,
-			SenderType:  message.SenderType,
-			Content:     message.Content,
-			MessageType: message.MessageType,
-			ReplyToID:   message.ReplyToID,
-			ReferenceID: message.ReferenceID,
-			CreatedAt:   message.CreatedAt,
-			UpdatedAt:   message.CreatedAt,
-		}
+	return true, nil
+}
 
-		// Send to subscription channel
-		select {
-		case messageChan <- chatMessage:
-		default:
-			// Channel is full, skip this message
-		}
+func (r *ChatResolver) ChangeParticipantRole(ctx context.Context, input ChangeParticipantRoleInput) (*models.ChatParticipant, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
-		return nil
+	roomID, target, err := parseRoomParticipantInput(RoomParticipantInput{
+		RoomID:      input.RoomID,
+		Participant: input.Participant,
 	})
-
 	if err != nil {
-		close(messageChan)
 		return nil, err
 	}
 
-	// Clean up when context is cancelled
-	go func() {
-		<-ctx.Done()
-		close(messageChan)
-	}()
-
-	return messageChan, nil
-}
-
-// Input Types
-type CreateRoomInput struct {
-	Name           string `json:"name"`
-	Description    string `json:"description"`
-	Type           string `json:"type"`
-	ParticipantIDs []uint `json:"participantIds"`
+	return r.chatService.ChangeParticipantRole(roomID, userID, userType, target, input.Role)
 }
 
-type SendMessageInput struct {
-	RoomID      string  `json:"roomId"`
-	Content     string  `json:"content"`
-	MessageType string  `json:"messageType"`
-	ReplyToID   *string `json:"replyToId"`
-	ReferenceID *string `json:"referenceId"`
-}
+func (r *ChatResolver) LeaveRoom(ctx context.Context, roomID string) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
 
-type EditMessageInput struct {
-	MessageID  string `json:"messageId"`
-	NewContent string `json:"newContent"`
-}
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return false, err
+	}
 
-type UploadFileInput struct {
-	MessageID string         `json:"messageId"`
-	File      graphql.Upload `json:"file"`
-}
+	if err := r.chatService.LeaveRoom(uint(id), userID, userType); err != nil {
+		return false, err
+	}
 
-type AddReactionInput struct {
-	MessageID string `json:"messageId"`
-	Emoji     string `json:"emoji"`
+	return true, nil
+}
+
+func (r *ChatResolver) TransferRoomOwnership(ctx context.Context, input RoomParticipantInput) (*models.ChatParticipant, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, newOwner, err := parseRoomParticipantInput(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.TransferOwnership(roomID, userID, userType, newOwner)
+}
+
+func parseParticipantInput(input ParticipantInput) (services.ParticipantRef, error) {
+	id, err := strconv.ParseUint(input.UserID, 10, 32)
+	if err != nil {
+		return services.ParticipantRef{}, err
+	}
+	return services.ParticipantRef{UserID: uint(id), UserType: input.UserType}, nil
+}
+
+func parseRoomParticipantInput(input RoomParticipantInput) (uint, services.ParticipantRef, error) {
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
+	if err != nil {
+		return 0, services.ParticipantRef{}, err
+	}
+
+	participant, err := parseParticipantInput(input.Participant)
+	if err != nil {
+		return 0, services.ParticipantRef{}, err
+	}
+
+	return uint(roomID), participant, nil
+}
+
+// Folder Resolvers
+func (r *ChatResolver) CreateFolder(ctx context.Context, input CreateFolderInput) (*models.ChatFolder, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentID *uint
+	if input.ParentID != nil {
+		id, err := strconv.ParseUint(*input.ParentID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		parentID = &[]uint{uint(id)}[0]
+	}
+
+	folder, err := r.chatService.CreateFolder(uint(roomID), input.Name, input.Description, parentID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+func (r *ChatResolver) GetFolders(ctx context.Context, roomID string) ([]*models.ChatFolder, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := r.chatService.GetFolders(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var folderPtrs []*models.ChatFolder
+	for i := range folders {
+		folderPtrs = append(folderPtrs, &folders[i])
+	}
+
+	return folderPtrs, nil
+}
+
+func (r *ChatResolver) GetFolderTree(ctx context.Context, roomID string) (*services.FolderTree, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.GetFolderTree(uint(id), userID, userType)
+}
+
+func (r *ChatResolver) RenameFolder(ctx context.Context, input RenameFolderInput) (*models.ChatFolder, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	folderID, err := strconv.ParseUint(input.FolderID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.RenameFolder(uint(folderID), userID, userType, input.Name)
+}
+
+func (r *ChatResolver) MoveFolder(ctx context.Context, input MoveFolderInput) (*models.ChatFolder, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	folderID, err := strconv.ParseUint(input.FolderID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentID *uint
+	if input.ParentID != nil {
+		id, err := strconv.ParseUint(*input.ParentID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		parentID = &[]uint{uint(id)}[0]
+	}
+
+	return r.chatService.MoveFolder(uint(folderID), userID, userType, parentID)
+}
+
+func (r *ChatResolver) DeleteFolder(ctx context.Context, folderID string) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(folderID, 10, 32)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.chatService.DeleteFolder(uint(id), userID, userType); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *ChatResolver) MoveAttachment(ctx context.Context, input MoveAttachmentInput) (*models.ChatAttachment, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	attachmentID, err := strconv.ParseUint(input.AttachmentID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var folderID *uint
+	if input.FolderID != nil {
+		id, err := strconv.ParseUint(*input.FolderID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		folderID = &[]uint{uint(id)}[0]
+	}
+
+	return r.chatService.MoveAttachment(uint(attachmentID), userID, userType, folderID)
+}
+
+// Storage Resolvers
+func (r *ChatResolver) SetStorageQuota(ctx context.Context, input SetStorageQuotaInput) (*models.StorageQuota, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	ownerID, err := strconv.ParseUint(input.OwnerID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.SetStorageQuota(userID, userType, input.OwnerType, uint(ownerID), int64(input.LimitBytes))
+}
+
+func (r *ChatResolver) GetStorageUsage(ctx context.Context, ownerType string, ownerID string) (*models.StorageQuota, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(ownerID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.GetStorageUsage(userID, userType, ownerType, uint(id))
+}
+
+func (r *ChatResolver) CreateRetentionRule(ctx context.Context, input CreateRetentionRuleInput) (*models.RetentionRule, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	var roomID *uint
+	if input.RoomID != nil {
+		id, err := strconv.ParseUint(*input.RoomID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		roomID = &[]uint{uint(id)}[0]
+	}
+
+	roomType := ""
+	if input.RoomType != nil {
+		roomType = *input.RoomType
+	}
+
+	return r.chatService.CreateRetentionRule(userID, userType, input.Name, roomType, roomID, input.MaxAgeDays)
+}
+
+func (r *ChatResolver) GetRetentionRules(ctx context.Context) ([]*models.RetentionRule, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	rules, err := r.chatService.GetRetentionRules(userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var rulePtrs []*models.RetentionRule
+	for i := range rules {
+		rulePtrs = append(rulePtrs, &rules[i])
+	}
+
+	return rulePtrs, nil
+}
+
+func (r *ChatResolver) DeleteRetentionRule(ctx context.Context, ruleID string) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(ruleID, 10, 32)
+	if err != nil {
+		return false, err
+	}
+
+	if err := r.chatService.DeleteRetentionRule(uint(id), userID, userType); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Notification Resolvers
+func (r *ChatResolver) GetNotifications(ctx context.Context) ([]*models.ChatNotification, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	notifications, err := r.chatService.GetNotifications(userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var notificationPtrs []*models.ChatNotification
+	for i := range notifications {
+		notificationPtrs = append(notificationPtrs, &notifications[i])
+	}
+
+	return notificationPtrs, nil
+}
+
+func (r *ChatResolver) MarkNotificationAsRead(ctx context.Context, notificationID string) (bool, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(notificationID, 10, 32)
+	if err != nil {
+		return false, err
+	}
+
+	err = r.chatService.MarkNotificationAsRead(uint(id), userID, userType)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Search Resolvers
+func (r *ChatResolver) SearchMessages(ctx context.Context, roomID string, query string) ([]*models.ChatMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := r.chatService.SearchMessages(uint(id), userID, userType, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var messagePtrs []*models.ChatMessage
+	for i := range messages {
+		messagePtrs = append(messagePtrs, &messages[i])
+	}
+
+	return messagePtrs, nil
+}
+
+func (r *ChatResolver) SearchChatMessages(ctx context.Context, input SearchChatMessagesInput) ([]*services.MessageSearchResult, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	filter := services.MessageSearchFilter{
+		Query:         input.Query,
+		HasAttachment: input.HasAttachment,
+		From:          input.From,
+		To:            input.To,
+	}
+	if input.RoomID != nil {
+		id, err := strconv.ParseUint(*input.RoomID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		filter.RoomID = &[]uint{uint(id)}[0]
+	}
+	if input.SenderID != nil {
+		id, err := strconv.ParseUint(*input.SenderID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		filter.SenderID = &[]uint{uint(id)}[0]
+	}
+	if input.SenderType != nil {
+		filter.SenderType = *input.SenderType
+	}
+	if input.MessageType != nil {
+		filter.MessageType = *input.MessageType
+	}
+	if input.Language != nil {
+		filter.Language = *input.Language
+	}
+	if input.Limit != nil {
+		filter.Limit = *input.Limit
+	}
+	if input.Offset != nil {
+		filter.Offset = *input.Offset
+	}
+
+	results, err := r.chatService.Search(userID, userType, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var resultPtrs []*services.MessageSearchResult
+	for i := range results {
+		resultPtrs = append(resultPtrs, &results[i])
+	}
+
+	return resultPtrs, nil
+}
+
+// Moderation Resolvers
+func (r *ChatResolver) ModerationQueue(ctx context.Context, roomID string, status *string, limit *int, offset *int) ([]*models.ChatModerationLog, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	reviewStatus := ""
+	if status != nil {
+		reviewStatus = *status
+	}
+	limitVal := 50 // default limit
+	if limit != nil {
+		limitVal = *limit
+	}
+	offsetVal := 0
+	if offset != nil {
+		offsetVal = *offset
+	}
+
+	entries, err := r.chatService.GetModerationQueue(uint(id), userID, userType, reviewStatus, limitVal, offsetVal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var entryPtrs []*models.ChatModerationLog
+	for i := range entries {
+		entryPtrs = append(entryPtrs, &entries[i])
+	}
+
+	return entryPtrs, nil
+}
+
+func (r *ChatResolver) ReviewModerationItem(ctx context.Context, input ReviewModerationItemInput) (*models.ChatModerationLog, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	logID, err := strconv.ParseUint(input.LogID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.ReviewModerationItem(uint(logID), userID, userType, input.Action, input.Reason)
+}
+
+func (r *ChatResolver) AppealModeration(ctx context.Context, input AppealModerationInput) (*models.ChatModerationAppeal, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	logID, err := strconv.ParseUint(input.LogID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.AppealModeration(uint(logID), userID, userType, input.Reason)
+}
+
+func (r *ChatResolver) ModerationAppeals(ctx context.Context, roomID string, status *string, limit *int, offset *int) ([]*models.ChatModerationAppeal, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	appealStatus := ""
+	if status != nil {
+		appealStatus = *status
+	}
+	limitVal := 50 // default limit
+	if limit != nil {
+		limitVal = *limit
+	}
+	offsetVal := 0
+	if offset != nil {
+		offsetVal = *offset
+	}
+
+	appeals, err := r.chatService.GetModerationAppeals(uint(id), userID, userType, appealStatus, limitVal, offsetVal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var appealPtrs []*models.ChatModerationAppeal
+	for i := range appeals {
+		appealPtrs = append(appealPtrs, &appeals[i])
+	}
+
+	return appealPtrs, nil
+}
+
+func (r *ChatResolver) ResolveAppeal(ctx context.Context, input ResolveAppealInput) (*models.ChatModerationAppeal, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	appealID, err := strconv.ParseUint(input.AppealID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.ResolveAppeal(uint(appealID), userID, userType, input.Overturn, input.Reason)
+}
+
+func (r *ChatResolver) ModerationDecisions(ctx context.Context, logID string) ([]*models.ChatModerationDecision, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(logID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions, err := r.chatService.GetModerationDecisions(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var decisionPtrs []*models.ChatModerationDecision
+	for i := range decisions {
+		decisionPtrs = append(decisionPtrs, &decisions[i])
+	}
+
+	return decisionPtrs, nil
+}
+
+// Sanction Resolvers
+// SanctionParticipant mutes, kicks or bans a participant in a room, or platform-wide when roomId is omitted
+func (r *ChatResolver) SanctionParticipant(ctx context.Context, input SanctionParticipantInput) (*models.ChatSanction, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	var roomID *uint
+	if input.RoomID != nil {
+		id, err := strconv.ParseUint(*input.RoomID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		roomID = &[]uint{uint(id)}[0]
+	}
+
+	target, err := parseParticipantInput(input.Participant)
+	if err != nil {
+		return nil, err
+	}
+
+	var duration time.Duration
+	if input.DurationMinutes != nil {
+		duration = time.Duration(*input.DurationMinutes) * time.Minute
+	}
+
+	return r.chatService.SanctionParticipant(roomID, userID, userType, target, input.Type, input.Reason, duration)
+}
+
+func (r *ChatResolver) SetSlowMode(ctx context.Context, input SetSlowModeInput) (*models.ChatSanction, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.SetSlowMode(uint(roomID), userID, userType, time.Duration(input.IntervalSeconds)*time.Second, input.Reason)
+}
+
+func (r *ChatResolver) LiftSanction(ctx context.Context, input LiftSanctionInput) (*models.ChatSanction, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	sanctionID, err := strconv.ParseUint(input.SanctionID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.LiftSanction(uint(sanctionID), userID, userType, input.Reason)
+}
+
+func (r *ChatResolver) Sanctions(ctx context.Context, roomID *string, activeOnly *bool) ([]*models.ChatSanction, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	var room *uint
+	if roomID != nil {
+		id, err := strconv.ParseUint(*roomID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		room = &[]uint{uint(id)}[0]
+	}
+
+	sanctions, err := r.chatService.GetSanctions(room, userID, userType, activeOnly != nil && *activeOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var sanctionPtrs []*models.ChatSanction
+	for i := range sanctions {
+		sanctionPtrs = append(sanctionPtrs, &sanctions[i])
+	}
+
+	return sanctionPtrs, nil
+}
+
+// Export Resolvers
+func (r *ChatResolver) RequestChatExport(ctx context.Context, input RequestChatExportInput) (*models.ChatExportJob, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	roomID, err := strconv.ParseUint(input.RoomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.RequestExport(uint(roomID), userID, userType, input.From, input.To)
+}
+
+func (r *ChatResolver) ChatExportJob(ctx context.Context, jobID string) (*models.ChatExportJob, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(jobID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.GetExportJob(uint(id), userID, userType)
+}
+
+func (r *ChatResolver) ChatExportJobs(ctx context.Context, roomID string) ([]*models.ChatExportJob, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := r.chatService.GetExportJobs(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var jobPtrs []*models.ChatExportJob
+	for i := range jobs {
+		jobPtrs = append(jobPtrs, &jobs[i])
+	}
+
+	return jobPtrs, nil
+}
+
+func (r *ChatResolver) DownloadChatExport(ctx context.Context, jobID string) (*ExportDownload, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(jobID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	data, filename, job, err := r.chatService.DownloadExport(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExportDownload{
+		Data:      data,
+		Filename:  filename,
+		SHA256:    job.ArchiveHash,
+		Signature: job.Signature,
+	}, nil
+}
+
+// Dead Letter Resolvers
+func (r *ChatResolver) DeadLetters(ctx context.Context, topic *string, status *string, limit *int, offset *int) ([]*models.DeadLetter, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	topicVal := ""
+	if topic != nil {
+		topicVal = *topic
+	}
+	statusVal := ""
+	if status != nil {
+		statusVal = *status
+	}
+	limitVal := 50 // default limit
+	if limit != nil {
+		limitVal = *limit
+	}
+	offsetVal := 0
+	if offset != nil {
+		offsetVal = *offset
+	}
+
+	entries, err := r.chatService.GetDeadLetters(userID, userType, topicVal, statusVal, limitVal, offsetVal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to pointers
+	var entryPtrs []*models.DeadLetter
+	for i := range entries {
+		entryPtrs = append(entryPtrs, &entries[i])
+	}
+
+	return entryPtrs, nil
+}
+
+func (r *ChatResolver) DeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	entryID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.GetDeadLetter(uint(entryID), userID, userType)
+}
+
+func (r *ChatResolver) ReplayDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	entryID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.ReplayDeadLetter(uint(entryID), userID, userType)
+}
+
+func (r *ChatResolver) DiscardDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	entryID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.chatService.DiscardDeadLetter(uint(entryID), userID, userType)
+}
+
+// Statistics Resolvers
+func (r *ChatResolver) GetMessageStats(ctx context.Context, roomID string) (*MessageStats, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := r.chatService.GetMessageStats(uint(id), userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageStats{
+		TotalMessages: int(stats["total_messages"].(int64)),
+		TodayMessages: int(stats["today_messages"].(int64)),
+		TotalFiles:    int(stats["total_files"].(int64)),
+	}, nil
+}
+
+// Subscription Resolvers
+func (r *ChatResolver) MessageAdded(ctx context.Context, roomID string) (<-chan *models.ChatMessage, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.chatService.RequireParticipant(uint(id), userID, userType); err != nil {
+		return nil, err
+	}
+
+	// Create channel for real-time messages
+	messageChan := make(chan *models.ChatMessage)
+
+	// Subscribe to the room's Kafka events
+	unsubscribe, err := r.chatService.SubscribeToRoom(uint(id), func(message *utils.ChatMessage) error {
+		// Convert Kafka message to model
+		chatMessage := &models.ChatMessage{
+			ID:           message.ID,
+			RoomID:       message.RoomID,
+			SenderID:     message.SenderID,
+			SenderType:   message.SenderType,
+			Content:      message.Content,
+			MessageType:  message.MessageType,
+			ReplyToID:    message.ReplyToID,
+			ReferenceID:  message.ReferenceID,
+			ThreadRootID: message.ThreadRootID,
+			ThreadOnly:   message.ThreadOnly,
+			CreatedAt:    message.CreatedAt,
+			UpdatedAt:    message.CreatedAt,
+		}
+
+		// Send to subscription channel
+		select {
+		case messageChan <- chatMessage:
+		default:
+			// Channel is full, skip this message
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		close(messageChan)
+		return nil, err
+	}
+
+	// Clean up when context is cancelled; no handler runs once unsubscribe returns
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+		close(messageChan)
+	}()
+
+	return messageChan, nil
+}
+
+func (r *ChatResolver) ReadReceipts(ctx context.Context, roomID string) (<-chan *services.ReadReceipt, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.chatService.RequireParticipant(uint(id), userID, userType); err != nil {
+		return nil, err
+	}
+
+	topic := services.ReadReceiptTopic(uint(id))
+	events := pubsub.GetInstance().Subscribe(topic)
+	receiptChan := make(chan *services.ReadReceipt)
+
+	go func() {
+		defer close(receiptChan)
+		defer pubsub.GetInstance().Unsubscribe(topic, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				receipt, ok := event.(*services.ReadReceipt)
+				if !ok {
+					continue
+				}
+				select {
+				case receiptChan <- receipt:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return receiptChan, nil
+}
+
+func (r *ChatResolver) PinnedMessagesUpdated(ctx context.Context, roomID string) (<-chan *services.PinnedMessagesEvent, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.chatService.RequireParticipant(uint(id), userID, userType); err != nil {
+		return nil, err
+	}
+
+	topic := services.PinnedMessagesTopic(uint(id))
+	events := pubsub.GetInstance().Subscribe(topic)
+	pinChan := make(chan *services.PinnedMessagesEvent)
+
+	go func() {
+		defer close(pinChan)
+		defer pubsub.GetInstance().Unsubscribe(topic, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				pins, ok := event.(*services.PinnedMessagesEvent)
+				if !ok {
+					continue
+				}
+				select {
+				case pinChan <- pins:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return pinChan, nil
+}
+
+func (r *ChatResolver) Typing(ctx context.Context, roomID string) (<-chan *presence.TypingEvent, error) {
+	userID := ctx.Value("user_id").(uint)
+	userType := ctx.Value("user_type").(string)
+
+	id, err := strconv.ParseUint(roomID, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.chatService.RequireParticipant(uint(id), userID, userType); err != nil {
+		return nil, err
+	}
+
+	topic := presence.TypingTopic(uint(id))
+	events := pubsub.GetInstance().Subscribe(topic)
+	typingChan := make(chan *presence.TypingEvent)
+
+	go func() {
+		defer close(typingChan)
+		defer pubsub.GetInstance().Unsubscribe(topic, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				typing, ok := event.(*presence.TypingEvent)
+				// Callers don't need their own indicator echoed back
+				if !ok || (typing.UserID == userID && typing.UserType == userType) {
+					continue
+				}
+				select {
+				case typingChan <- typing:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return typingChan, nil
+}
+
+func (r *ChatResolver) Presence(ctx context.Context, userIds []string, userType *string) (<-chan *presence.Event, error) {
+	userID := ctx.Value("user_id").(uint)
+	callerType := ctx.Value("user_type").(string)
+
+	t := "user" // default participant type
+	if userType != nil {
+		t = *userType
+	}
+
+	var candidates []services.ParticipantRef
+	for _, idStr := range userIds {
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, services.ParticipantRef{UserID: uint(id), UserType: t})
+	}
+
+	visible, err := r.chatService.VisibleParticipants(userID, callerType, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	watched := make(map[presence.User]bool, len(visible))
+	var users []presence.User
+	for _, ref := range visible {
+		user := presence.User{ID: ref.UserID, Type: ref.UserType}
+		watched[user] = true
+		users = append(users, user)
+	}
+
+	events := pubsub.GetInstance().Subscribe(presence.PresenceTopic)
+	presenceChan := make(chan *presence.Event)
+
+	go func() {
+		defer close(presenceChan)
+		defer pubsub.GetInstance().Unsubscribe(presence.PresenceTopic, events)
+
+		// Start with the current state so clients don't wait for the next change
+		for _, snapshot := range presence.GetInstance().Snapshot(users) {
+			snapshot := snapshot
+			select {
+			case presenceChan <- &snapshot:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				update, ok := event.(*presence.Event)
+				if !ok || !watched[presence.User{ID: update.UserID, Type: update.UserType}] {
+					continue
+				}
+				select {
+				case presenceChan <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return presenceChan, nil
+}
+
+// Input Types
+type CreateRoomInput struct {
+	Name           string             `json:"name"`
+	Description    string             `json:"description"`
+	Type           string             `json:"type"`
+	ParticipantIDs []uint             `json:"participantIds"`
+	Participants   []ParticipantInput `json:"participants"`
+}
+
+type PinMessageInput struct {
+	MessageID string `json:"messageId"`
+	Position  *int   `json:"position"`
+}
+
+type MarkRoomReadInput struct {
+	RoomID    string  `json:"roomId"`
+	MessageID *string `json:"messageId"`
+}
+
+type ParticipantInput struct {
+	UserID   string `json:"userId"`
+	UserType string `json:"userType"`
+}
+
+type InviteParticipantsInput struct {
+	RoomID       string             `json:"roomId"`
+	Participants []ParticipantInput `json:"participants"`
+}
+
+type RoomParticipantInput struct {
+	RoomID      string           `json:"roomId"`
+	Participant ParticipantInput `json:"participant"`
+}
+
+type ChangeParticipantRoleInput struct {
+	RoomID      string           `json:"roomId"`
+	Participant ParticipantInput `json:"participant"`
+	Role        string           `json:"role"`
+}
+
+type SendMessageInput struct {
+	RoomID      string  `json:"roomId"`
+	Content     string  `json:"content"`
+	MessageType string  `json:"messageType"`
+	ReplyToID   *string `json:"replyToId"`
+	ReferenceID *string `json:"referenceId"`
+	ThreadOnly  *bool   `json:"threadOnly"`
+}
+
+type EditMessageInput struct {
+	MessageID  string `json:"messageId"`
+	NewContent string `json:"newContent"`
+}
+
+type UploadFileInput struct {
+	MessageID string         `json:"messageId"`
+	FolderID  *string        `json:"folderId"`
+	File      graphql.Upload `json:"file"`
+}
+
+type AddReactionInput struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
 }
 
 type RemoveReactionInput struct {
@@ -461,11 +1682,108 @@ type CreateFolderInput struct {
 	ParentID    *string `json:"parentId"`
 }
 
+type RenameFolderInput struct {
+	FolderID string `json:"folderId"`
+	Name     string `json:"name"`
+}
+
+type MoveFolderInput struct {
+	FolderID string  `json:"folderId"`
+	ParentID *string `json:"parentId"`
+}
+
+type MoveAttachmentInput struct {
+	AttachmentID string  `json:"attachmentId"`
+	FolderID     *string `json:"folderId"`
+}
+
+type SetStorageQuotaInput struct {
+	OwnerType  string `json:"ownerType"`
+	OwnerID    string `json:"ownerId"`
+	LimitBytes int    `json:"limitBytes"`
+}
+
+type CreateRetentionRuleInput struct {
+	Name       string  `json:"name"`
+	RoomType   *string `json:"roomType"`
+	RoomID     *string `json:"roomId"`
+	MaxAgeDays int     `json:"maxAgeDays"`
+}
+
+type SearchChatMessagesInput struct {
+	Query         string     `json:"query"`
+	RoomID        *string    `json:"roomId"`
+	SenderID      *string    `json:"senderId"`
+	SenderType    *string    `json:"senderType"`
+	From          *time.Time `json:"from"`
+	To            *time.Time `json:"to"`
+	MessageType   *string    `json:"messageType"`
+	HasAttachment *bool      `json:"hasAttachment"`
+	Language      *string    `json:"language"`
+	Limit         *int       `json:"limit"`
+	Offset        *int       `json:"offset"`
+}
+
+type StartInquiryInput struct {
+	PropertyID *string `json:"propertyId"`
+	BuildingID *string `json:"buildingId"`
+}
+
+type ReviewModerationItemInput struct {
+	LogID  string `json:"logId"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+type AppealModerationInput struct {
+	LogID  string `json:"logId"`
+	Reason string `json:"reason"`
+}
+
+type ResolveAppealInput struct {
+	AppealID string `json:"appealId"`
+	Overturn bool   `json:"overturn"`
+	Reason   string `json:"reason"`
+}
+
+type SanctionParticipantInput struct {
+	RoomID          *string          `json:"roomId"`
+	Participant     ParticipantInput `json:"participant"`
+	Type            string           `json:"type"`
+	Reason          string           `json:"reason"`
+	DurationMinutes *int             `json:"durationMinutes"`
+}
+
+type SetSlowModeInput struct {
+	RoomID          string `json:"roomId"`
+	IntervalSeconds int    `json:"intervalSeconds"`
+	Reason          string `json:"reason"`
+}
+
+type LiftSanctionInput struct {
+	SanctionID string `json:"sanctionId"`
+	Reason     string `json:"reason"`
+}
+
+type RequestChatExportInput struct {
+	RoomID string     `json:"roomId"`
+	From   *time.Time `json:"from"`
+	To     *time.Time `json:"to"`
+}
+
 type FileDownload struct {
 	Data     []byte `json:"data"`
 	Filename string `json:"filename"`
 }
 
+// ExportDownload is a decrypted export archive with the hash and signature recorded when it was built
+type ExportDownload struct {
+	Data      []byte `json:"data"`
+	Filename  string `json:"filename"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
 type MessageStats struct {
 	TotalMessages int `json:"totalMessages"`
 	TodayMessages int `json:"todayMessages"`
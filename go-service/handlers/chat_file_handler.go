@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"my-property/go-service/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChatService serves chat attachment previews; set when the chat module is initialized
+var ChatService *services.ChatService
+
+// ServeAttachmentThumbnail returns the decrypted preview of a chat attachment behind a signed URL
+func ServeAttachmentThumbnail(c *gin.Context) {
+	if ChatService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Chat service not initialized"})
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment id"})
+		return
+	}
+
+	data, err := ChatService.ReadThumbnail(uint(attachmentID), c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=900")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
@@ -1,16 +1,29 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"my-property/go-service/database"
 	"my-property/go-service/models"
+	"my-property/go-service/services"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+// UploadPolicy validates listing images before they are published; set during startup
+var UploadPolicy *services.UploadPolicyEngine
+
+// uploadStagingDir holds uploads while they are inspected; unlike storage it is not served
+const uploadStagingDir = "./uploads/staging"
+
+// StorageQuotas charges listing images to the building's company and developer; set during startup
+var StorageQuotas *services.StorageQuotaService
+
 func UploadPropertyImages(c *gin.Context) {
 	propertyID := c.Param("id")
 
@@ -33,29 +46,92 @@ func UploadPropertyImages(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create storage directory"})
 		return
 	}
+	// Uploads wait outside the public storage directory until they have been inspected
+	if err := os.MkdirAll(uploadStagingDir, os.ModePerm); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create staging directory"})
+		return
+	}
+
+	quotaOwners := services.BuildingQuotaOwners(property)
+
+	// Files staged, published and quota reserved so far; a failure on any file undoes the whole upload
+	var staged, published []string
+	var reserved []int64
+	defer func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}()
+	abort := func(status int, message string) {
+		for _, path := range published {
+			os.Remove(path)
+		}
+		if StorageQuotas != nil {
+			for _, size := range reserved {
+				if err := StorageQuotas.Release(database.DB, quotaOwners, size); err != nil {
+					fmt.Printf("Failed to release storage quota: %v\n", err)
+				}
+			}
+		}
+		c.JSON(status, gin.H{"error": message})
+	}
 
 	var newImages []models.PropertyImage
 	for _, file := range files {
 		filename := filepath.Base(file.Filename)
-		// Use a public-facing URL path
-		urlPath := filepath.Join("/storage", "properties", propertyID, filename)
-		// And a corresponding filesystem path to save the file
-		fsPath := filepath.Join("storage", "properties", propertyID, filename)
+		// Server-generated names keep uploads from replacing each other or existing images
+		storedName := uuid.NewString() + strings.ToLower(filepath.Ext(filename))
+		stagingPath := filepath.Join(uploadStagingDir, storedName)
 
-		if err := c.SaveUploadedFile(file, fsPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("upload file err: %s", err.Error())})
+		if err := c.SaveUploadedFile(file, stagingPath); err != nil {
+			abort(http.StatusInternalServerError, fmt.Sprintf("upload file err: %s", err.Error()))
 			return
 		}
+		staged = append(staged, stagingPath)
+
+		if UploadPolicy != nil {
+			if _, err := UploadPolicy.Inspect(services.UploadContextListing, propertyID, filename, stagingPath); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, services.ErrFileTypeNotAllowed) || errors.Is(err, services.ErrFileTooLarge) || errors.Is(err, services.ErrFileInfected) {
+					status = http.StatusUnprocessableEntity
+				}
+				abort(status, fmt.Sprintf("upload rejected: %s", err.Error()))
+				return
+			}
+		}
+
+		if StorageQuotas != nil {
+			if err := StorageQuotas.Reserve(quotaOwners, file.Size); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, services.ErrQuotaExceeded) {
+					status = http.StatusRequestEntityTooLarge
+				}
+				abort(status, fmt.Sprintf("upload rejected: %s", err.Error()))
+				return
+			}
+			reserved = append(reserved, file.Size)
+		}
 
 		newImage := models.PropertyImage{
 			PropertyID: property.ID,
-			URL:        urlPath,
+			// Use a public-facing URL path
+			URL: filepath.Join("/storage", "properties", propertyID, storedName),
 		}
 		newImages = append(newImages, newImage)
 	}
 
+	// Every file passed inspection, so they can be published
+	for _, stagingPath := range staged {
+		fsPath := filepath.Join(storagePath, filepath.Base(stagingPath))
+		if err := os.Rename(stagingPath, fsPath); err != nil {
+			abort(http.StatusInternalServerError, fmt.Sprintf("upload file err: %s", err.Error()))
+			return
+		}
+		published = append(published, fsPath)
+	}
+
 	if err := database.DB.Create(&newImages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image records to database"})
+		abort(http.StatusInternalServerError, "Failed to save image records to database")
 		return
 	}
 
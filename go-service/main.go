@@ -7,10 +7,14 @@ import (
 	"my-property/go-service/database"
 	"my-property/go-service/graphql"
 	"my-property/go-service/handlers"
+	"my-property/go-service/presence"
 	"my-property/go-service/services"
 	"my-property/go-service/utils"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -61,10 +65,65 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 2. Execute the subscription
+	// 2. Track presence for identified clients while the connection stays open
+	user, identified := websocketUser(r)
+	var connID uint64
+	// Heartbeats stop before the connection is removed, so a late one cannot bring it back
+	var heartbeats sync.WaitGroup
+	if identified {
+		connID = presence.GetInstance().Connect(user)
+		defer func() {
+			cancel()
+			heartbeats.Wait()
+			presence.GetInstance().Disconnect(user, connID)
+		}()
+	}
+
+	// Any further client frame counts as a heartbeat; a read error means the client went away
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		defer cancel()
+		for {
+			if _, _, err := c.Read(ctx); err != nil {
+				return
+			}
+			if identified {
+				presence.GetInstance().Heartbeat(user, connID)
+			}
+		}
+	}()
+
+	// Idle clients send no frames, so the server pings them and counts each pong as a heartbeat
+	if identified {
+		heartbeats.Add(1)
+		go func() {
+			defer heartbeats.Done()
+			defer cancel()
+			interval := presence.GetInstance().HeartbeatInterval()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					pingCtx, pingCancel := context.WithTimeout(ctx, interval)
+					err := c.Ping(pingCtx)
+					pingCancel()
+					if err != nil {
+						return
+					}
+					presence.GetInstance().Heartbeat(user, connID)
+				}
+			}
+		}()
+	}
+
+	// 3. Execute the subscription
 	ch := graphql.ExecuteSubscription(req.Query, req.Variables)
 
-	// 3. Forward events to client
+	// 4. Forward events to client
 	for {
 		select {
 		case <-ctx.Done():
@@ -86,6 +145,16 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// websocketUser reads the caller from the same headers AuthMiddleware uses
+func websocketUser(r *http.Request) (presence.User, bool) {
+	userType := r.Header.Get("X-User-Type")
+	userID, err := strconv.ParseUint(r.Header.Get("X-User-Id"), 10, 32)
+	if err != nil || userType == "" {
+		return presence.User{}, false
+	}
+	return presence.User{ID: uint(userID), Type: userType}, true
+}
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -102,10 +171,15 @@ func main() {
 	}
 	encryptionService := utils.NewEncryptionService(encryptionKey)
 	financialService := services.NewFinancialService(database.DB, encryptionService)
+	handlers.UploadPolicy = services.NewUploadPolicyEngine(database.DB, encryptionService, utils.NewVirusScannerFromEnv())
+	handlers.StorageQuotas = services.NewStorageQuotaService(database.DB)
 
 	// Initialize GraphQL resolvers with services
 	graphql.InitializeResolvers(financialService)
 
+	// Chat also relays chat and financial events from the outbox
+	graphql.InitChatModule(encryptionService)
+
 	router := gin.Default()
 
 	// GraphQL endpoint
@@ -115,6 +189,9 @@ func main() {
 	// REST endpoint for file upload
 	router.POST("/properties/:id/upload", handlers.UploadPropertyImages)
 
+	// Signed thumbnail URLs for encrypted chat attachments
+	router.GET("/chat/attachments/:id/thumbnail", handlers.ServeAttachmentThumbnail)
+
 	// Static file serving for property images
 	router.Static("/storage", "./storage")
 
@@ -13,21 +13,43 @@ type ChatRoom struct {
 	CreatedBy   uint      `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Listing an inquiry room was started from, empty for ordinary rooms
+	ListingType string `gorm:"index:idx_chat_room_listing" json:"listing_type"` // "property", "building"
+	ListingID   *uint  `gorm:"index:idx_chat_room_listing" json:"listing_id"`
 	
 	// Relationships
 	Participants []ChatParticipant `gorm:"foreignKey:RoomID" json:"participants"`
 	Messages     []ChatMessage     `gorm:"foreignKey:RoomID" json:"messages"`
+
+	// Computed per caller on room lists, not stored
+	UnreadCount int          `gorm:"-" json:"unread_count"`
+	LastMessage *ChatMessage `gorm:"-" json:"last_message"`
 }
 
+// Chat participant roles, from most to least privileged
+const (
+	ChatRoleOwner     = "owner"
+	ChatRoleAdmin     = "admin"
+	ChatRoleModerator = "moderator"
+	ChatRoleMember    = "member"
+)
+
 // ChatParticipant represents a user in a chat room
 type ChatParticipant struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	RoomID    uint      `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	UserType  string    `json:"user_type"` // "user", "company", "developer"
-	Role      string    `json:"role"`      // "admin", "member", "moderator"
-	JoinedAt  time.Time `json:"joined_at"`
-	IsActive  bool      `json:"is_active"`
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	RoomID    uint       `gorm:"index:idx_chat_participant_member" json:"room_id"`
+	UserID    uint       `gorm:"index:idx_chat_participant_member" json:"user_id"`
+	UserType  string     `gorm:"index:idx_chat_participant_member" json:"user_type"` // "user", "company", "developer"
+	Role      string     `json:"role"`                                                // "owner", "admin", "moderator", "member"
+	JoinedAt  time.Time  `json:"joined_at"`
+	IsActive  bool       `json:"is_active"`
+	InvitedBy *uint      `json:"invited_by"`
+	LeftAt    *time.Time `json:"left_at"`
+
+	// Read receipts
+	LastReadMessageID *uint      `json:"last_read_message_id"` // Newest message the participant has seen
+	LastReadAt        *time.Time `json:"last_read_at"`
 	
 	// Relationships
 	Room ChatRoom `gorm:"foreignKey:RoomID" json:"room"`
@@ -36,40 +58,103 @@ type ChatParticipant struct {
 // ChatMessage represents a message in a chat room
 type ChatMessage struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
-	RoomID      uint      `json:"room_id"`
+	RoomID      uint      `gorm:"index" json:"room_id"`
 	SenderID    uint      `json:"sender_id"`
 	SenderType  string    `json:"sender_type"` // "user", "company", "developer"
 	Content     string    `json:"content"`
-	MessageType string    `json:"message_type"` // "text", "file", "image", "emoji"
+	MessageType string    `json:"message_type"` // "text", "file", "image", "emoji", "listing_card"
 	ReplyToID   *uint     `json:"reply_to_id"`  // For reply messages
 	ReferenceID *uint     `json:"reference_id"` // For referenced messages
+	ThreadRootID *uint      `gorm:"index" json:"thread_root_id"` // Top-level message of the thread this reply belongs to
+	ThreadOnly   bool       `json:"thread_only"`                 // Reply is hidden from the main room timeline
+	ReplyCount   int        `json:"reply_count"`                 // Replies in the thread, set on root messages
+	LastReplyAt  *time.Time `json:"last_reply_at"`
 	IsEdited    bool      `json:"is_edited"`
 	IsDeleted   bool      `json:"is_deleted"`
 	IsModerated bool      `json:"is_moderated"` // Whether message was checked by AI
-	ModerationStatus string `json:"moderation_status"` // "pending", "approved", "flagged", "blocked"
+	ModerationStatus string `json:"moderation_status"` // "pending", "approved", "flagged", "blocked", "hidden"
+	ModerationAttempts int  `json:"moderation_attempts"` // Failed re-moderation attempts while pending
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	
 	// Relationships
-	Room        ChatRoom           `gorm:"foreignKey:RoomID" json:"room"`
-	Attachments []ChatAttachment   `gorm:"foreignKey:MessageID" json:"attachments"`
-	Reactions   []ChatReaction     `gorm:"foreignKey:MessageID" json:"reactions"`
-	ReplyTo     *ChatMessage       `gorm:"foreignKey:ReplyToID" json:"reply_to"`
-	Referenced  *ChatMessage       `gorm:"foreignKey:ReferenceID" json:"referenced"`
+	Room        ChatRoom            `gorm:"foreignKey:RoomID" json:"room"`
+	Attachments []ChatAttachment    `gorm:"foreignKey:MessageID" json:"attachments"`
+	Reactions   []ChatReaction      `gorm:"foreignKey:MessageID" json:"reactions"`
+	ReplyTo     *ChatMessage        `gorm:"foreignKey:ReplyToID" json:"reply_to"`
+	Referenced  *ChatMessage        `gorm:"foreignKey:ReferenceID" json:"referenced"`
+	Entities    []ChatMessageEntity `gorm:"foreignKey:MessageID" json:"entities"`
+}
+
+// ChatPinnedMessage is a message pinned to the top of a room, ordered by Position
+type ChatPinnedMessage struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	RoomID     uint      `gorm:"uniqueIndex:idx_chat_pin_message" json:"room_id"`
+	MessageID  uint      `gorm:"uniqueIndex:idx_chat_pin_message" json:"message_id"`
+	Position   int       `json:"position"` // 0 is shown first
+	PinnedBy   uint      `json:"pinned_by"`
+	PinnerType string    `json:"pinner_type"`
+	PinnedAt   time.Time `json:"pinned_at"`
+
+	// Relationships
+	Message ChatMessage `gorm:"foreignKey:MessageID" json:"message"`
+}
+
+// ChatMessageRevision keeps a superseded version of an edited message
+type ChatMessageRevision struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	MessageID        uint      `gorm:"uniqueIndex:idx_message_revision" json:"message_id"`
+	Revision         int       `gorm:"uniqueIndex:idx_message_revision" json:"revision"` // 1 is the original content
+	Content          string    `json:"content"`
+	ModerationStatus string    `json:"moderation_status"` // Status the content had before it was replaced
+	EditedBy         uint      `json:"edited_by"`
+	EditorType       string    `json:"editor_type"`
+	CreatedAt        time.Time `json:"created_at"` // When this version was replaced
+}
+
+// ChatMessageEntity marks a structured span inside a message's content, such as a mention
+type ChatMessageEntity struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	MessageID  uint   `gorm:"index" json:"message_id"`
+	Type       string `json:"type"`        // "mention"
+	Offset     int    `json:"offset"`      // Start of the span, in characters
+	Length     int    `json:"length"`      // Span length, in characters
+	Token      string `json:"token"`       // Raw text, e.g. "@company:12"
+	TargetType string `json:"target_type"` // "user", "company", "developer", "here", "all"
+	TargetID   *uint  `json:"target_id"`   // Mentioned participant, nil for @here and @all
+}
+
+// ChatMention records that a participant was mentioned by a message, directly or via @here/@all
+type ChatMention struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"index" json:"message_id"`
+	RoomID    uint      `json:"room_id"`
+	UserID    uint      `gorm:"index:idx_chat_mention_user" json:"user_id"`
+	UserType  string    `gorm:"index:idx_chat_mention_user" json:"user_type"`
+	Kind      string    `json:"kind"` // "direct", "here", "all"
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	Message ChatMessage `gorm:"foreignKey:MessageID" json:"message"`
 }
 
 // ChatAttachment represents file attachments in messages
 type ChatAttachment struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
 	MessageID   uint      `json:"message_id"`
+	FolderID    *uint     `gorm:"index" json:"folder_id"` // Folder the file is filed under, nil for the room root
 	FileName    string    `json:"file_name"`
 	FileType    string    `json:"file_type"`
 	FileSize    int64     `json:"file_size"`
 	EncryptedPath string  `json:"encrypted_path"` // Encrypted file path
 	EncryptedKey  string  `json:"encrypted_key"`  // Encrypted file encryption key
+	ContentHash   string  `gorm:"type:varchar(64);index" json:"content_hash"` // SHA-256 of the plaintext
+	ThumbnailPath string  `json:"thumbnail_path"` // Encrypted JPEG preview, empty when none was generated
+	ThumbnailKey  string  `json:"thumbnail_key"`  // Encrypted thumbnail encryption key
 	UploadedAt  time.Time `json:"uploaded_at"`
 	IsModerated bool      `json:"is_moderated"` // Whether file was checked by AI
-	ModerationStatus string `json:"moderation_status"` // "pending", "approved", "flagged", "blocked"
+	ModerationStatus string `json:"moderation_status"` // "pending", "approved", "flagged", "blocked", "hidden", "removed"
+	ModerationAttempts int  `json:"moderation_attempts"` // Failed re-moderation attempts while pending
 	
 	// Relationships
 	Message ChatMessage `gorm:"foreignKey:MessageID" json:"message"`
@@ -112,7 +197,8 @@ type ChatNotification struct {
 	UserID    uint      `json:"user_id"`
 	UserType  string    `json:"user_type"`
 	RoomID    uint      `json:"room_id"`
-	Type      string    `json:"type"` // "message", "mention", "reaction", "file_upload"
+	Type      string    `json:"type"` // "message", "mention", "reaction", "file_upload", "thread_reply", "moderation"
+	MessageID *uint     `json:"message_id"` // Message the notification is about, if any
 	Message   string    `json:"message"`
 	IsRead    bool      `json:"is_read"`
 	CreatedAt time.Time `json:"created_at"`
@@ -133,4 +219,85 @@ type ChatModerationLog struct {
 	Severity  string    `json:"severity"` // "low", "medium", "high"
 	Flagged   bool      `json:"flagged"`
 	CreatedAt time.Time `json:"created_at"`
-} 
\ No newline at end of file
+
+	RuleID       string `gorm:"index" json:"rule_id"`    // Local rule that decided or flagged the content, empty for AI decisions
+	MessageID    *uint  `gorm:"index" json:"message_id"` // Message the content belongs to, nil when a new message was blocked
+	AttachmentID *uint  `json:"attachment_id"`
+	ContentType  string `json:"content_type"`               // "text", "file"
+	ReviewStatus string `gorm:"index" json:"review_status"` // "pending", "resolved"; empty when nothing needs review
+
+	// Relationships
+	Decisions []ChatModerationDecision `gorm:"foreignKey:LogID" json:"decisions"`
+}
+
+// ChatModerationDecision records a moderator's action on a moderation log entry
+type ChatModerationDecision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	LogID     uint      `gorm:"index" json:"log_id"`
+	AppealID  *uint     `json:"appeal_id"` // Set when the decision resolves an appeal
+	Action    string    `json:"action"`    // "approve", "hide", "delete", "appeal_upheld", "appeal_overturned"
+	ActorID   uint      `json:"actor_id"`
+	ActorType string    `json:"actor_type"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatModerationAppeal is a user's request to reverse a block or a moderator's decision
+type ChatModerationAppeal struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	LogID        uint       `gorm:"index" json:"log_id"`
+	RoomID       uint       `gorm:"index" json:"room_id"`
+	UserID       uint       `json:"user_id"`
+	UserType     string     `json:"user_type"`
+	Reason       string     `json:"reason"`
+	Status       string     `json:"status"` // "pending", "upheld", "overturned"
+	ResolvedBy   *uint      `json:"resolved_by"`
+	ResolverType string     `json:"resolver_type"`
+	Resolution   string     `json:"resolution"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ResolvedAt   *time.Time `json:"resolved_at"`
+
+	// Relationships
+	Log ChatModerationLog `gorm:"foreignKey:LogID" json:"log"`
+}
+
+// ChatSanction restricts a participant in one room, or on the whole platform when RoomID is nil.
+// Slow mode is a room-wide sanction with no target user.
+type ChatSanction struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	RoomID          *uint      `gorm:"index" json:"room_id"`
+	UserID          uint       `gorm:"index:idx_chat_sanction_user" json:"user_id"` // Zero for slow mode
+	UserType        string     `gorm:"index:idx_chat_sanction_user" json:"user_type"`
+	Type            string     `json:"type"` // "mute", "kick", "ban", "slow_mode"
+	IntervalSeconds int        `json:"interval_seconds"` // Minimum gap between a member's messages in slow mode
+	Reason          string     `json:"reason"`
+	IssuedBy        *uint      `json:"issued_by"`   // Nil for automatic escalation
+	IssuerType      string     `json:"issuer_type"` // "system" for automatic escalation
+	ExpiresAt       *time.Time `json:"expires_at"`  // Nil for sanctions that last until lifted
+	CreatedAt       time.Time  `json:"created_at"`
+	LiftedAt        *time.Time `json:"lifted_at"`
+	LiftedBy        *uint      `json:"lifted_by"`
+	LifterType      string     `json:"lifter_type"`
+	LiftReason      string     `json:"lift_reason"`
+}
+
+// ChatExportJob tracks an export of a room's history to a signed ZIP archive
+type ChatExportJob struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	RoomID          uint       `gorm:"index" json:"room_id"`
+	RequestedBy     uint       `json:"requested_by"`
+	RequesterType   string     `json:"requester_type"`
+	From            *time.Time `json:"from"`   // Start of the exported range, nil for the beginning of the room
+	To              *time.Time `json:"to"`     // End of the exported range, nil for now
+	Status          string     `json:"status"` // "pending", "running", "completed", "failed"
+	Error           string     `json:"error"`
+	ArchivePath     string     `json:"-"` // Encrypted ZIP
+	ArchiveKey      string     `json:"-"`
+	ArchiveHash     string     `gorm:"type:varchar(64)" json:"archive_hash"` // SHA-256 of the ZIP
+	Signature       string     `json:"signature"`                            // HMAC over ArchiveHash
+	MessageCount    int        `json:"message_count"`
+	AttachmentCount int        `json:"attachment_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// DeadLetter is a Kafka message a handler gave up on, collected from a dead-letter topic for inspection
+type DeadLetter struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	DLQTopic          string     `gorm:"type:varchar(150);uniqueIndex:idx_dead_letter_position;not null" json:"dlq_topic"`
+	DLQPartition      int32      `gorm:"uniqueIndex:idx_dead_letter_position" json:"dlq_partition"`
+	DLQOffset         int64      `gorm:"uniqueIndex:idx_dead_letter_position" json:"dlq_offset"`
+	Topic             string     `gorm:"type:varchar(150);index;not null" json:"topic"` // Topic the message was consumed from
+	OriginalPartition int32      `json:"original_partition"`
+	OriginalOffset    int64      `json:"original_offset"`
+	MessageKey        string     `json:"message_key"`
+	Payload           string     `gorm:"type:text" json:"payload"`
+	Encoding          string     `gorm:"type:varchar(10)" json:"encoding"` // "" for text, "base64" for key and payload that are not UTF-8
+	EventID           string     `gorm:"type:varchar(200)" json:"event_id"`
+	EventType         string     `gorm:"type:varchar(100)" json:"event_type"`
+	Handler           string     `gorm:"type:varchar(100)" json:"handler"` // Empty when the payload could not be decoded
+	Error             string     `gorm:"type:text" json:"error"`
+	Attempts          int        `json:"attempts"`
+	Status            string     `gorm:"type:varchar(20);index;default:'pending'" json:"status"` // "pending", "replayed", "discarded"
+	ReplayCount       int        `gorm:"default:0" json:"replay_count"`
+	ResolvedBy        *uint      `json:"resolved_by"`
+	ResolverType      string     `json:"resolver_type"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	FailedAt          time.Time  `json:"failed_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
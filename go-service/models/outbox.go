@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// OutboxEvent is a Kafka event written in the same transaction as the change it describes and published later by the relay
+type OutboxEvent struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	IdempotencyKey string     `gorm:"type:varchar(200);uniqueIndex;not null" json:"idempotency_key"` // Sent as the event id so consumers can drop duplicates
+	Topic          string     `gorm:"type:varchar(100);not null" json:"topic"`
+	PartitionKey   string     `gorm:"type:varchar(100);index:idx_outbox_partition" json:"partition_key"` // Events with the same key are published in order
+	EventType      string     `gorm:"type:varchar(100);not null" json:"event_type"`
+	SchemaVersion  int        `gorm:"default:1" json:"schema_version"`
+	Payload        string     `gorm:"type:jsonb;not null" json:"payload"`
+	TraceParent    string     `gorm:"type:varchar(55)" json:"trace_parent"` // W3C traceparent of the change
+	Tenant         string     `gorm:"type:varchar(100)" json:"tenant"`
+	Status         string     `gorm:"type:varchar(20);index:idx_outbox_due;default:'pending'" json:"status"` // "pending", "sent"
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	LastError      string     `gorm:"type:text" json:"last_error"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_outbox_due" json:"next_attempt_at"`
+	SentAt         *time.Time `json:"sent_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ProcessedEvent records that a consumer handled an event, so a redelivered event is not handled twice
+// even after a restart or by another replica
+type ProcessedEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Consumer       string    `gorm:"type:varchar(200);uniqueIndex:idx_processed_event;not null" json:"consumer"` // Consumer group and handler name
+	IdempotencyKey string    `gorm:"type:varchar(200);uniqueIndex:idx_processed_event;not null" json:"idempotency_key"`
+	ProcessedAt    time.Time `gorm:"index" json:"processed_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+)
+
+// StoredBlob represents a content-addressed encrypted file shared by attachments and documents
+type StoredBlob struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	ContentHash   string    `gorm:"type:varchar(64);uniqueIndex;not null" json:"content_hash"` // SHA-256 of the plaintext
+	EncryptedPath string    `gorm:"type:text;not null" json:"encrypted_path"`
+	EncryptedKey  string    `gorm:"type:text;not null" json:"encrypted_key"`
+	Size          int64     `json:"size"`
+	RefCount      int       `gorm:"default:0" json:"ref_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// QuarantinedFile represents an upload that failed the malware scan and was isolated
+type QuarantinedFile struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Context       string    `gorm:"type:varchar(50)" json:"context"` // "chat", "listing", "lease", "transaction"
+	ReferenceID   string    `json:"reference_id"`                    // Message, property, lease or transaction the file was uploaded to
+	FileName      string    `json:"file_name"`
+	ContentType   string    `json:"content_type"`
+	FileSize      int64     `json:"file_size"`
+	ContentHash   string    `gorm:"type:varchar(64);index" json:"content_hash"`
+	Signature     string    `json:"signature"` // Malware signature reported by the scanner
+	EncryptedPath string    `gorm:"type:text" json:"encrypted_path"`
+	EncryptedKey  string    `gorm:"type:text" json:"encrypted_key"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// StorageQuota tracks the storage limit and current usage of a company, developer or chat room
+type StorageQuota struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	OwnerType  string    `gorm:"type:varchar(20);uniqueIndex:idx_storage_quota_owner;not null" json:"owner_type"` // "company", "developer", "room"
+	OwnerID    uint      `gorm:"uniqueIndex:idx_storage_quota_owner;not null" json:"owner_id"`
+	LimitBytes int64     `gorm:"default:0" json:"limit_bytes"` // 0 = unlimited
+	UsedBytes  int64     `gorm:"default:0" json:"used_bytes"`
+	FileCount  int       `gorm:"default:0" json:"file_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// RetentionRule deletes chat attachments older than MaxAgeDays in matching rooms
+type RetentionRule struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `json:"name"`
+	RoomType   string     `gorm:"type:varchar(20)" json:"room_type"` // Applies to every room of this type when set
+	RoomID     *uint      `json:"room_id"`                           // Applies to a single room when set
+	MaxAgeDays int        `gorm:"not null" json:"max_age_days"`
+	IsActive   bool       `gorm:"default:true" json:"is_active"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,256 @@
+package presence
+
+import (
+	"fmt"
+	"my-property/go-service/pubsub"
+	"sync"
+	"time"
+)
+
+// Pubsub topics presence and typing events are fanned out on
+const PresenceTopic = "CHAT_PRESENCE"
+
+func TypingTopic(roomID uint) string {
+	return fmt.Sprintf("CHAT_TYPING_%d", roomID)
+}
+
+// User identifies a connected participant of any type
+type User struct {
+	ID   uint   `json:"user_id"`
+	Type string `json:"user_type"` // "user", "company", "developer"
+}
+
+// Event reports a user coming online or going offline
+type Event struct {
+	UserID   uint      `json:"user_id"`
+	UserType string    `json:"user_type"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// TypingEvent reports a user starting or stopping typing in a room
+type TypingEvent struct {
+	RoomID   uint      `json:"room_id"`
+	UserID   uint      `json:"user_id"`
+	UserType string    `json:"user_type"`
+	IsTyping bool      `json:"is_typing"`
+	At       time.Time `json:"at"`
+}
+
+type typingKey struct {
+	roomID uint
+	user   User
+}
+
+// Tracker keeps presence and typing state in memory only; entries expire unless refreshed.
+// State and events stay in this process: with several replicas, a user connected to another replica
+// shows offline here and their typing events are not delivered to clients connected here.
+type Tracker struct {
+	mu          sync.Mutex
+	presenceTTL time.Duration
+	typingTTL   time.Duration
+	connections map[User]map[uint64]time.Time // connection id -> last heartbeat
+	lastSeen    map[User]time.Time
+	typing      map[typingKey]time.Time // expiry
+	nextConnID  uint64
+}
+
+var (
+	tracker *Tracker
+	once    sync.Once
+)
+
+func GetInstance() *Tracker {
+	once.Do(func() {
+		tracker = NewTracker(60*time.Second, 6*time.Second)
+		go tracker.sweep(time.Second)
+	})
+	return tracker
+}
+
+func NewTracker(presenceTTL, typingTTL time.Duration) *Tracker {
+	return &Tracker{
+		presenceTTL: presenceTTL,
+		typingTTL:   typingTTL,
+		connections: make(map[User]map[uint64]time.Time),
+		lastSeen:    make(map[User]time.Time),
+		typing:      make(map[typingKey]time.Time),
+	}
+}
+
+// Connect registers a new connection for user and returns its id; the first connection marks the user online
+func (t *Tracker) Connect(user User) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextConnID++
+	connID := t.nextConnID
+	now := time.Now()
+
+	conns, ok := t.connections[user]
+	if !ok {
+		conns = make(map[uint64]time.Time)
+		t.connections[user] = conns
+	}
+	conns[connID] = now
+	t.lastSeen[user] = now
+
+	if len(conns) == 1 {
+		t.publishPresence(user, true, now)
+	}
+
+	return connID
+}
+
+// Heartbeat keeps a connection alive for another TTL. A connection that already expired is registered again,
+// so a client that was idle for a while comes back online.
+func (t *Tracker) Heartbeat(user User, connID uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	conns, ok := t.connections[user]
+	if !ok {
+		conns = make(map[uint64]time.Time)
+		t.connections[user] = conns
+	}
+	conns[connID] = now
+	t.lastSeen[user] = now
+
+	if !ok {
+		t.publishPresence(user, true, now)
+	}
+}
+
+// HeartbeatInterval is how often a connection should send a heartbeat to stay online
+func (t *Tracker) HeartbeatInterval() time.Duration {
+	return t.presenceTTL / 3
+}
+
+// Disconnect removes a connection; the last one going away marks the user offline
+func (t *Tracker) Disconnect(user User, connID uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns, ok := t.connections[user]
+	if !ok {
+		return
+	}
+	if _, ok := conns[connID]; !ok {
+		return
+	}
+	delete(conns, connID)
+
+	if len(conns) == 0 {
+		t.goOffline(user, time.Now())
+	}
+}
+
+// Snapshot returns the current presence of each requested user
+func (t *Tracker) Snapshot(users []User) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := make([]Event, 0, len(users))
+	for _, user := range users {
+		_, online := t.connections[user]
+		events = append(events, Event{
+			UserID:   user.ID,
+			UserType: user.Type,
+			Online:   online,
+			LastSeen: t.lastSeen[user],
+		})
+	}
+	return events
+}
+
+// SetTyping records that user is (or stopped) typing in a room.
+// Repeated keystrokes only extend the TTL; an event is published when the state changes.
+func (t *Tracker) SetTyping(roomID uint, user User, isTyping bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := typingKey{roomID: roomID, user: user}
+	now := time.Now()
+	_, wasTyping := t.typing[key]
+
+	if isTyping {
+		t.typing[key] = now.Add(t.typingTTL)
+		if !wasTyping {
+			t.publishTyping(key, true, now)
+		}
+		return
+	}
+
+	if wasTyping {
+		delete(t.typing, key)
+		t.publishTyping(key, false, now)
+	}
+}
+
+// sweep expires stale connections and typing indicators every interval
+func (t *Tracker) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.expire(now)
+	}
+}
+
+func (t *Tracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for user, conns := range t.connections {
+		for connID, heartbeat := range conns {
+			if now.Sub(heartbeat) > t.presenceTTL {
+				delete(conns, connID)
+			}
+		}
+		if len(conns) == 0 {
+			t.goOffline(user, t.lastSeen[user])
+		}
+	}
+
+	for key, expiry := range t.typing {
+		if now.After(expiry) {
+			delete(t.typing, key)
+			t.publishTyping(key, false, now)
+		}
+	}
+}
+
+// goOffline must be called with the lock held
+func (t *Tracker) goOffline(user User, lastSeen time.Time) {
+	delete(t.connections, user)
+	t.lastSeen[user] = lastSeen
+	t.publishPresence(user, false, lastSeen)
+
+	// An offline user is no longer typing anywhere
+	for key := range t.typing {
+		if key.user == user {
+			delete(t.typing, key)
+			t.publishTyping(key, false, lastSeen)
+		}
+	}
+}
+
+func (t *Tracker) publishPresence(user User, online bool, at time.Time) {
+	pubsub.GetInstance().Publish(PresenceTopic, &Event{
+		UserID:   user.ID,
+		UserType: user.Type,
+		Online:   online,
+		LastSeen: at,
+	})
+}
+
+func (t *Tracker) publishTyping(key typingKey, isTyping bool, at time.Time) {
+	pubsub.GetInstance().Publish(TypingTopic(key.roomID), &TypingEvent{
+		RoomID:   key.roomID,
+		UserID:   key.user.ID,
+		UserType: key.user.Type,
+		IsTyping: isTyping,
+		At:       at,
+	})
+}
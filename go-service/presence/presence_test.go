@@ -0,0 +1,156 @@
+package presence
+
+import (
+	"my-property/go-service/pubsub"
+	"testing"
+	"time"
+)
+
+const testTTL = time.Minute
+
+var (
+	alice = User{ID: 1, Type: "user"}
+	acme  = User{ID: 1, Type: "company"}
+)
+
+func online(t *testing.T, tracker *Tracker, user User) bool {
+	t.Helper()
+	return tracker.Snapshot([]User{user})[0].Online
+}
+
+func TestTrackerPresenceExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(tracker *Tracker) // Runs at time.Now()
+		after time.Duration          // When expire runs, relative to now
+		want  map[User]bool          // Who is online afterwards
+	}{
+		{"fresh connection stays online", func(tracker *Tracker) {
+			tracker.Connect(alice)
+		}, testTTL / 2, map[User]bool{alice: true}},
+		{"idle connection expires", func(tracker *Tracker) {
+			tracker.Connect(alice)
+		}, testTTL + time.Second, map[User]bool{alice: false}},
+		{"users of different types are separate", func(tracker *Tracker) {
+			tracker.Connect(alice)
+		}, 0, map[User]bool{alice: true, acme: false}},
+		{"last disconnect goes offline", func(tracker *Tracker) {
+			first := tracker.Connect(alice)
+			second := tracker.Connect(alice)
+			tracker.Disconnect(alice, first)
+			tracker.Disconnect(alice, second)
+		}, 0, map[User]bool{alice: false}},
+		{"other connection keeps the user online", func(tracker *Tracker) {
+			first := tracker.Connect(alice)
+			tracker.Connect(alice)
+			tracker.Disconnect(alice, first)
+		}, 0, map[User]bool{alice: true}},
+		{"disconnecting an unknown connection is ignored", func(tracker *Tracker) {
+			connID := tracker.Connect(alice)
+			tracker.Disconnect(alice, connID+1)
+		}, 0, map[User]bool{alice: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(testTTL, time.Second)
+			tt.setup(tracker)
+			tracker.expire(time.Now().Add(tt.after))
+
+			for user, want := range tt.want {
+				if got := online(t, tracker, user); got != want {
+					t.Errorf("%+v online = %v, want %v", user, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackerHeartbeatAfterExpiry(t *testing.T) {
+	tracker := NewTracker(testTTL, time.Second)
+	events := pubsub.GetInstance().Subscribe(PresenceTopic)
+	defer pubsub.GetInstance().Unsubscribe(PresenceTopic, events)
+
+	connID := tracker.Connect(alice)
+	expectPresence(t, events, true)
+
+	tracker.expire(time.Now().Add(testTTL + time.Second))
+	expectPresence(t, events, false)
+	if online(t, tracker, alice) {
+		t.Fatal("user is online after the connection expired")
+	}
+
+	// The connection is still open, so its next heartbeat brings the user back
+	tracker.Heartbeat(alice, connID)
+	expectPresence(t, events, true)
+	if !online(t, tracker, alice) {
+		t.Fatal("heartbeat did not re-register the expired connection")
+	}
+
+	// A heartbeat on a live connection publishes nothing
+	tracker.Heartbeat(alice, connID)
+	select {
+	case event := <-events:
+		t.Errorf("heartbeat on a live connection published %+v", event)
+	default:
+	}
+
+	tracker.Disconnect(alice, connID)
+	expectPresence(t, events, false)
+}
+
+func TestTrackerTypingExpiry(t *testing.T) {
+	const typingTTL = 5 * time.Second
+
+	tests := []struct {
+		name   string
+		setup  func(tracker *Tracker)
+		after  time.Duration
+		typing bool
+	}{
+		{"typing within the ttl", func(tracker *Tracker) {
+			tracker.SetTyping(7, alice, true)
+		}, typingTTL / 2, true},
+		{"typing expires", func(tracker *Tracker) {
+			tracker.SetTyping(7, alice, true)
+		}, typingTTL + time.Second, false},
+		{"stopped typing", func(tracker *Tracker) {
+			tracker.SetTyping(7, alice, true)
+			tracker.SetTyping(7, alice, false)
+		}, 0, false},
+		{"going offline stops typing", func(tracker *Tracker) {
+			connID := tracker.Connect(alice)
+			tracker.SetTyping(7, alice, true)
+			tracker.Disconnect(alice, connID)
+		}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(testTTL, typingTTL)
+			tt.setup(tracker)
+			tracker.expire(time.Now().Add(tt.after))
+
+			tracker.mu.Lock()
+			_, typing := tracker.typing[typingKey{roomID: 7, user: alice}]
+			tracker.mu.Unlock()
+			if typing != tt.typing {
+				t.Errorf("typing = %v, want %v", typing, tt.typing)
+			}
+		})
+	}
+}
+
+func expectPresence(t *testing.T, events <-chan interface{}, online bool) {
+	t.Helper()
+
+	select {
+	case data := <-events:
+		event, ok := data.(*Event)
+		if !ok || event.UserID != alice.ID || event.UserType != alice.Type || event.Online != online {
+			t.Fatalf("presence event %+v, want %+v online=%v", data, alice, online)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no presence event, want online=%v", online)
+	}
+}
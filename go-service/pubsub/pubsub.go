@@ -10,7 +10,7 @@ type PubSub struct {
 }
 
 var (
-	ps *PubSub
+	ps   *PubSub
 	once sync.Once
 )
 
@@ -38,7 +38,28 @@ func (ps *PubSub) Publish(topic string, data interface{}) {
 
 	if subs, ok := ps.subs[topic]; ok {
 		for _, ch := range subs {
-			ch <- data
+			select {
+			case ch <- data:
+			default:
+				// Subscriber is not keeping up, drop the event rather than block publishers
+			}
 		}
 	}
-} 
\ No newline at end of file
+}
+
+func (ps *PubSub) Unsubscribe(topic string, sub <-chan interface{}) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subs := ps.subs[topic]
+	for i, ch := range subs {
+		if ch == sub {
+			ps.subs[topic] = append(subs[:i], subs[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+	if len(ps.subs[topic]) == 0 {
+		delete(ps.subs, topic)
+	}
+}
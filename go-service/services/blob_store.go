@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"my-property/go-service/utils"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrIntegrityMismatch is returned when decrypted content does not match its recorded hash
+var ErrIntegrityMismatch = errors.New("file integrity check failed")
+
+// BlobStore keeps one encrypted copy of each distinct file content, shared via reference counting
+type BlobStore struct {
+	db                *gorm.DB
+	encryptionService *utils.EncryptionService
+	blobDir           string
+}
+
+func NewBlobStore(db *gorm.DB, encryptionService *utils.EncryptionService) *BlobStore {
+	blobDir := "./uploads/blobs"
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		panic(fmt.Sprintf("Failed to create blob directory: %v", err))
+	}
+
+	return &BlobStore{
+		db:                db,
+		encryptionService: encryptionService,
+		blobDir:           blobDir,
+	}
+}
+
+// Put hashes the plaintext file at sourcePath and takes a reference on the matching blob,
+// encrypting and storing the content only if it has not been seen before
+func (b *BlobStore) Put(tx *gorm.DB, sourcePath string) (*models.StoredBlob, error) {
+	hash, err := utils.HashFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file: %v", err)
+	}
+
+	// Existing content only needs another reference
+	result := tx.Model(&models.StoredBlob{}).
+		Where("content_hash = ?", hash).
+		Updates(map[string]interface{}{
+			"ref_count":  gorm.Expr("ref_count + 1"),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return b.Get(tx, hash)
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedPath := filepath.Join(b.blobDir, fmt.Sprintf("%s_%d.enc", hash, time.Now().UnixNano()))
+	encryptedKey, err := b.encryptionService.EncryptFile(sourcePath, encryptedPath)
+	if err != nil {
+		os.Remove(encryptedPath)
+		return nil, err
+	}
+
+	blob := &models.StoredBlob{
+		ContentHash:   hash,
+		EncryptedPath: encryptedPath,
+		EncryptedKey:  encryptedKey,
+		Size:          info.Size(),
+		RefCount:      1,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	// A concurrent upload of the same content may have won the insert
+	err = tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "content_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("stored_blobs.ref_count + 1"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(blob).Error
+	if err != nil {
+		os.Remove(encryptedPath)
+		return nil, err
+	}
+
+	stored, err := b.Get(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if stored.EncryptedPath != encryptedPath {
+		os.Remove(encryptedPath)
+	}
+
+	return stored, nil
+}
+
+// Get returns the blob stored for a content hash
+func (b *BlobStore) Get(tx *gorm.DB, hash string) (*models.StoredBlob, error) {
+	var blob models.StoredBlob
+	if err := tx.Where("content_hash = ?", hash).First(&blob).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// Release drops one reference to a blob. Once nothing uses it the row is deleted and the path of its encrypted file
+// is returned; remove the file only after tx commits, so a rollback cannot leave rows whose content is gone.
+func (b *BlobStore) Release(tx *gorm.DB, hash string) (string, error) {
+	if hash == "" {
+		return "", nil
+	}
+
+	err := tx.Model(&models.StoredBlob{}).
+		Where("content_hash = ? AND ref_count > 0", hash).
+		Updates(map[string]interface{}{
+			"ref_count":  gorm.Expr("ref_count - 1"),
+			"updated_at": time.Now(),
+		}).Error
+	if err != nil {
+		return "", err
+	}
+
+	blob, err := b.Get(tx, hash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	if blob.RefCount > 0 {
+		return "", nil
+	}
+
+	result := tx.Where("id = ? AND ref_count <= 0", blob.ID).Delete(&models.StoredBlob{})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", nil
+	}
+
+	return blob.EncryptedPath, nil
+}
+
+// removeFiles deletes files whose records are gone; call it after the deleting transaction committed
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove %s: %v\n", path, err)
+		}
+	}
+}
+
+// Verify checks that a decrypted file matches the hash recorded at upload time
+func (b *BlobStore) Verify(path, expectedHash string) error {
+	// Files stored before hashing was introduced have nothing to verify against
+	if expectedHash == "" {
+		return nil
+	}
+
+	hash, err := utils.HashFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %v", err)
+	}
+	if hash != expectedHash {
+		return ErrIntegrityMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,548 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"my-property/go-service/models"
+	"my-property/go-service/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Export job statuses
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// ErrExportNotReady is returned when downloading an export that has not completed
+var ErrExportNotReady = errors.New("export is not ready for download")
+
+const exportDir = "./uploads/exports"
+
+const (
+	exportRequeueAfter = time.Minute   // Pending this long, a job's worker is assumed lost and it is run again
+	exportStaleAfter   = 2 * time.Hour // Running this long, a job's worker is assumed to have died with its process
+)
+
+// ExportManifest describes an export archive; every other file in the ZIP is listed with its SHA-256
+type ExportManifest struct {
+	JobID           uint                 `json:"job_id"`
+	RoomID          uint                 `json:"room_id"`
+	RoomName        string               `json:"room_name"`
+	From            *time.Time           `json:"from,omitempty"`
+	To              *time.Time           `json:"to,omitempty"`
+	RequestedBy     ParticipantRef       `json:"requested_by"`
+	GeneratedAt     time.Time            `json:"generated_at"`
+	MessageCount    int                  `json:"message_count"`
+	AttachmentCount int                  `json:"attachment_count"`
+	Files           []ExportManifestFile `json:"files"`
+	MissingFiles    []string             `json:"missing_files,omitempty"` // Attachments that could not be decrypted or failed verification
+}
+
+type ExportManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// exportMessage is the messages.json and transcript view of a message
+type exportMessage struct {
+	ID          uint               `json:"id"`
+	SenderID    uint               `json:"sender_id"`
+	SenderType  string             `json:"sender_type"`
+	Content     string             `json:"content"`
+	MessageType string             `json:"message_type"`
+	ReplyToID   *uint              `json:"reply_to_id,omitempty"`
+	IsEdited    bool               `json:"is_edited"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Attachments []exportAttachment `json:"attachments,omitempty"`
+	Reactions   map[string]int     `json:"reactions,omitempty"`
+}
+
+type exportAttachment struct {
+	ID       uint   `json:"id"`
+	FileName string `json:"file_name"`
+	FileType string `json:"file_type"`
+	FileSize int64  `json:"file_size"`
+	Path     string `json:"path,omitempty"` // Location inside the archive, empty if the file is missing
+}
+
+// Export Management
+// RequestExport queues an export of a room's history between from and to; only room admins may export
+func (s *ChatService) RequestExport(roomID, userID uint, userType string, from, to *time.Time) (*models.ChatExportJob, error) {
+	if _, err := s.RequireRole(roomID, userID, userType, models.ChatRoleAdmin); err != nil {
+		return nil, err
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, fmt.Errorf("export range start must be before its end")
+	}
+
+	job := &models.ChatExportJob{
+		RoomID:        roomID,
+		RequestedBy:   userID,
+		RequesterType: userType,
+		From:          from,
+		To:            to,
+		Status:        ExportStatusPending,
+		CreatedAt:     time.Now(),
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	go s.runExport(job.ID)
+
+	return job, nil
+}
+
+// GetExportJob returns a job's status to its requester or a room admin
+func (s *ChatService) GetExportJob(jobID, userID uint, userType string) (*models.ChatExportJob, error) {
+	var job models.ChatExportJob
+	if err := s.db.First(&job, jobID).Error; err != nil {
+		return nil, err
+	}
+
+	if job.RequestedBy != userID || job.RequesterType != userType {
+		if _, err := s.RequireRole(job.RoomID, userID, userType, models.ChatRoleAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+func (s *ChatService) GetExportJobs(roomID, userID uint, userType string) ([]models.ChatExportJob, error) {
+	if _, err := s.RequireRole(roomID, userID, userType, models.ChatRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var jobs []models.ChatExportJob
+	err := s.db.Where("room_id = ?", roomID).Order("created_at DESC").Find(&jobs).Error
+	return jobs, err
+}
+
+// DownloadExport decrypts a completed archive after checking it still matches its signed hash
+func (s *ChatService) DownloadExport(jobID, userID uint, userType string) ([]byte, string, *models.ChatExportJob, error) {
+	job, err := s.GetExportJob(jobID, userID, userType)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if job.Status != ExportStatusCompleted {
+		return nil, "", nil, ErrExportNotReady
+	}
+	if !s.encryptionService.VerifyDigest(job.ArchiveHash, job.Signature) {
+		return nil, "", nil, fmt.Errorf("export signature does not match")
+	}
+
+	tempPath := filepath.Join(exportDir, fmt.Sprintf("temp_%d_%d.zip", job.ID, time.Now().UnixNano()))
+	defer os.Remove(tempPath)
+
+	if err := s.encryptionService.DecryptFile(job.ArchivePath, tempPath, job.ArchiveKey); err != nil {
+		return nil, "", nil, err
+	}
+	if err := s.blobStore.Verify(tempPath, job.ArchiveHash); err != nil {
+		return nil, "", nil, err
+	}
+
+	data, err := os.ReadFile(tempPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return data, fmt.Sprintf("room_%d_export_%d.zip", job.RoomID, job.ID), job, nil
+}
+
+// StartExportRecoveryJob finds export jobs a restart left behind every interval until ctx is cancelled
+func (s *ChatService) StartExportRecoveryJob(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				requeued, failed, err := s.RecoverExportJobs()
+				if err != nil {
+					log.Printf("Export recovery job error: %v", err)
+				}
+				if requeued > 0 || failed > 0 {
+					log.Printf("Export recovery job restarted %d and failed %d interrupted exports", requeued, failed)
+				}
+			}
+		}
+	}()
+}
+
+// RecoverExportJobs runs pending jobs again whose worker never started them, and fails jobs that have been
+// running too long to still be alive so their requesters can ask again
+func (s *ChatService) RecoverExportJobs() (int, int, error) {
+	result := s.db.Model(&models.ChatExportJob{}).
+		Where("status = ? AND started_at < ?", ExportStatusRunning, time.Now().Add(-exportStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":       ExportStatusFailed,
+			"error":        "export was interrupted, please request it again",
+			"completed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	failed := int(result.RowsAffected)
+
+	var pending []models.ChatExportJob
+	err := s.db.Where("status = ? AND created_at < ?", ExportStatusPending, time.Now().Add(-exportRequeueAfter)).
+		Find(&pending).Error
+	if err != nil {
+		return 0, failed, err
+	}
+	for _, job := range pending {
+		go s.runExport(job.ID)
+	}
+
+	return len(pending), failed, nil
+}
+
+func (s *ChatService) runExport(jobID uint) {
+	// Claiming the job means only one worker runs it, also when recovery picks it up again
+	startedAt := time.Now()
+	claim := s.db.Model(&models.ChatExportJob{}).
+		Where("id = ? AND status = ?", jobID, ExportStatusPending).
+		Updates(map[string]interface{}{
+			"status":     ExportStatusRunning,
+			"started_at": startedAt,
+		})
+	if claim.Error != nil {
+		fmt.Printf("Failed to start export job %d: %v\n", jobID, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return
+	}
+
+	var job models.ChatExportJob
+	if err := s.db.First(&job, jobID).Error; err != nil {
+		fmt.Printf("Export job %d not found: %v\n", jobID, err)
+		return
+	}
+
+	if err := s.buildExport(&job); err != nil {
+		fmt.Printf("Export job %d failed: %v\n", jobID, err)
+		s.db.Model(&job).Updates(map[string]interface{}{
+			"status":       ExportStatusFailed,
+			"error":        err.Error(),
+			"completed_at": time.Now(),
+		})
+	}
+}
+
+// buildExport writes the ZIP, signs its hash, encrypts it at rest and marks the job completed
+func (s *ChatService) buildExport(job *models.ChatExportJob) error {
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(exportDir, "work_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	var room models.ChatRoom
+	if err := s.db.First(&room, job.RoomID).Error; err != nil {
+		return err
+	}
+
+	query := s.db.Where("room_id = ? AND is_deleted = ?", job.RoomID, false)
+	if job.From != nil {
+		query = query.Where("created_at >= ?", *job.From)
+	}
+	if job.To != nil {
+		query = query.Where("created_at < ?", *job.To)
+	}
+	var messages []models.ChatMessage
+	err = query.Preload("Attachments").
+		Preload("Reactions").
+		Order("created_at ASC").
+		Find(&messages).Error
+	if err != nil {
+		return err
+	}
+
+	zipPath := filepath.Join(workDir, "archive.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	archive := &exportArchive{writer: zip.NewWriter(zipFile)}
+
+	manifest := ExportManifest{
+		JobID:        job.ID,
+		RoomID:       room.ID,
+		RoomName:     room.Name,
+		From:         job.From,
+		To:           job.To,
+		RequestedBy:  ParticipantRef{UserID: job.RequestedBy, UserType: job.RequesterType},
+		GeneratedAt:  time.Now(),
+		MessageCount: len(messages),
+	}
+
+	exported := make([]exportMessage, 0, len(messages))
+	for _, message := range messages {
+		entry := exportMessage{
+			ID:          message.ID,
+			SenderID:    message.SenderID,
+			SenderType:  message.SenderType,
+			Content:     message.Content,
+			MessageType: message.MessageType,
+			ReplyToID:   message.ReplyToID,
+			IsEdited:    message.IsEdited,
+			CreatedAt:   message.CreatedAt,
+		}
+
+		for _, reaction := range message.Reactions {
+			if entry.Reactions == nil {
+				entry.Reactions = make(map[string]int)
+			}
+			entry.Reactions[reaction.Emoji]++
+		}
+
+		for _, attachment := range message.Attachments {
+			item := exportAttachment{
+				ID:       attachment.ID,
+				FileName: attachment.FileName,
+				FileType: attachment.FileType,
+				FileSize: attachment.FileSize,
+			}
+			path := fmt.Sprintf("attachments/%d_%s", attachment.ID, safeArchiveName(attachment.FileName))
+			if err := s.addAttachmentToArchive(archive, workDir, attachment, path); err != nil {
+				fmt.Printf("Export job %d: skipping attachment %d: %v\n", job.ID, attachment.ID, err)
+				manifest.MissingFiles = append(manifest.MissingFiles, path)
+			} else {
+				item.Path = path
+				manifest.AttachmentCount++
+			}
+			entry.Attachments = append(entry.Attachments, item)
+		}
+
+		exported = append(exported, entry)
+	}
+
+	messagesJSON, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archive.addBytes("messages.json", messagesJSON); err != nil {
+		return err
+	}
+
+	htmlPath := filepath.Join(workDir, "transcript.html")
+	if err := renderTranscript(htmlPath, room, manifest, exported); err != nil {
+		return err
+	}
+	if err := archive.addFile("transcript.html", htmlPath); err != nil {
+		return err
+	}
+
+	pdfPath := filepath.Join(workDir, "transcript.pdf")
+	if rendered, err := renderTranscriptPDF(htmlPath, pdfPath); err != nil {
+		fmt.Printf("Export job %d: PDF transcript failed: %v\n", job.ID, err)
+	} else if rendered {
+		if err := archive.addFile("transcript.pdf", pdfPath); err != nil {
+			return err
+		}
+	}
+
+	// The manifest is written last so it can list every other file
+	manifest.Files = archive.files
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archive.addBytes("manifest.json", manifestJSON); err != nil {
+		return err
+	}
+
+	if err := archive.writer.Close(); err != nil {
+		zipFile.Close()
+		return err
+	}
+	if err := zipFile.Close(); err != nil {
+		return err
+	}
+
+	archiveHash, err := utils.HashFile(zipPath)
+	if err != nil {
+		return err
+	}
+
+	encryptedPath := filepath.Join(exportDir, fmt.Sprintf("%d_%d.enc", job.ID, time.Now().UnixNano()))
+	archiveKey, err := s.encryptionService.EncryptFile(zipPath, encryptedPath)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Model(job).Updates(map[string]interface{}{
+		"status":           ExportStatusCompleted,
+		"archive_path":     encryptedPath,
+		"archive_key":      archiveKey,
+		"archive_hash":     archiveHash,
+		"signature":        s.encryptionService.SignDigest(archiveHash),
+		"message_count":    manifest.MessageCount,
+		"attachment_count": manifest.AttachmentCount,
+		"completed_at":     time.Now(),
+	}).Error
+}
+
+// addAttachmentToArchive decrypts an attachment, checks its content hash and copies it into the archive
+func (s *ChatService) addAttachmentToArchive(archive *exportArchive, workDir string, attachment models.ChatAttachment, path string) error {
+	tempPath := filepath.Join(workDir, fmt.Sprintf("attachment_%d", attachment.ID))
+	defer os.Remove(tempPath)
+
+	if err := s.encryptionService.DecryptFile(attachment.EncryptedPath, tempPath, attachment.EncryptedKey); err != nil {
+		return err
+	}
+	if err := s.blobStore.Verify(tempPath, attachment.ContentHash); err != nil {
+		return err
+	}
+
+	return archive.addFile(path, tempPath)
+}
+
+// exportArchive writes ZIP entries and records their sizes and hashes for the manifest
+type exportArchive struct {
+	writer *zip.Writer
+	files  []ExportManifestFile
+}
+
+func (a *exportArchive) addFile(name, sourcePath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return a.add(name, source)
+}
+
+func (a *exportArchive) addBytes(name string, data []byte) error {
+	return a.add(name, strings.NewReader(string(data)))
+}
+
+func (a *exportArchive) add(name string, source io.Reader) error {
+	entry, err := a.writer.Create(name)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(entry, hasher), source)
+	if err != nil {
+		return err
+	}
+
+	a.files = append(a.files, ExportManifestFile{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	})
+	return nil
+}
+
+var unsafeArchiveChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeArchiveName keeps attachment names from escaping their folder or using odd characters
+func safeArchiveName(name string) string {
+	name = unsafeArchiveChars.ReplaceAllString(filepath.Base(name), "_")
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Room.Name}} transcript</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+.meta { color: #666; margin-bottom: 2em; }
+.message { border-bottom: 1px solid #eee; padding: 0.6em 0; }
+.sender { font-weight: bold; }
+.time { color: #888; font-size: 0.85em; margin-left: 0.5em; }
+.content { white-space: pre-wrap; margin-top: 0.3em; }
+.attachments, .reactions { font-size: 0.9em; color: #555; margin-top: 0.3em; }
+</style>
+</head>
+<body>
+<h1>{{.Room.Name}}</h1>
+<div class="meta">
+Room #{{.Room.ID}} ({{.Room.Type}}) &middot; {{.Manifest.MessageCount}} messages &middot; {{.Manifest.AttachmentCount}} attachments<br>
+Range: {{if .Manifest.From}}{{.Manifest.From.Format "2006-01-02 15:04 MST"}}{{else}}start of room{{end}} to {{if .Manifest.To}}{{.Manifest.To.Format "2006-01-02 15:04 MST"}}{{else}}export time{{end}}<br>
+Generated {{.Manifest.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} for {{.Manifest.RequestedBy.UserType}} #{{.Manifest.RequestedBy.UserID}}
+</div>
+{{range .Messages}}
+<div class="message" id="message-{{.ID}}">
+<span class="sender">{{.SenderType}} #{{.SenderID}}</span>
+<span class="time">{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}{{if .IsEdited}} (edited){{end}}</span>
+{{if .ReplyToID}}<div class="attachments">In reply to <a href="#message-{{.ReplyToID}}">message #{{.ReplyToID}}</a></div>{{end}}
+<div class="content">{{.Content}}</div>
+{{if .Attachments}}<div class="attachments">{{range .Attachments}}{{if .Path}}<a href="{{.Path}}">{{.FileName}}</a>{{else}}{{.FileName}} (unavailable){{end}} ({{.FileSize}} bytes) {{end}}</div>{{end}}
+{{if .Reactions}}<div class="reactions">{{range $emoji, $count := .Reactions}}{{$emoji}} {{$count}} {{end}}</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+func renderTranscript(path string, room models.ChatRoom, manifest ExportManifest, messages []exportMessage) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return transcriptTemplate.Execute(file, map[string]interface{}{
+		"Room":     room,
+		"Manifest": manifest,
+		"Messages": messages,
+	})
+}
+
+// renderTranscriptPDF converts the HTML transcript with wkhtmltopdf.
+// It returns false when the renderer is not installed, in which case the archive only has the HTML transcript.
+func renderTranscriptPDF(htmlPath, pdfPath string) (bool, error) {
+	rendererPath := os.Getenv("HTML_TO_PDF_PATH")
+	if rendererPath == "" {
+		rendererPath = "wkhtmltopdf"
+	}
+	renderer, err := exec.LookPath(rendererPath)
+	if err != nil {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, renderer, "--quiet", "--encoding", "utf-8", htmlPath, pdfPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return true, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return true, nil
+}
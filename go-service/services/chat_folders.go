@@ -0,0 +1,438 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrFolderCycle is returned when a folder would be moved beneath itself
+var ErrFolderCycle = errors.New("folder cannot be moved into itself or one of its subfolders")
+
+// FolderNode is a folder with its full subtree of folders and files
+type FolderNode struct {
+	Folder    models.ChatFolder       `json:"folder"`
+	Children  []*FolderNode           `json:"children"`
+	Files     []models.ChatAttachment `json:"files"`
+	FileCount int                     `json:"file_count"` // Files in this folder and all subfolders
+	TotalSize int64                   `json:"total_size"` // Bytes in this folder and all subfolders
+}
+
+// FolderTree is a room's folder hierarchy plus the files not filed into any folder
+type FolderTree struct {
+	RoomID    uint                    `json:"room_id"`
+	Folders   []*FolderNode           `json:"folders"`
+	RootFiles []models.ChatAttachment `json:"root_files"`
+	FileCount int                     `json:"file_count"`
+	TotalSize int64                   `json:"total_size"`
+}
+
+// Folder Management
+func (s *ChatService) CreateFolder(roomID uint, name, description string, parentID *uint, createdBy uint, creatorType string) (*models.ChatFolder, error) {
+	if _, err := s.RequireParticipant(roomID, createdBy, creatorType); err != nil {
+		return nil, err
+	}
+
+	if parentID != nil {
+		if _, err := s.getRoomFolder(roomID, *parentID); err != nil {
+			return nil, err
+		}
+	}
+
+	folder := &models.ChatFolder{
+		RoomID:      roomID,
+		Name:        name,
+		Description: description,
+		ParentID:    parentID,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := s.db.Create(folder).Error; err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// GetFolders returns the room's top-level folders with every level of children and files loaded
+func (s *ChatService) GetFolders(roomID, userID uint, userType string) ([]models.ChatFolder, error) {
+	tree, err := s.GetFolderTree(roomID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	return toFolderModels(tree.Folders), nil
+}
+
+// GetFolderTree returns the room's full folder hierarchy with file counts and sizes per folder
+func (s *ChatService) GetFolderTree(roomID, userID uint, userType string) (*FolderTree, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
+	var folders []models.ChatFolder
+	if err := s.db.Where("room_id = ?", roomID).Order("name ASC").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	// Files are listed as DownloadFile serves them: never once moderation took them down, and only to
+	// their sender while they or their message are withheld
+	var attachments []models.ChatAttachment
+	err := s.db.Joins("JOIN chat_messages ON chat_attachments.message_id = chat_messages.id").
+		Where("chat_messages.room_id = ? AND chat_messages.is_deleted = ?", roomID, false).
+		Where("COALESCE(chat_attachments.moderation_status, '') NOT IN ?", takenDownStatuses).
+		Where("(chat_messages.sender_id = ? AND chat_messages.sender_type = ?) OR (chat_messages.moderation_status NOT IN ? AND COALESCE(chat_attachments.moderation_status, '') <> ?)",
+			userID, userType, withheldStatuses, ModerationStatusPending).
+		Order("chat_attachments.uploaded_at DESC").
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*FolderNode, len(folders))
+	for _, folder := range folders {
+		nodes[folder.ID] = &FolderNode{
+			Folder:   folder,
+			Children: []*FolderNode{},
+			Files:    []models.ChatAttachment{},
+		}
+	}
+
+	tree := &FolderTree{
+		RoomID:    roomID,
+		Folders:   []*FolderNode{},
+		RootFiles: []models.ChatAttachment{},
+	}
+
+	for _, attachment := range attachments {
+		if attachment.FolderID != nil {
+			if node, ok := nodes[*attachment.FolderID]; ok {
+				node.Files = append(node.Files, attachment)
+				continue
+			}
+		}
+		tree.RootFiles = append(tree.RootFiles, attachment)
+	}
+
+	for _, folder := range folders {
+		node := nodes[folder.ID]
+		if folder.ParentID != nil {
+			if parent, ok := nodes[*folder.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		tree.Folders = append(tree.Folders, node)
+	}
+
+	tree.FileCount = len(tree.RootFiles)
+	for _, file := range tree.RootFiles {
+		tree.TotalSize += file.FileSize
+	}
+	for _, node := range tree.Folders {
+		summarizeFolder(node)
+		tree.FileCount += node.FileCount
+		tree.TotalSize += node.TotalSize
+	}
+
+	return tree, nil
+}
+
+func (s *ChatService) RenameFolder(folderID, userID uint, userType, name string) (*models.ChatFolder, error) {
+	folder, err := s.requireFolderParticipant(folderID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	folder.Name = name
+	folder.UpdatedAt = time.Now()
+
+	if err := s.db.Save(folder).Error; err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// MoveFolder re-parents a folder within its room; a nil parent moves it to the top level
+func (s *ChatService) MoveFolder(folderID, userID uint, userType string, newParentID *uint) (*models.ChatFolder, error) {
+	folder, err := s.requireFolderParticipant(folderID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	if newParentID != nil {
+		parent, err := s.getRoomFolder(folder.RoomID, *newParentID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkFolderCycle(folder.ID, parent, func(id uint) (*models.ChatFolder, error) {
+			var next models.ChatFolder
+			if err := s.db.First(&next, id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return &next, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	folder.ParentID = newParentID
+	folder.UpdatedAt = time.Now()
+
+	if err := s.db.Save(folder).Error; err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// checkFolderCycle walks up from the new parent and returns ErrFolderCycle on reaching the folder itself.
+// getFolder returns nil for a parent that no longer exists, which ends the walk.
+func checkFolderCycle(folderID uint, parent *models.ChatFolder, getFolder func(id uint) (*models.ChatFolder, error)) error {
+	visited := map[uint]bool{}
+	for current := parent; current != nil; {
+		if current.ID == folderID {
+			return ErrFolderCycle
+		}
+		if visited[current.ID] || current.ParentID == nil {
+			return nil
+		}
+		visited[current.ID] = true
+
+		next, err := getFolder(*current.ParentID)
+		if err != nil {
+			return err
+		}
+		current = next
+	}
+	return nil
+}
+
+// DeleteFolder removes a folder, all of its subfolders and the files filed under them.
+// Only the folder's creator or a room moderator may delete it. A moderator deletes every file in the tree;
+// the creator deletes only their own uploads, and other members' files go back to the room root.
+func (s *ChatService) DeleteFolder(folderID, userID uint, userType string) error {
+	var folder models.ChatFolder
+	if err := s.db.First(&folder, folderID).Error; err != nil {
+		return err
+	}
+
+	participant, err := s.RequireParticipant(folder.RoomID, userID, userType)
+	if err != nil {
+		return err
+	}
+	isModerator := roleRank[participant.Role] >= roleRank[models.ChatRoleModerator]
+	if folder.CreatedBy != userID && !isModerator {
+		return ErrInsufficientRole
+	}
+
+	folderIDs, err := s.collectSubfolderIDs(folder)
+	if err != nil {
+		return err
+	}
+
+	tx := s.db.Begin()
+
+	var attachments []models.ChatAttachment
+	if err := tx.Where("folder_id IN ?", folderIDs).Find(&attachments).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var orphaned []string
+	var kept []uint
+	for _, attachment := range attachments {
+		if !isModerator {
+			var message models.ChatMessage
+			if err := tx.First(&message, attachment.MessageID).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			if message.SenderID != userID || message.SenderType != userType {
+				kept = append(kept, attachment.ID)
+				continue
+			}
+		}
+
+		files, err := s.removeAttachment(tx, attachment)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		orphaned = append(orphaned, files...)
+	}
+
+	if len(kept) > 0 {
+		if err := tx.Model(&models.ChatAttachment{}).Where("id IN ?", kept).Update("folder_id", nil).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Where("id IN ?", folderIDs).Delete(&models.ChatFolder{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	removeFiles(orphaned)
+	return nil
+}
+
+// MoveAttachment files an attachment under a folder of the same room; a nil folder moves it to the room root.
+// Only the uploader or a room moderator may move it.
+func (s *ChatService) MoveAttachment(attachmentID, userID uint, userType string, folderID *uint) (*models.ChatAttachment, error) {
+	var attachment models.ChatAttachment
+	if err := s.db.First(&attachment, attachmentID).Error; err != nil {
+		return nil, err
+	}
+
+	message, participant, err := s.requireMessageParticipant(attachment.MessageID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+	isUploader := message.SenderID == userID && message.SenderType == userType
+	if !isUploader && roleRank[participant.Role] < roleRank[models.ChatRoleModerator] {
+		return nil, ErrInsufficientRole
+	}
+
+	if folderID != nil {
+		if _, err := s.getRoomFolder(message.RoomID, *folderID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Model(&attachment).Update("folder_id", folderID).Error; err != nil {
+		return nil, err
+	}
+	attachment.FolderID = folderID
+
+	return &attachment, nil
+}
+
+// removeAttachment deletes an attachment record and releases its stored content and quota usage.
+// It returns the files nothing refers to any more, to be removed once tx commits.
+func (s *ChatService) removeAttachment(tx *gorm.DB, attachment models.ChatAttachment) ([]string, error) {
+	var message models.ChatMessage
+	if err := tx.First(&message, attachment.MessageID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Delete(&models.ChatAttachment{}, attachment.ID).Error; err != nil {
+		return nil, err
+	}
+
+	quotaOwners := ChatQuotaOwners(message.RoomID, message.SenderID, message.SenderType)
+	if err := s.quotas.Release(tx, quotaOwners, attachment.FileSize); err != nil {
+		return nil, err
+	}
+
+	orphaned := []string{attachment.ThumbnailPath}
+
+	// Files uploaded before deduplication own their encrypted copy
+	if attachment.ContentHash == "" {
+		return append(orphaned, attachment.EncryptedPath), nil
+	}
+
+	blobPath, err := s.blobStore.Release(tx, attachment.ContentHash)
+	if err != nil {
+		return nil, err
+	}
+	return append(orphaned, blobPath), nil
+}
+
+// requireFolderParticipant loads a folder and checks the caller belongs to its room
+func (s *ChatService) requireFolderParticipant(folderID, userID uint, userType string) (*models.ChatFolder, error) {
+	var folder models.ChatFolder
+	if err := s.db.First(&folder, folderID).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RequireParticipant(folder.RoomID, userID, userType); err != nil {
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+func (s *ChatService) getRoomFolder(roomID, folderID uint) (*models.ChatFolder, error) {
+	var folder models.ChatFolder
+	if err := s.db.Where("id = ? AND room_id = ?", folderID, roomID).First(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("folder %d not found in room %d", folderID, roomID)
+		}
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (s *ChatService) collectSubfolderIDs(root models.ChatFolder) ([]uint, error) {
+	var folders []models.ChatFolder
+	if err := s.db.Where("room_id = ?", root.RoomID).Find(&folders).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]uint)
+	for _, folder := range folders {
+		if folder.ParentID != nil {
+			children[*folder.ParentID] = append(children[*folder.ParentID], folder.ID)
+		}
+	}
+
+	ids := []uint{root.ID}
+	seen := map[uint]bool{root.ID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, childID := range children[ids[i]] {
+			if !seen[childID] {
+				seen[childID] = true
+				ids = append(ids, childID)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// summarizeFolder fills in recursive file counts and sizes for a subtree
+func summarizeFolder(node *FolderNode) {
+	node.FileCount = len(node.Files)
+	node.TotalSize = 0
+	for _, file := range node.Files {
+		node.TotalSize += file.FileSize
+	}
+
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Folder.Name < node.Children[j].Folder.Name
+	})
+	for _, child := range node.Children {
+		summarizeFolder(child)
+		node.FileCount += child.FileCount
+		node.TotalSize += child.TotalSize
+	}
+}
+
+func toFolderModels(nodes []*FolderNode) []models.ChatFolder {
+	folders := make([]models.ChatFolder, 0, len(nodes))
+	for _, node := range nodes {
+		folder := node.Folder
+		folder.Children = toFolderModels(node.Children)
+		folder.Files = node.Files
+		folders = append(folders, folder)
+	}
+	return folders
+}
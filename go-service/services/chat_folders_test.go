@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"my-property/go-service/models"
+	"testing"
+)
+
+func TestCheckFolderCycle(t *testing.T) {
+	parentOf := func(id uint) *uint { return &id }
+	// 1 -> 2 -> 3 -> 4 is a chain under the room root; 5 and 6 point at each other, as a corrupted tree
+	// could; 7's parent has been deleted
+	folders := map[uint]*models.ChatFolder{
+		1: {ID: 1},
+		2: {ID: 2, ParentID: parentOf(1)},
+		3: {ID: 3, ParentID: parentOf(2)},
+		4: {ID: 4, ParentID: parentOf(3)},
+		5: {ID: 5, ParentID: parentOf(6)},
+		6: {ID: 6, ParentID: parentOf(5)},
+		7: {ID: 7, ParentID: parentOf(99)},
+	}
+	lookupErr := errors.New("database is down")
+
+	tests := []struct {
+		name      string
+		folderID  uint
+		newParent uint
+		failAt    uint // Lookup of this id fails, 0 for none
+		want      error
+	}{
+		{"into itself", 2, 2, 0, ErrFolderCycle},
+		{"into its child", 2, 3, 0, ErrFolderCycle},
+		{"into a deeper descendant", 1, 4, 0, ErrFolderCycle},
+		{"into its parent", 3, 2, 0, nil},
+		{"into a root folder", 4, 1, 0, nil},
+		{"into another branch", 2, 5, 0, nil},
+		{"stops at an existing loop", 1, 6, 0, nil},
+		{"stops at a deleted parent", 1, 7, 0, nil},
+		{"lookup error", 1, 4, 2, lookupErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFolderCycle(tt.folderID, folders[tt.newParent], func(id uint) (*models.ChatFolder, error) {
+				if id == tt.failAt {
+					return nil, lookupErr
+				}
+				return folders[id], nil
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("checkFolderCycle(%d into %d) = %v, want %v", tt.folderID, tt.newParent, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Listing types an inquiry room can reference
+const (
+	ListingTypeProperty = "property"
+	ListingTypeBuilding = "building"
+)
+
+// ListingCard is the content of the "listing_card" message that opens an inquiry room
+type ListingCard struct {
+	ListingType string  `json:"listing_type"`
+	ListingID   uint    `json:"listing_id"`
+	Title       string  `json:"title"`
+	Price       float64 `json:"price"`
+	Currency    string  `json:"currency,omitempty"`
+	Location    string  `json:"location,omitempty"`
+	Image       string  `json:"image,omitempty"`
+}
+
+// listing is a property or building resolved to the party that answers inquiries about it
+type listing struct {
+	Type  string
+	ID    uint
+	Owner ParticipantRef
+	Card  ListingCard
+}
+
+// Inquiry Management
+// StartInquiry opens the direct room between the caller and a listing's landlord or company,
+// reusing the room from an earlier inquiry about the same listing. Exactly one of propertyID and buildingID must be set.
+func (s *ChatService) StartInquiry(userID uint, userType string, propertyID, buildingID *uint) (*models.ChatRoom, error) {
+	if (propertyID == nil) == (buildingID == nil) {
+		return nil, fmt.Errorf("exactly one of propertyId or buildingId is required")
+	}
+	if !participantTypes[userType] {
+		return nil, fmt.Errorf("invalid participant type: %s", userType)
+	}
+
+	var target *listing
+	var err error
+	if propertyID != nil {
+		target, err = s.loadPropertyListing(*propertyID)
+	} else {
+		target, err = s.loadBuildingListing(*buildingID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buyer := ParticipantRef{UserID: userID, UserType: userType}
+	if buyer == target.Owner {
+		return nil, fmt.Errorf("cannot start an inquiry on your own listing")
+	}
+	if err := s.requireNotBanned(nil, buyer); err != nil {
+		return nil, err
+	}
+
+	card, err := json.Marshal(target.Card)
+	if err != nil {
+		return nil, err
+	}
+
+	room := &models.ChatRoom{
+		Name:        target.Card.Title,
+		Description: fmt.Sprintf("Inquiry about %s #%d", target.Type, target.ID),
+		Type:        "direct",
+		CreatedBy:   userID,
+		ListingType: target.Type,
+		ListingID:   &target.ID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	message := &models.ChatMessage{
+		SenderID:         userID,
+		SenderType:       userType,
+		Content:          string(card),
+		MessageType:      "listing_card",
+		IsModerated:      true,
+		ModerationStatus: "approved", // Generated from listing data, not user text
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent inquiries from the same buyer about the same listing wait here, so only one creates the room
+		lockKey := fmt.Sprintf("inquiry:%s:%d:%s:%d", target.Type, target.ID, buyer.UserType, buyer.UserID)
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", lockKey).Error; err != nil {
+			return err
+		}
+
+		existing, err := findInquiryRoom(tx, target, buyer)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			room = existing
+			return nil
+		}
+
+		if err := tx.Create(room).Error; err != nil {
+			return err
+		}
+
+		participants := []models.ChatParticipant{
+			{
+				RoomID:   room.ID,
+				UserID:   buyer.UserID,
+				UserType: buyer.UserType,
+				Role:     models.ChatRoleOwner,
+				JoinedAt: time.Now(),
+				IsActive: true,
+			},
+			{
+				RoomID:    room.ID,
+				UserID:    target.Owner.UserID,
+				UserType:  target.Owner.UserType,
+				Role:      models.ChatRoleOwner, // Equal roles, so neither side can remove, mute or ban the other
+				JoinedAt:  time.Now(),
+				IsActive:  true,
+				InvitedBy: &userID,
+			},
+		}
+		if err := tx.Create(&participants).Error; err != nil {
+			return err
+		}
+
+		message.RoomID = room.ID
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		// The buyer has seen the card they started the room with
+		err = tx.Model(&participants[0]).Updates(map[string]interface{}{
+			"last_read_message_id": message.ID,
+			"last_read_at":         time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if target.Type == ListingTypeProperty {
+			err := tx.Model(&models.Property{}).
+				Where("id = ?", target.ID).
+				Update("inquiry_count", gorm.Expr("inquiry_count + 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		return s.publishMessage(tx, message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// findInquiryRoom returns the buyer's existing inquiry room for a listing if both sides are still in it
+func findInquiryRoom(tx *gorm.DB, target *listing, buyer ParticipantRef) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := tx.Joins(`JOIN chat_participants buyer ON buyer.room_id = chat_rooms.id
+			AND buyer.user_id = ? AND buyer.user_type = ? AND buyer.is_active = ?`, buyer.UserID, buyer.UserType, true).
+		Joins(`JOIN chat_participants owner ON owner.room_id = chat_rooms.id
+			AND owner.user_id = ? AND owner.user_type = ? AND owner.is_active = ?`, target.Owner.UserID, target.Owner.UserType, true).
+		Where("chat_rooms.type = ? AND chat_rooms.listing_type = ? AND chat_rooms.listing_id = ?", "direct", target.Type, target.ID).
+		Order("chat_rooms.created_at DESC").
+		First(&room).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+func (s *ChatService) loadPropertyListing(propertyID uint) (*listing, error) {
+	var property models.Property
+	if err := s.db.First(&property, propertyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("property %d not found", propertyID)
+		}
+		return nil, err
+	}
+
+	// Landlords are platform users
+	landlordID, err := strconv.ParseUint(property.LandlordId, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("property %d has no landlord to contact", propertyID)
+	}
+
+	card := ListingCard{
+		ListingType: ListingTypeProperty,
+		ListingID:   property.ID,
+		Title:       property.Title,
+		Price:       property.Price,
+		Currency:    property.Currency,
+		Location:    property.Neighborhood,
+	}
+	if property.Images != "" {
+		card.Image = strings.TrimSpace(strings.Split(property.Images, ",")[0])
+	}
+
+	return &listing{
+		Type:  ListingTypeProperty,
+		ID:    property.ID,
+		Owner: ParticipantRef{UserID: uint(landlordID), UserType: "user"},
+		Card:  card,
+	}, nil
+}
+
+func (s *ChatService) loadBuildingListing(buildingID uint) (*listing, error) {
+	var building models.Building
+	if err := s.db.Preload("Images").First(&building, buildingID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("building %d not found", buildingID)
+		}
+		return nil, err
+	}
+
+	// Buildings are sold by their company, or by the developer when no company is set
+	owner := ParticipantRef{UserID: building.CompanyID, UserType: "company"}
+	if building.CompanyID == 0 {
+		owner = ParticipantRef{UserID: building.DeveloperID, UserType: "developer"}
+	}
+	if owner.UserID == 0 {
+		return nil, fmt.Errorf("building %d has no company or developer to contact", buildingID)
+	}
+
+	card := ListingCard{
+		ListingType: ListingTypeBuilding,
+		ListingID:   building.ID,
+		Title:       building.Title,
+		Price:       building.Price,
+		Location:    building.Address,
+	}
+	if len(building.Images) > 0 {
+		card.Image = building.Images[0].URL
+	}
+
+	return &listing{
+		Type:  ListingTypeBuilding,
+		ID:    building.ID,
+		Owner: owner,
+		Card:  card,
+	}, nil
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotRoomMember     = errors.New("not an active participant of this room")
+	ErrInsufficientRole  = errors.New("insufficient room role for this operation")
+	ErrInvalidRole       = errors.New("invalid room role")
+	ErrOwnerMustTransfer = errors.New("room owner must transfer ownership before leaving")
+)
+
+// ParticipantRef identifies a room member across participant types
+type ParticipantRef struct {
+	UserID   uint   `json:"user_id"`
+	UserType string `json:"user_type"` // "user", "company", "developer"
+}
+
+var roleRank = map[string]int{
+	models.ChatRoleMember:    1,
+	models.ChatRoleModerator: 2,
+	models.ChatRoleAdmin:     3,
+	models.ChatRoleOwner:     4,
+}
+
+var participantTypes = map[string]bool{
+	"user":      true,
+	"company":   true,
+	"developer": true,
+}
+
+// RequireParticipant returns the caller's active membership of a room, or ErrNotRoomMember
+func (s *ChatService) RequireParticipant(roomID, userID uint, userType string) (*models.ChatParticipant, error) {
+	var participant models.ChatParticipant
+	err := s.db.Where("room_id = ? AND user_id = ? AND user_type = ? AND is_active = ?", roomID, userID, userType, true).
+		First(&participant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotRoomMember
+		}
+		return nil, err
+	}
+	return &participant, nil
+}
+
+// RequireRole returns the caller's membership if their role is at least minRole
+func (s *ChatService) RequireRole(roomID, userID uint, userType, minRole string) (*models.ChatParticipant, error) {
+	participant, err := s.RequireParticipant(roomID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+	if roleRank[participant.Role] < roleRank[minRole] {
+		return nil, ErrInsufficientRole
+	}
+	return participant, nil
+}
+
+// requireMessageParticipant loads a message and checks the caller belongs to its room
+func (s *ChatService) requireMessageParticipant(messageID, userID uint, userType string) (*models.ChatMessage, *models.ChatParticipant, error) {
+	var message models.ChatMessage
+	if err := s.db.First(&message, messageID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	participant, err := s.RequireParticipant(message.RoomID, userID, userType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &message, participant, nil
+}
+
+// Membership Management
+func (s *ChatService) GetParticipants(roomID, userID uint, userType string) ([]models.ChatParticipant, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
+	var participants []models.ChatParticipant
+	err := s.db.Where("room_id = ? AND is_active = ?", roomID, true).
+		Order("joined_at ASC").
+		Find(&participants).Error
+	return participants, err
+}
+
+// InviteParticipants adds members to a room, reactivating anyone who previously left
+func (s *ChatService) InviteParticipants(roomID, actorID uint, actorType string, invitees []ParticipantRef) ([]models.ChatParticipant, error) {
+	if _, err := s.RequireRole(roomID, actorID, actorType, models.ChatRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var room models.ChatRoom
+	if err := s.db.First(&room, roomID).Error; err != nil {
+		return nil, err
+	}
+
+	if room.Type == "direct" {
+		return nil, fmt.Errorf("cannot invite participants to a direct room")
+	}
+
+	var added []models.ChatParticipant
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, invitee := range invitees {
+			if !participantTypes[invitee.UserType] {
+				return fmt.Errorf("invalid participant type: %s", invitee.UserType)
+			}
+			if err := s.requireNotBanned(&roomID, invitee); err != nil {
+				return fmt.Errorf("cannot invite %s %d: %w", invitee.UserType, invitee.UserID, err)
+			}
+
+			var participant models.ChatParticipant
+			err := tx.Where("room_id = ? AND user_id = ? AND user_type = ?", roomID, invitee.UserID, invitee.UserType).
+				First(&participant).Error
+			switch {
+			case err == nil:
+				if participant.IsActive {
+					continue
+				}
+				participant.IsActive = true
+				participant.Role = models.ChatRoleMember
+				participant.JoinedAt = time.Now()
+				participant.InvitedBy = &actorID
+				participant.LeftAt = nil
+				if err := tx.Save(&participant).Error; err != nil {
+					return err
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				participant = models.ChatParticipant{
+					RoomID:    roomID,
+					UserID:    invitee.UserID,
+					UserType:  invitee.UserType,
+					Role:      models.ChatRoleMember,
+					JoinedAt:  time.Now(),
+					IsActive:  true,
+					InvitedBy: &actorID,
+				}
+				if err := tx.Create(&participant).Error; err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+			added = append(added, participant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
+
+// RemoveParticipant deactivates a member; only the owner can remove admins and nobody can remove the owner
+func (s *ChatService) RemoveParticipant(roomID, actorID uint, actorType string, target ParticipantRef) error {
+	actor, err := s.RequireRole(roomID, actorID, actorType, models.ChatRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	participant, err := s.RequireParticipant(roomID, target.UserID, target.UserType)
+	if err != nil {
+		return err
+	}
+
+	if participant.Role == models.ChatRoleOwner {
+		return ErrInsufficientRole
+	}
+	if roleRank[participant.Role] >= roleRank[actor.Role] {
+		return ErrInsufficientRole
+	}
+
+	return s.deactivateParticipant(s.db, participant)
+}
+
+// ChangeParticipantRole sets a member's role; actors can only grant and change roles below their own
+func (s *ChatService) ChangeParticipantRole(roomID, actorID uint, actorType string, target ParticipantRef, role string) (*models.ChatParticipant, error) {
+	if _, ok := roleRank[role]; !ok || role == models.ChatRoleOwner {
+		return nil, ErrInvalidRole
+	}
+
+	actor, err := s.RequireRole(roomID, actorID, actorType, models.ChatRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	participant, err := s.RequireParticipant(roomID, target.UserID, target.UserType)
+	if err != nil {
+		return nil, err
+	}
+
+	if actor.ID == participant.ID {
+		return nil, fmt.Errorf("cannot change your own role")
+	}
+	if roleRank[participant.Role] >= roleRank[actor.Role] || roleRank[role] >= roleRank[actor.Role] {
+		return nil, ErrInsufficientRole
+	}
+
+	participant.Role = role
+	if err := s.db.Save(participant).Error; err != nil {
+		return nil, err
+	}
+
+	return participant, nil
+}
+
+// LeaveRoom deactivates the caller's membership; an owner must hand over ownership first unless they are the last member
+// or another owner stays, as in an inquiry room
+func (s *ChatService) LeaveRoom(roomID, userID uint, userType string) error {
+	participant, err := s.RequireParticipant(roomID, userID, userType)
+	if err != nil {
+		return err
+	}
+
+	if participant.Role == models.ChatRoleOwner {
+		var others, owners int64
+		s.db.Model(&models.ChatParticipant{}).
+			Where("room_id = ? AND is_active = ? AND id <> ?", roomID, true, participant.ID).
+			Count(&others)
+		s.db.Model(&models.ChatParticipant{}).
+			Where("room_id = ? AND is_active = ? AND id <> ? AND role = ?", roomID, true, participant.ID, models.ChatRoleOwner).
+			Count(&owners)
+		if others > 0 && owners == 0 {
+			return ErrOwnerMustTransfer
+		}
+	}
+
+	return s.deactivateParticipant(s.db, participant)
+}
+
+// TransferOwnership makes another active member the owner and demotes the current owner to admin
+func (s *ChatService) TransferOwnership(roomID, actorID uint, actorType string, newOwner ParticipantRef) (*models.ChatParticipant, error) {
+	actor, err := s.RequireRole(roomID, actorID, actorType, models.ChatRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.RequireParticipant(roomID, newOwner.UserID, newOwner.UserType)
+	if err != nil {
+		return nil, err
+	}
+	// Co-owners keep their role; demoting the actor would let the other owner sanction them
+	if target.ID == actor.ID || target.Role == models.ChatRoleOwner {
+		return target, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(actor).Update("role", models.ChatRoleAdmin).Error; err != nil {
+			return err
+		}
+		return tx.Model(target).Update("role", models.ChatRoleOwner).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	target.Role = models.ChatRoleOwner
+	return target, nil
+}
+
+func (s *ChatService) deactivateParticipant(tx *gorm.DB, participant *models.ChatParticipant) error {
+	now := time.Now()
+	return tx.Model(participant).Updates(map[string]interface{}{
+		"is_active": false,
+		"left_at":   now,
+	}).Error
+}
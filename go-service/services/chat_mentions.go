@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"my-property/go-service/models"
+	"my-property/go-service/presence"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Mention tokens: @user:12, @company:3, @developer:7, @here and @all
+var mentionPattern = regexp.MustCompile(`@(?:(user|company|developer):(\d+)|(here|all))\b`)
+
+// parseMentions finds mention tokens in content. Offsets and lengths are in characters, not bytes.
+func parseMentions(content string) []models.ChatMessageEntity {
+	var entities []models.ChatMessageEntity
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		start, end := match[0], match[1]
+
+		// Skip things like e-mail addresses where @ follows a word character
+		if start > 0 {
+			previous, _ := utf8.DecodeLastRuneInString(content[:start])
+			if unicode.IsLetter(previous) || unicode.IsDigit(previous) {
+				continue
+			}
+		}
+
+		entity := models.ChatMessageEntity{
+			Type:   "mention",
+			Offset: utf8.RuneCountInString(content[:start]),
+			Length: utf8.RuneCountInString(content[start:end]),
+			Token:  content[start:end],
+		}
+
+		if match[2] >= 0 {
+			id, err := strconv.ParseUint(content[match[4]:match[5]], 10, 32)
+			if err != nil {
+				continue
+			}
+			entity.TargetType = content[match[2]:match[3]]
+			entity.TargetID = &[]uint{uint(id)}[0]
+		} else {
+			entity.TargetType = content[match[6]:match[7]]
+		}
+
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// saveMentions replaces a message's mention entities and resolved mentions, returning the participants
+// who were not already mentioned by a previous version of the message
+func (s *ChatService) saveMentions(tx *gorm.DB, message *models.ChatMessage) ([]models.ChatMention, error) {
+	var previous []models.ChatMention
+	if err := tx.Where("message_id = ?", message.ID).Find(&previous).Error; err != nil {
+		return nil, err
+	}
+	alreadyMentioned := make(map[ParticipantRef]bool, len(previous))
+	for _, mention := range previous {
+		alreadyMentioned[ParticipantRef{UserID: mention.UserID, UserType: mention.UserType}] = true
+	}
+
+	if err := tx.Where("message_id = ?", message.ID).Delete(&models.ChatMessageEntity{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("message_id = ?", message.ID).Delete(&models.ChatMention{}).Error; err != nil {
+		return nil, err
+	}
+
+	entities := parseMentions(message.Content)
+	if len(entities) == 0 {
+		message.Entities = nil
+		return nil, nil
+	}
+
+	var participants []models.ChatParticipant
+	if err := tx.Where("room_id = ? AND is_active = ?", message.RoomID, true).Find(&participants).Error; err != nil {
+		return nil, err
+	}
+	members := make(map[ParticipantRef]bool, len(participants))
+	for _, participant := range participants {
+		members[ParticipantRef{UserID: participant.UserID, UserType: participant.UserType}] = true
+	}
+
+	sender := ParticipantRef{UserID: message.SenderID, UserType: message.SenderType}
+	resolved := make(map[ParticipantRef]string)
+	var kept []models.ChatMessageEntity
+
+	for _, entity := range entities {
+		switch entity.TargetType {
+		case "all":
+			for member := range members {
+				if _, ok := resolved[member]; !ok {
+					resolved[member] = "all"
+				}
+			}
+		case "here":
+			for member := range members {
+				if _, ok := resolved[member]; !ok && isOnline(member) {
+					resolved[member] = "here"
+				}
+			}
+		default:
+			// Tokens naming someone outside the room stay plain text
+			target := ParticipantRef{UserID: *entity.TargetID, UserType: entity.TargetType}
+			if !members[target] {
+				continue
+			}
+			resolved[target] = "direct"
+		}
+
+		entity.MessageID = message.ID
+		kept = append(kept, entity)
+	}
+
+	if len(kept) > 0 {
+		if err := tx.Create(&kept).Error; err != nil {
+			return nil, err
+		}
+	}
+	message.Entities = kept
+
+	var added []models.ChatMention
+	for ref, kind := range resolved {
+		if ref == sender {
+			continue
+		}
+
+		mention := models.ChatMention{
+			MessageID: message.ID,
+			RoomID:    message.RoomID,
+			UserID:    ref.UserID,
+			UserType:  ref.UserType,
+			Kind:      kind,
+			CreatedAt: time.Now(),
+		}
+		if err := tx.Create(&mention).Error; err != nil {
+			return nil, err
+		}
+		if !alreadyMentioned[ref] {
+			added = append(added, mention)
+		}
+	}
+
+	return added, nil
+}
+
+// notifyMentions creates a "mention" notification for each newly mentioned participant
+func (s *ChatService) notifyMentions(message *models.ChatMessage, mentions []models.ChatMention) {
+	for _, mention := range mentions {
+		text := "You were mentioned in a message"
+		if mention.Kind != "direct" {
+			text = fmt.Sprintf("@%s mention in a message", mention.Kind)
+		}
+
+		notification := &models.ChatNotification{
+			UserID:    mention.UserID,
+			UserType:  mention.UserType,
+			RoomID:    message.RoomID,
+			Type:      "mention",
+			MessageID: &message.ID,
+			Message:   text,
+			CreatedAt: time.Now(),
+		}
+		if err := s.db.Create(notification).Error; err != nil {
+			fmt.Printf("Failed to create mention notification: %v\n", err)
+		}
+	}
+}
+
+// GetMentions lists messages that mentioned the caller in rooms they still belong to, newest first
+func (s *ChatService) GetMentions(userID uint, userType string, limit, offset int) ([]models.ChatMessage, error) {
+	var messages []models.ChatMessage
+	err := s.db.Joins("JOIN chat_mentions ON chat_mentions.message_id = chat_messages.id").
+		Joins(`JOIN chat_participants ON chat_participants.room_id = chat_messages.room_id
+			AND chat_participants.user_id = chat_mentions.user_id
+			AND chat_participants.user_type = chat_mentions.user_type
+			AND chat_participants.is_active = ?`, true).
+		Where("chat_mentions.user_id = ? AND chat_mentions.user_type = ?", userID, userType).
+		Where("chat_messages.is_deleted = ?", false).
+		// Mentions are saved while a message waits for moderation; they show once it is released
+		Where("chat_messages.moderation_status NOT IN ? OR (chat_messages.sender_id = ? AND chat_messages.sender_type = ?)", withheldStatuses, userID, userType).
+		Preload("Entities").
+		Preload("Attachments").
+		Order("chat_messages.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&messages).Error
+	return messages, err
+}
+
+func isOnline(ref ParticipantRef) bool {
+	snapshot := presence.GetInstance().Snapshot([]presence.User{{ID: ref.UserID, Type: ref.UserType}})
+	return len(snapshot) == 1 && snapshot[0].Online
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	type mention struct {
+		token      string
+		offset     int
+		length     int
+		targetType string
+		targetID   uint // 0 for @here and @all
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []mention
+	}{
+		{"no mentions", "Is the flat still available?", nil},
+		{"user", "@user:12 please check", []mention{{"@user:12", 0, 8, "user", 12}}},
+		{"company and developer", "ask @company:3 and @developer:45.", []mention{
+			{"@company:3", 4, 10, "company", 3},
+			{"@developer:45", 19, 13, "developer", 45},
+		}},
+		{"here and all", "@here @all", []mention{{"@here", 0, 5, "here", 0}, {"@all", 6, 4, "all", 0}}},
+		{"offsets count runes", "مرحبا @user:7", []mention{{"@user:7", 6, 7, "user", 7}}},
+		{"email address", "write to owner@here.com", nil},
+		{"unknown type", "@admin:1 and @everyone", nil},
+		{"word continues", "@allowed @user:1x", nil},
+		{"id out of range", "@user:99999999999", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entities := parseMentions(tt.content)
+			if len(entities) != len(tt.want) {
+				t.Fatalf("parseMentions(%q) found %d mentions, want %d", tt.content, len(entities), len(tt.want))
+			}
+			for i, entity := range entities {
+				want := tt.want[i]
+				var targetID uint
+				if entity.TargetID != nil {
+					targetID = *entity.TargetID
+				}
+				got := mention{entity.Token, entity.Offset, entity.Length, entity.TargetType, targetID}
+				if entity.Type != "mention" || got != want {
+					t.Errorf("mention %d = %s %+v, want mention %+v", i, entity.Type, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,565 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Review states of a moderation log entry
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusResolved = "resolved"
+)
+
+// Moderator actions on a queued item
+const (
+	ModerationActionApprove = "approve"
+	ModerationActionHide    = "hide"
+	ModerationActionDelete  = "delete"
+)
+
+// Moderation statuses of messages and attachments
+const (
+	ModerationStatusPending  = "pending" // Waiting for the AI service to recover
+	ModerationStatusApproved = "approved"
+	ModerationStatusFlagged  = "flagged"
+	ModerationStatusBlocked  = "blocked" // Pending content the AI service later rejected
+	ModerationStatusHidden   = "hidden"  // Hidden by a moderator
+	ModerationStatusRemoved  = "removed" // Attachment deleted by a moderator
+)
+
+// withheldStatuses keep a message from room members other than its sender
+var withheldStatuses = []string{ModerationStatusPending, ModerationStatusBlocked, ModerationStatusHidden}
+
+// takenDownStatuses keep an attachment from everyone, its sender included
+var takenDownStatuses = []string{ModerationStatusBlocked, ModerationStatusHidden, ModerationStatusRemoved}
+
+func isWithheld(status string) bool {
+	for _, withheld := range withheldStatuses {
+		if status == withheld {
+			return true
+		}
+	}
+	return false
+}
+
+func isTakenDown(status string) bool {
+	for _, takenDown := range takenDownStatuses {
+		if status == takenDown {
+			return true
+		}
+	}
+	return false
+}
+
+// Appeal statuses
+const (
+	AppealStatusPending    = "pending"
+	AppealStatusUpheld     = "upheld"
+	AppealStatusOverturned = "overturned"
+)
+
+var (
+	ErrNothingToAppeal  = errors.New("there is no block or moderator decision to appeal")
+	ErrAlreadyAppealed  = errors.New("this item has already been appealed")
+	ErrAppealResolved   = errors.New("appeal has already been resolved")
+	ErrOwnContentReview = errors.New("moderators cannot review their own content")
+)
+
+// Moderation Queue
+// GetModerationQueue lists a room's flagged and blocked items with a review status, oldest first; moderators and above only
+func (s *ChatService) GetModerationQueue(roomID, userID uint, userType, status string, limit, offset int) ([]models.ChatModerationLog, error) {
+	if _, err := s.RequireRole(roomID, userID, userType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+	if status == "" {
+		status = ReviewStatusPending
+	}
+
+	var entries []models.ChatModerationLog
+	err := s.db.Where("room_id = ? AND review_status = ?", roomID, status).
+		Preload("Decisions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Order("created_at ASC").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries).Error
+	return entries, err
+}
+
+// ReviewModerationItem approves, hides or deletes a queued item and records the decision.
+// Approving blocked content posts it (or applies the blocked edit); deleting it keeps it blocked.
+func (s *ChatService) ReviewModerationItem(logID, actorID uint, actorType, action, reason string) (*models.ChatModerationLog, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required for moderation decisions")
+	}
+	if action != ModerationActionApprove && action != ModerationActionHide && action != ModerationActionDelete {
+		return nil, fmt.Errorf("invalid moderation action: %s", action)
+	}
+
+	var entry models.ChatModerationLog
+	if err := s.db.First(&entry, logID).Error; err != nil {
+		return nil, err
+	}
+	if entry.ReviewStatus == "" {
+		return nil, fmt.Errorf("moderation log %d was not queued for review", logID)
+	}
+	if _, err := s.RequireRole(entry.RoomID, actorID, actorType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+	if entry.UserID == actorID && entry.UserType == actorType {
+		return nil, ErrOwnContentReview
+	}
+
+	var outcome moderationOutcome
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if outcome, err = s.applyModerationAction(tx, &entry, action); err != nil {
+			return err
+		}
+		return s.recordModerationDecision(tx, &entry, nil, action, actorID, actorType, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.finishModerationOutcome(outcome)
+	if action != ModerationActionApprove {
+		s.notifyModeration(&entry, fmt.Sprintf("A moderator applied %q to your content (moderation item #%d): %s", action, entry.ID, reason))
+	}
+
+	return s.getModerationLog(entry.ID)
+}
+
+// AppealModeration lets the author of blocked, hidden or deleted content ask for another review. Each item can be appealed once.
+func (s *ChatService) AppealModeration(logID, userID uint, userType, reason string) (*models.ChatModerationAppeal, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to appeal")
+	}
+
+	var entry models.ChatModerationLog
+	if err := s.db.First(&entry, logID).Error; err != nil {
+		return nil, err
+	}
+	if entry.UserID != userID || entry.UserType != userType {
+		return nil, fmt.Errorf("only the author can appeal this decision")
+	}
+
+	var appeals int64
+	if err := s.db.Model(&models.ChatModerationAppeal{}).Where("log_id = ?", logID).Count(&appeals).Error; err != nil {
+		return nil, err
+	}
+	if appeals > 0 {
+		return nil, ErrAlreadyAppealed
+	}
+
+	lastAction, err := s.lastModerationAction(s.db, logID)
+	if err != nil {
+		return nil, err
+	}
+	released, err := s.wasReleased(s.db, logID)
+	if err != nil {
+		return nil, err
+	}
+	blocked := !entry.Allowed && !released
+	removed := lastAction == ModerationActionHide || lastAction == ModerationActionDelete
+	if !blocked && !removed {
+		return nil, ErrNothingToAppeal
+	}
+
+	appeal := &models.ChatModerationAppeal{
+		LogID:     entry.ID,
+		RoomID:    entry.RoomID,
+		UserID:    userID,
+		UserType:  userType,
+		Reason:    reason,
+		Status:    AppealStatusPending,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(appeal).Error; err != nil {
+		return nil, err
+	}
+
+	appeal.Log = entry
+	return appeal, nil
+}
+
+// GetModerationAppeals lists a room's appeals with the given status, oldest first; moderators and above only
+func (s *ChatService) GetModerationAppeals(roomID, userID uint, userType, status string, limit, offset int) ([]models.ChatModerationAppeal, error) {
+	if _, err := s.RequireRole(roomID, userID, userType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+	if status == "" {
+		status = AppealStatusPending
+	}
+
+	var appeals []models.ChatModerationAppeal
+	err := s.db.Where("room_id = ? AND status = ?", roomID, status).
+		Preload("Log").
+		Preload("Log.Decisions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Order("created_at ASC").
+		Limit(limit).
+		Offset(offset).
+		Find(&appeals).Error
+	return appeals, err
+}
+
+// ResolveAppeal upholds the original outcome or overturns it, which approves the content
+func (s *ChatService) ResolveAppeal(appealID, actorID uint, actorType string, overturn bool, reason string) (*models.ChatModerationAppeal, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required for moderation decisions")
+	}
+
+	var appeal models.ChatModerationAppeal
+	if err := s.db.Preload("Log").First(&appeal, appealID).Error; err != nil {
+		return nil, err
+	}
+	if appeal.Status != AppealStatusPending {
+		return nil, ErrAppealResolved
+	}
+	if _, err := s.RequireRole(appeal.RoomID, actorID, actorType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+	if appeal.UserID == actorID && appeal.UserType == actorType {
+		return nil, ErrOwnContentReview
+	}
+
+	status, action := AppealStatusUpheld, "appeal_upheld"
+	if overturn {
+		status, action = AppealStatusOverturned, "appeal_overturned"
+	}
+
+	now := time.Now()
+	var outcome moderationOutcome
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if overturn {
+			var err error
+			if outcome, err = s.applyModerationAction(tx, &appeal.Log, ModerationActionApprove); err != nil {
+				return err
+			}
+		}
+		if err := s.recordModerationDecision(tx, &appeal.Log, &appeal.ID, action, actorID, actorType, reason); err != nil {
+			return err
+		}
+
+		appeal.Status = status
+		appeal.ResolvedBy = &actorID
+		appeal.ResolverType = actorType
+		appeal.Resolution = reason
+		appeal.ResolvedAt = &now
+		return tx.Omit("Log").Save(&appeal).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.finishModerationOutcome(outcome)
+	s.notifyModeration(&appeal.Log, fmt.Sprintf("Your appeal of moderation item #%d was %s: %s", appeal.LogID, status, reason))
+
+	return &appeal, nil
+}
+
+// GetModerationDecisions returns the decision history of a moderation log entry to its author or a room moderator
+func (s *ChatService) GetModerationDecisions(logID, userID uint, userType string) ([]models.ChatModerationDecision, error) {
+	var entry models.ChatModerationLog
+	if err := s.db.First(&entry, logID).Error; err != nil {
+		return nil, err
+	}
+	if entry.UserID != userID || entry.UserType != userType {
+		if _, err := s.RequireRole(entry.RoomID, userID, userType, models.ChatRoleModerator); err != nil {
+			return nil, err
+		}
+	}
+
+	var decisions []models.ChatModerationDecision
+	err := s.db.Where("log_id = ?", logID).Order("created_at ASC").Find(&decisions).Error
+	return decisions, err
+}
+
+// moderationOutcome carries the side effects to publish once a decision is committed
+type moderationOutcome struct {
+	posted    *models.ChatMessage
+	mentions  []models.ChatMention
+	unpinRoom *uint
+}
+
+// applyModerationAction changes the moderated message, attachment or blocked content to match a decision
+func (s *ChatService) applyModerationAction(tx *gorm.DB, entry *models.ChatModerationLog, action string) (moderationOutcome, error) {
+	var outcome moderationOutcome
+
+	if entry.AttachmentID != nil {
+		status := ModerationStatusApproved
+		switch action {
+		case ModerationActionHide:
+			status = ModerationStatusHidden
+		case ModerationActionDelete:
+			status = ModerationStatusRemoved
+		}
+		err := tx.Model(&models.ChatAttachment{}).
+			Where("id = ?", *entry.AttachmentID).
+			Update("moderation_status", status).Error
+		return outcome, err
+	}
+
+	if !entry.Allowed {
+		released, err := s.wasReleased(tx, entry.ID)
+		if err != nil {
+			return outcome, err
+		}
+		if entry.ContentType != "text" || !released {
+			// Blocked content was never stored; deleting confirms the block
+			if action == ModerationActionHide {
+				return outcome, fmt.Errorf("blocked content can only be approved or deleted")
+			}
+			// Blocked files were discarded, so approving one only lets the sender upload it again
+			if action == ModerationActionDelete || entry.ContentType != "text" {
+				return outcome, nil
+			}
+			outcome, err := s.releaseBlockedText(tx, entry)
+			if err == nil && outcome.posted != nil {
+				err = s.publishMessage(tx, outcome.posted)
+			}
+			return outcome, err
+		}
+		// Released text is moderated like any other message from here on
+	}
+
+	if entry.MessageID == nil {
+		return outcome, fmt.Errorf("moderation log %d has no message to act on", entry.ID)
+	}
+
+	var message models.ChatMessage
+	if err := tx.First(&message, *entry.MessageID).Error; err != nil {
+		return outcome, err
+	}
+
+	switch action {
+	case ModerationActionApprove:
+		// Approving reverses an earlier moderator deletion
+		lastAction, err := s.lastModerationAction(tx, entry.ID)
+		if err != nil {
+			return outcome, err
+		}
+		if message.IsDeleted && lastAction == ModerationActionDelete {
+			message.IsDeleted = false
+		}
+		message.ModerationStatus = ModerationStatusApproved
+	case ModerationActionHide:
+		message.ModerationStatus = ModerationStatusHidden
+	case ModerationActionDelete:
+		if message.IsDeleted {
+			return outcome, nil
+		}
+		wasPinned, err := s.deleteMessage(tx, &message)
+		if err != nil {
+			return outcome, err
+		}
+		if wasPinned {
+			outcome.unpinRoom = &message.RoomID
+		}
+		return outcome, nil
+	}
+
+	message.UpdatedAt = time.Now()
+	if err := tx.Save(&message).Error; err != nil {
+		return outcome, err
+	}
+	// Hiding or approving a reply changes what its thread's count shows
+	if message.ThreadRootID != nil {
+		return outcome, refreshThreadReplies(tx, *message.ThreadRootID)
+	}
+	return outcome, nil
+}
+
+// releaseBlockedText posts a blocked message, or applies a blocked edit, once a moderator approves it
+func (s *ChatService) releaseBlockedText(tx *gorm.DB, entry *models.ChatModerationLog) (moderationOutcome, error) {
+	var outcome moderationOutcome
+
+	if entry.MessageID != nil {
+		message := models.ChatMessage{ID: *entry.MessageID}
+		if err := lockMessage(tx, &message); err != nil {
+			return outcome, err
+		}
+		if message.IsDeleted {
+			return outcome, fmt.Errorf("the edited message has since been deleted")
+		}
+
+		// Queued messages the AI service later blocked are already stored and only need releasing
+		if message.ModerationStatus == ModerationStatusBlocked {
+			message.ModerationStatus = ModerationStatusApproved
+			message.UpdatedAt = time.Now()
+			if err := tx.Save(&message).Error; err != nil {
+				return outcome, err
+			}
+			if message.IsEdited {
+				return outcome, publishEdit(tx, &message, entry.UserID, entry.UserType)
+			}
+			if err := tx.Where("message_id = ?", message.ID).Find(&outcome.mentions).Error; err != nil {
+				return outcome, err
+			}
+			outcome.posted = &message
+			return outcome, nil
+		}
+
+		revision := &models.ChatMessageRevision{
+			MessageID:        message.ID,
+			Content:          message.Content,
+			ModerationStatus: message.ModerationStatus,
+			EditedBy:         entry.UserID,
+			EditorType:       entry.UserType,
+			CreatedAt:        time.Now(),
+		}
+		if err := s.saveRevision(tx, revision); err != nil {
+			return outcome, err
+		}
+
+		message.Content = entry.Content
+		message.IsEdited = true
+		message.ModerationStatus = ModerationStatusApproved
+		message.UpdatedAt = time.Now()
+		if err := tx.Save(&message).Error; err != nil {
+			return outcome, err
+		}
+		if _, err := s.saveMentions(tx, &message); err != nil {
+			return outcome, err
+		}
+		return outcome, publishEdit(tx, &message, entry.UserID, entry.UserType)
+	}
+
+	if _, err := s.RequireParticipant(entry.RoomID, entry.UserID, entry.UserType); err != nil {
+		return outcome, fmt.Errorf("the sender is no longer in the room")
+	}
+
+	message := &models.ChatMessage{
+		RoomID:           entry.RoomID,
+		SenderID:         entry.UserID,
+		SenderType:       entry.UserType,
+		Content:          entry.Content,
+		MessageType:      "text",
+		IsModerated:      true,
+		ModerationStatus: ModerationStatusApproved,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if err := tx.Create(message).Error; err != nil {
+		return outcome, err
+	}
+	if err := linkModerationLog(tx, entry, message.ID, nil); err != nil {
+		return outcome, err
+	}
+	mentions, err := s.saveMentions(tx, message)
+	if err != nil {
+		return outcome, err
+	}
+
+	outcome.posted = message
+	outcome.mentions = mentions
+	return outcome, nil
+}
+
+// finishModerationOutcome publishes what a committed decision changed
+func (s *ChatService) finishModerationOutcome(outcome moderationOutcome) {
+	if outcome.posted != nil {
+		s.notifyDelivery(outcome.posted, outcome.mentions)
+	}
+	if outcome.unpinRoom != nil {
+		if _, err := s.publishPins(*outcome.unpinRoom); err != nil {
+			fmt.Printf("Failed to publish pinned messages: %v\n", err)
+		}
+	}
+}
+
+func (s *ChatService) recordModerationDecision(tx *gorm.DB, entry *models.ChatModerationLog, appealID *uint, action string, actorID uint, actorType, reason string) error {
+	decision := &models.ChatModerationDecision{
+		LogID:     entry.ID,
+		AppealID:  appealID,
+		Action:    action,
+		ActorID:   actorID,
+		ActorType: actorType,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(decision).Error; err != nil {
+		return err
+	}
+
+	entry.ReviewStatus = ReviewStatusResolved
+	return tx.Model(entry).Update("review_status", ReviewStatusResolved).Error
+}
+
+// lastModerationAction returns the latest approve, hide or delete applied to an entry, directly or by an overturned appeal
+func (s *ChatService) lastModerationAction(db *gorm.DB, logID uint) (string, error) {
+	var decision models.ChatModerationDecision
+	err := db.Where("log_id = ? AND action IN ?", logID, []string{ModerationActionApprove, ModerationActionHide, ModerationActionDelete, "appeal_overturned"}).
+		Order("created_at DESC").
+		First(&decision).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if decision.Action == "appeal_overturned" {
+		return ModerationActionApprove, nil
+	}
+	return decision.Action, nil
+}
+
+// wasReleased reports whether blocked content has been approved at some point, directly or on appeal
+func (s *ChatService) wasReleased(db *gorm.DB, logID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.ChatModerationDecision{}).
+		Where("log_id = ? AND action IN ?", logID, []string{ModerationActionApprove, "appeal_overturned"}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *ChatService) getModerationLog(logID uint) (*models.ChatModerationLog, error) {
+	var entry models.ChatModerationLog
+	err := s.db.Preload("Decisions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).First(&entry, logID).Error
+	return &entry, err
+}
+
+// notifyModeration tells the author of moderated content about a decision
+func (s *ChatService) notifyModeration(entry *models.ChatModerationLog, text string) {
+	notification := &models.ChatNotification{
+		UserID:    entry.UserID,
+		UserType:  entry.UserType,
+		RoomID:    entry.RoomID,
+		Type:      "moderation",
+		MessageID: entry.MessageID,
+		Message:   text,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(notification).Error; err != nil {
+		fmt.Printf("Failed to create moderation notification: %v\n", err)
+	}
+}
+
+// linkModerationLog points a log entry at the message or attachment created after moderation
+func linkModerationLog(tx *gorm.DB, entry *models.ChatModerationLog, messageID uint, attachmentID *uint) error {
+	if entry == nil {
+		return nil
+	}
+
+	entry.MessageID = &messageID
+	entry.AttachmentID = attachmentID
+	return tx.Model(entry).Updates(map[string]interface{}{
+		"message_id":    messageID,
+		"attachment_id": attachmentID,
+	}).Error
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"my-property/go-service/pubsub"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxPinnedMessages caps how many messages a room can pin
+const maxPinnedMessages = 10
+
+// ErrPinLimitReached is returned when a room already has the maximum number of pins
+var ErrPinLimitReached = fmt.Errorf("a room can pin at most %d messages", maxPinnedMessages)
+
+// PinnedMessagesEvent carries a room's full pin list after it changes, in display order
+type PinnedMessagesEvent struct {
+	RoomID uint                       `json:"room_id"`
+	Pins   []models.ChatPinnedMessage `json:"pins"`
+}
+
+// PinnedMessagesTopic is the pubsub topic pin changes for a room are published on
+func PinnedMessagesTopic(roomID uint) string {
+	return fmt.Sprintf("CHAT_PINS_%d", roomID)
+}
+
+// PinMessage pins a message at position, or at the end when position is nil.
+// Pinning an already pinned message moves it. Only room moderators and above may pin.
+func (s *ChatService) PinMessage(messageID, userID uint, userType string, position *int) ([]models.ChatPinnedMessage, error) {
+	var message models.ChatMessage
+	if err := s.db.Where("id = ? AND is_deleted = ?", messageID, false).First(&message).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RequireRole(message.RoomID, userID, userType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		pins, err := s.loadPins(tx, message.RoomID)
+		if err != nil {
+			return err
+		}
+
+		// Take the message out of the list if it is already pinned, then insert it where requested
+		var pin *models.ChatPinnedMessage
+		for i := range pins {
+			if pins[i].MessageID == messageID {
+				existing := pins[i]
+				pin = &existing
+				pins = append(pins[:i], pins[i+1:]...)
+				break
+			}
+		}
+		if pin == nil {
+			if len(pins) >= maxPinnedMessages {
+				return ErrPinLimitReached
+			}
+			pin = &models.ChatPinnedMessage{
+				RoomID:     message.RoomID,
+				MessageID:  messageID,
+				PinnedBy:   userID,
+				PinnerType: userType,
+				PinnedAt:   time.Now(),
+			}
+		}
+
+		index := len(pins)
+		if position != nil && *position >= 0 && *position < len(pins) {
+			index = *position
+		}
+		pins = append(pins[:index], append([]models.ChatPinnedMessage{*pin}, pins[index:]...)...)
+
+		return s.savePinOrder(tx, pins)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.publishPins(message.RoomID)
+}
+
+// UnpinMessage removes a pin and closes the gap it leaves. Only room moderators and above may unpin.
+func (s *ChatService) UnpinMessage(messageID, userID uint, userType string) ([]models.ChatPinnedMessage, error) {
+	var pin models.ChatPinnedMessage
+	if err := s.db.Where("message_id = ?", messageID).First(&pin).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("message %d is not pinned", messageID)
+		}
+		return nil, err
+	}
+
+	if _, err := s.RequireRole(pin.RoomID, userID, userType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := s.removePin(tx, pin.RoomID, messageID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return s.publishPins(pin.RoomID)
+}
+
+func (s *ChatService) GetPinnedMessages(roomID, userID uint, userType string) ([]models.ChatPinnedMessage, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
+	return s.loadPins(s.db, roomID)
+}
+
+// removePin deletes a room's pin for a message, if any, renumbers the rest and reports whether a pin was removed
+func (s *ChatService) removePin(tx *gorm.DB, roomID, messageID uint) (bool, error) {
+	result := tx.Where("room_id = ? AND message_id = ?", roomID, messageID).Delete(&models.ChatPinnedMessage{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	pins, err := s.loadPins(tx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return true, s.savePinOrder(tx, pins)
+}
+
+func (s *ChatService) loadPins(tx *gorm.DB, roomID uint) ([]models.ChatPinnedMessage, error) {
+	var pins []models.ChatPinnedMessage
+	err := tx.Where("room_id = ?", roomID).
+		Preload("Message").
+		Preload("Message.Attachments").
+		Order("position ASC").
+		Find(&pins).Error
+	return pins, err
+}
+
+// savePinOrder writes positions matching the slice order, creating pins that are new
+func (s *ChatService) savePinOrder(tx *gorm.DB, pins []models.ChatPinnedMessage) error {
+	for i := range pins {
+		pins[i].Position = i
+		if pins[i].ID == 0 {
+			if err := tx.Omit("Message").Create(&pins[i]).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tx.Model(&models.ChatPinnedMessage{}).Where("id = ?", pins[i].ID).Update("position", i).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishPins reloads a room's pins and fans them out to subscribers
+func (s *ChatService) publishPins(roomID uint) ([]models.ChatPinnedMessage, error) {
+	pins, err := s.loadPins(s.db, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	pubsub.GetInstance().Publish(PinnedMessagesTopic(roomID), &PinnedMessagesEvent{
+		RoomID: roomID,
+		Pins:   pins,
+	})
+
+	return pins, nil
+}
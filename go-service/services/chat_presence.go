@@ -0,0 +1,47 @@
+package services
+
+import (
+	"my-property/go-service/presence"
+)
+
+// SetTyping records an ephemeral typing indicator for a room member; nothing is written to the database
+func (s *ChatService) SetTyping(roomID, userID uint, userType string, isTyping bool) error {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return err
+	}
+
+	presence.GetInstance().SetTyping(roomID, presence.User{ID: userID, Type: userType}, isTyping)
+	return nil
+}
+
+// VisibleParticipants filters candidates down to users who share an active room with the caller,
+// so presence is only exposed to people you are chatting with
+func (s *ChatService) VisibleParticipants(userID uint, userType string, candidates []ParticipantRef) ([]ParticipantRef, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var shared []ParticipantRef
+	err := s.db.Table("chat_participants AS other").
+		Select("DISTINCT other.user_id, other.user_type").
+		Joins(`JOIN chat_participants AS self ON self.room_id = other.room_id
+			AND self.user_id = ? AND self.user_type = ? AND self.is_active = ?`, userID, userType, true).
+		Where("other.is_active = ?", true).
+		Scan(&shared).Error
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[ParticipantRef]bool, len(shared))
+	for _, ref := range shared {
+		allowed[ref] = true
+	}
+
+	var visible []ParticipantRef
+	for _, candidate := range candidates {
+		if allowed[candidate] {
+			visible = append(visible, candidate)
+		}
+	}
+	return visible, nil
+}
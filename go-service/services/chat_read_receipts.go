@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"my-property/go-service/pubsub"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReadReceipt tells a room that a participant has seen every message up to LastReadMessageID
+type ReadReceipt struct {
+	RoomID            uint      `json:"room_id"`
+	UserID            uint      `json:"user_id"`
+	UserType          string    `json:"user_type"`
+	LastReadMessageID uint      `json:"last_read_message_id"`
+	ReadAt            time.Time `json:"read_at"`
+}
+
+// ReadReceiptTopic is the pubsub topic read receipts for a room are published on
+func ReadReceiptTopic(roomID uint) string {
+	return fmt.Sprintf("CHAT_READ_RECEIPTS_%d", roomID)
+}
+
+// MarkRoomRead moves the caller's read pointer to messageID, or to the newest message when nil.
+// The pointer never moves backwards.
+func (s *ChatService) MarkRoomRead(roomID, userID uint, userType string, messageID *uint) (*models.ChatParticipant, error) {
+	participant, err := s.RequireParticipant(roomID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	var message models.ChatMessage
+	query := s.db.Where("room_id = ?", roomID)
+	if messageID != nil {
+		query = query.Where("id = ?", *messageID)
+	} else {
+		query = query.Where("is_deleted = ?", false).Order("id DESC")
+	}
+	if err := query.First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if messageID != nil {
+				return nil, fmt.Errorf("message %d not found in room %d", *messageID, roomID)
+			}
+			// Nothing to read yet
+			return participant, nil
+		}
+		return nil, err
+	}
+
+	if participant.LastReadMessageID != nil && *participant.LastReadMessageID >= message.ID {
+		return participant, nil
+	}
+
+	if err := s.advanceReadPointer(participant.ID, message.ID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	participant.LastReadMessageID = &message.ID
+	participant.LastReadAt = &now
+
+	pubsub.GetInstance().Publish(ReadReceiptTopic(roomID), &ReadReceipt{
+		RoomID:            roomID,
+		UserID:            userID,
+		UserType:          userType,
+		LastReadMessageID: message.ID,
+		ReadAt:            now,
+	})
+
+	return participant, nil
+}
+
+// advanceReadPointer moves a participant's read pointer forward in a single statement so concurrent reads cannot rewind it
+func (s *ChatService) advanceReadPointer(participantID, messageID uint) error {
+	return s.db.Model(&models.ChatParticipant{}).
+		Where("id = ? AND (last_read_message_id IS NULL OR last_read_message_id < ?)", participantID, messageID).
+		Updates(map[string]interface{}{
+			"last_read_message_id": messageID,
+			"last_read_at":         time.Now(),
+		}).Error
+}
+
+// populateRoomSummaries fills in the caller's unread count and the newest message of each room, counting only
+// messages the caller's timeline shows, then orders the rooms by latest activity
+func (s *ChatService) populateRoomSummaries(rooms []models.ChatRoom, userID uint, userType string) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	roomIDs := make([]uint, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.ID)
+	}
+
+	var lastMessages []models.ChatMessage
+	err := s.db.Raw(`SELECT DISTINCT ON (room_id) * FROM chat_messages
+		WHERE room_id IN ? AND is_deleted = ? AND thread_only = ?
+			AND (moderation_status NOT IN ? OR (sender_id = ? AND sender_type = ?))
+		ORDER BY room_id, id DESC`, roomIDs, false, false, withheldStatuses, userID, userType).
+		Scan(&lastMessages).Error
+	if err != nil {
+		return fmt.Errorf("failed to load last messages: %v", err)
+	}
+
+	var unread []struct {
+		RoomID uint
+		Count  int
+	}
+	err = s.db.Table("chat_messages").
+		Select("chat_messages.room_id, COUNT(*) AS count").
+		Joins(`JOIN chat_participants ON chat_participants.room_id = chat_messages.room_id
+			AND chat_participants.user_id = ? AND chat_participants.user_type = ? AND chat_participants.is_active = ?`, userID, userType, true).
+		Where("chat_messages.room_id IN ? AND chat_messages.is_deleted = ? AND chat_messages.thread_only = ?", roomIDs, false, false).
+		Where("chat_messages.moderation_status NOT IN ?", withheldStatuses).
+		Where("chat_messages.id > COALESCE(chat_participants.last_read_message_id, 0)").
+		Where("NOT (chat_messages.sender_id = ? AND chat_messages.sender_type = ?)", userID, userType).
+		Group("chat_messages.room_id").
+		Scan(&unread).Error
+	if err != nil {
+		return fmt.Errorf("failed to count unread messages: %v", err)
+	}
+
+	lastByRoom := make(map[uint]*models.ChatMessage, len(lastMessages))
+	for i := range lastMessages {
+		lastByRoom[lastMessages[i].RoomID] = &lastMessages[i]
+	}
+	unreadByRoom := make(map[uint]int, len(unread))
+	for _, row := range unread {
+		unreadByRoom[row.RoomID] = row.Count
+	}
+
+	for i := range rooms {
+		rooms[i].LastMessage = lastByRoom[rooms[i].ID]
+		rooms[i].UnreadCount = unreadByRoom[rooms[i].ID]
+	}
+
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return lastActivity(rooms[i]).After(lastActivity(rooms[j]))
+	})
+
+	return nil
+}
+
+func lastActivity(room models.ChatRoom) time.Time {
+	if room.LastMessage != nil {
+		return room.LastMessage.CreatedAt
+	}
+	return room.CreatedAt
+}
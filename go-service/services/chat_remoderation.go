@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"my-property/go-service/models"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxRemoderationAttempts is how often a pending item may fail re-moderation before it is left for a moderator
+const maxRemoderationAttempts = 5
+
+// StartRemoderationJob re-checks content queued as "pending" while the AI service was unavailable,
+// every interval until ctx is cancelled
+func (s *ChatService) StartRemoderationJob(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checked, failed, err := s.RemoderatePending(100)
+				if err != nil {
+					log.Printf("Re-moderation job error: %v", err)
+				}
+				if checked > 0 || failed > 0 {
+					log.Printf("Re-moderation job checked %d pending chat items, %d failed", checked, failed)
+				}
+			}
+		}
+	}()
+}
+
+// RemoderatePending moderates up to limit pending messages and attachments, oldest first, and returns how many
+// were checked and how many failed. A failed item is logged, counted against maxRemoderationAttempts and stays
+// pending; the run stops early only when the AI service becomes unavailable.
+func (s *ChatService) RemoderatePending(limit int) (int, int, error) {
+	if !s.moderation.Available() {
+		return 0, 0, nil
+	}
+
+	var messages []models.ChatMessage
+	err := s.db.Where("moderation_status = ? AND is_deleted = ? AND moderation_attempts < ?", ModerationStatusPending, false, maxRemoderationAttempts).
+		Order("id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	checked, failed := 0, 0
+	for i := range messages {
+		if !s.moderation.Available() {
+			return checked, failed, nil
+		}
+		if err := s.remoderateMessage(&messages[i]); err != nil {
+			log.Printf("Re-moderation of message %d failed: %v", messages[i].ID, err)
+			s.countRemoderationAttempt(&models.ChatMessage{}, messages[i].ID)
+			failed++
+			continue
+		}
+		checked++
+	}
+
+	var attachments []models.ChatAttachment
+	err = s.db.Preload("Message").
+		Where("moderation_status = ? AND moderation_attempts < ?", ModerationStatusPending, maxRemoderationAttempts).
+		Order("id ASC").
+		Limit(limit).
+		Find(&attachments).Error
+	if err != nil {
+		return checked, failed, err
+	}
+
+	for i := range attachments {
+		if !s.moderation.Available() {
+			return checked, failed, nil
+		}
+		if err := s.remoderateAttachment(&attachments[i]); err != nil {
+			log.Printf("Re-moderation of attachment %d failed: %v", attachments[i].ID, err)
+			s.countRemoderationAttempt(&models.ChatAttachment{}, attachments[i].ID)
+			failed++
+			continue
+		}
+		checked++
+	}
+
+	return checked, failed, nil
+}
+
+// countRemoderationAttempt records a failed attempt on a message or attachment that is still pending
+func (s *ChatService) countRemoderationAttempt(model interface{}, id uint) {
+	err := s.db.Model(model).
+		Where("id = ? AND moderation_status = ?", id, ModerationStatusPending).
+		Update("moderation_attempts", gorm.Expr("moderation_attempts + 1")).Error
+	if err != nil {
+		log.Printf("Failed to count re-moderation attempt: %v", err)
+	}
+}
+
+func (s *ChatService) remoderateMessage(message *models.ChatMessage) error {
+	// The content rules apply as they do to new messages; the message was already counted against the rate limit
+	verdict := s.moderationRules.CheckContent(message.Content)
+
+	var moderationResult *ModerationResponse
+	if verdict.Decision == RuleDecisionAllow || verdict.Decision == RuleDecisionBlock {
+		moderationResult = verdict.Response()
+	} else {
+		var err error
+		moderationResult, err = s.ModerateMessage(message.Content, "", message.SenderID, message.SenderType, message.RoomID)
+		if err != nil {
+			return err
+		}
+		applyRuleFlag(verdict, moderationResult)
+	}
+
+	logEntry, err := s.LogModerationEvent(message.SenderID, message.SenderType, message.RoomID, &message.ID, "text", message.Content, moderationResult)
+	if err != nil {
+		fmt.Printf("Failed to log moderation event: %v\n", err)
+	}
+
+	status := moderationStatusOf(moderationResult)
+	if !moderationResult.Allowed {
+		status = ModerationStatusBlocked
+	}
+
+	// Only update if nothing else decided in the meantime
+	updated := false
+	var mentions []models.ChatMention
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ChatMessage{}).
+			Where("id = ? AND moderation_status = ?", message.ID, ModerationStatusPending).
+			Updates(map[string]interface{}{
+				"moderation_status": status,
+				"is_moderated":      true,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		updated = true
+		message.ModerationStatus = status
+		message.IsModerated = true
+
+		if status == ModerationStatusBlocked {
+			return nil
+		}
+		// Edits of messages that were already delivered are not delivered again
+		if message.IsEdited {
+			return publishEdit(tx, message, message.SenderID, message.SenderType)
+		}
+		if err := tx.Where("message_id = ?", message.ID).Find(&mentions).Error; err != nil {
+			return err
+		}
+		return s.publishMessage(tx, message)
+	})
+	if err != nil || !updated {
+		return err
+	}
+
+	if status == ModerationStatusBlocked {
+		if logEntry != nil {
+			s.notifyModeration(logEntry, fmt.Sprintf("Your message was blocked by moderation: %s (appeal with moderation log %d)", moderationResult.Reason, logEntry.ID))
+		}
+		return nil
+	}
+	if !message.IsEdited {
+		s.notifyDelivery(message, mentions)
+	}
+
+	return nil
+}
+
+func (s *ChatService) remoderateAttachment(attachment *models.ChatAttachment) error {
+	// The AI service reads the plaintext from disk, as it does for new uploads
+	tempPath := filepath.Join(s.uploadDir, fmt.Sprintf("remoderate_%d%s", attachment.ID, filepath.Ext(attachment.FileName)))
+	defer os.Remove(tempPath)
+
+	if err := s.encryptionService.DecryptFile(attachment.EncryptedPath, tempPath, attachment.EncryptedKey); err != nil {
+		return fmt.Errorf("attachment %d: %v", attachment.ID, err)
+	}
+
+	message := attachment.Message
+	moderationResult, err := s.ModerateMessage("", tempPath, message.SenderID, message.SenderType, message.RoomID)
+	if err != nil {
+		return err
+	}
+
+	logEntry, err := s.LogModerationEvent(message.SenderID, message.SenderType, message.RoomID, &message.ID, "file", "File: "+attachment.FileName, moderationResult)
+	if err != nil {
+		fmt.Printf("Failed to log moderation event: %v\n", err)
+	}
+	if err := linkModerationLog(s.db, logEntry, message.ID, &attachment.ID); err != nil {
+		fmt.Printf("Failed to link moderation log: %v\n", err)
+	}
+
+	status := moderationStatusOf(moderationResult)
+	if !moderationResult.Allowed {
+		status = ModerationStatusBlocked
+	}
+
+	err = s.db.Model(&models.ChatAttachment{}).
+		Where("id = ? AND moderation_status = ?", attachment.ID, ModerationStatusPending).
+		Updates(map[string]interface{}{
+			"moderation_status": status,
+			"is_moderated":      true,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if status == ModerationStatusBlocked && logEntry != nil {
+		s.notifyModeration(logEntry, fmt.Sprintf("Your file %q was blocked by moderation: %s (appeal with moderation log %d)", attachment.FileName, moderationResult.Reason, logEntry.ID))
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"my-property/go-service/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Retention Management
+// CreateRetentionRule needs a room admin for a rule on one room and a platform admin for a rule on a room type
+func (s *ChatService) CreateRetentionRule(actorID uint, actorType, name, roomType string, roomID *uint, maxAgeDays int) (*models.RetentionRule, error) {
+	if roomType == "" && roomID == nil {
+		return nil, fmt.Errorf("retention rule needs a room type or a room")
+	}
+	if err := s.requireRetentionAdmin(roomID, actorID, actorType); err != nil {
+		return nil, err
+	}
+	if maxAgeDays <= 0 {
+		return nil, fmt.Errorf("max age must be at least one day")
+	}
+
+	rule := &models.RetentionRule{
+		Name:       name,
+		RoomType:   roomType,
+		RoomID:     roomID,
+		MaxAgeDays: maxAgeDays,
+		IsActive:   true,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	if err := s.db.Create(rule).Error; err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// GetRetentionRules lists every rule for platform admins, and the rules of the rooms they administer for others
+func (s *ChatService) GetRetentionRules(userID uint, userType string) ([]models.RetentionRule, error) {
+	query := s.db.Order("created_at ASC")
+	if !s.platformRoles.Has(userID, userType, PlatformRoleAdmin) {
+		administered := s.db.Model(&models.ChatParticipant{}).
+			Select("room_id").
+			Where("user_id = ? AND user_type = ? AND is_active = ? AND role IN ?", userID, userType, true, []string{models.ChatRoleAdmin, models.ChatRoleOwner})
+		query = query.Where("room_id IN (?)", administered)
+	}
+
+	var rules []models.RetentionRule
+	err := query.Find(&rules).Error
+	return rules, err
+}
+
+func (s *ChatService) DeleteRetentionRule(ruleID, actorID uint, actorType string) error {
+	var rule models.RetentionRule
+	if err := s.db.First(&rule, ruleID).Error; err != nil {
+		return err
+	}
+	if err := s.requireRetentionAdmin(rule.RoomID, actorID, actorType); err != nil {
+		return err
+	}
+
+	return s.db.Delete(&models.RetentionRule{}, ruleID).Error
+}
+
+// requireRetentionAdmin checks the caller may manage retention of one room, or of all rooms when roomID is nil
+func (s *ChatService) requireRetentionAdmin(roomID *uint, userID uint, userType string) error {
+	if s.platformRoles.Has(userID, userType, PlatformRoleAdmin) {
+		return nil
+	}
+	if roomID == nil {
+		return s.RequirePlatformRole(userID, userType, PlatformRoleAdmin)
+	}
+
+	_, err := s.RequireRole(*roomID, userID, userType, models.ChatRoleAdmin)
+	return err
+}
+
+// ApplyRetentionRules deletes attachments that have outlived every active rule and returns how many were removed
+func (s *ChatService) ApplyRetentionRules() (int, error) {
+	var rules []models.RetentionRule
+	if err := s.db.Where("is_active = ?", true).Find(&rules).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, rule := range rules {
+		count, err := s.applyRetentionRule(rule)
+		removed += count
+		if err != nil {
+			return removed, fmt.Errorf("retention rule %d: %v", rule.ID, err)
+		}
+
+		now := time.Now()
+		s.db.Model(&models.RetentionRule{}).Where("id = ?", rule.ID).Update("last_run_at", now)
+	}
+
+	return removed, nil
+}
+
+// StartRetentionJob applies retention rules every interval until ctx is cancelled
+func (s *ChatService) StartRetentionJob(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				removed, err := s.ApplyRetentionRules()
+				if err != nil {
+					log.Printf("Retention job error: %v", err)
+				}
+				if removed > 0 {
+					log.Printf("Retention job removed %d chat attachments", removed)
+				}
+			}
+		}
+	}()
+}
+
+func (s *ChatService) applyRetentionRule(rule models.RetentionRule) (int, error) {
+	cutoff := time.Now().AddDate(0, 0, -rule.MaxAgeDays)
+	removed := 0
+
+	for {
+		query := s.db.Model(&models.ChatAttachment{}).
+			Joins("JOIN chat_messages ON chat_attachments.message_id = chat_messages.id").
+			Joins("JOIN chat_rooms ON chat_messages.room_id = chat_rooms.id").
+			Where("chat_attachments.uploaded_at < ?", cutoff)
+		if rule.RoomID != nil {
+			query = query.Where("chat_rooms.id = ?", *rule.RoomID)
+		}
+		if rule.RoomType != "" {
+			query = query.Where("chat_rooms.type = ?", rule.RoomType)
+		}
+
+		var attachments []models.ChatAttachment
+		if err := query.Limit(100).Find(&attachments).Error; err != nil {
+			return removed, err
+		}
+		if len(attachments) == 0 {
+			return removed, nil
+		}
+
+		for _, attachment := range attachments {
+			var orphaned []string
+			err := s.db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				orphaned, err = s.removeAttachment(tx, attachment)
+				return err
+			})
+			if err != nil {
+				return removed, err
+			}
+			removeFiles(orphaned)
+			removed++
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"my-property/go-service/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockMessage reloads a message and locks its row until tx ends, so edits of it are applied one at a time
+func lockMessage(tx *gorm.DB, message *models.ChatMessage) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(message, message.ID).Error
+}
+
+// saveRevision numbers and stores the version of a message that an edit is about to replace.
+// The message row must be locked with lockMessage in the same transaction.
+func (s *ChatService) saveRevision(tx *gorm.DB, revision *models.ChatMessageRevision) error {
+	var latest int
+	err := tx.Model(&models.ChatMessageRevision{}).
+		Where("message_id = ?", revision.MessageID).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+
+	revision.Revision = latest + 1
+	return tx.Create(revision).Error
+}
+
+// GetMessageRevisions returns earlier versions of a message, oldest first; only room moderators and above may view them
+func (s *ChatService) GetMessageRevisions(messageID, userID uint, userType string) ([]models.ChatMessageRevision, error) {
+	var message models.ChatMessage
+	if err := s.db.First(&message, messageID).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RequireRole(message.RoomID, userID, userType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+
+	var revisions []models.ChatMessageRevision
+	err := s.db.Where("message_id = ?", messageID).Order("revision ASC").Find(&revisions).Error
+	return revisions, err
+}
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sanction types
+const (
+	SanctionMute     = "mute"
+	SanctionKick     = "kick"
+	SanctionBan      = "ban"
+	SanctionSlowMode = "slow_mode"
+)
+
+// Actions checked against sanctions
+const (
+	sanctionActionMessage  = "message"
+	sanctionActionUpload   = "upload"
+	sanctionActionReaction = "reaction"
+)
+
+var (
+	ErrMuted            = errors.New("you are muted")
+	ErrBanned           = errors.New("you are banned")
+	ErrSlowMode         = errors.New("slow mode is on in this room")
+	ErrSanctionLifted   = errors.New("sanction has already been lifted")
+	ErrInvalidSanction  = errors.New("invalid sanction type")
+	ErrCannotSanctionUp = errors.New("cannot sanction a member with an equal or higher role")
+)
+
+// escalationStep issues a platform-wide sanction when a sender collects Count moderation hits of Severity within Window
+type escalationStep struct {
+	Severity string
+	Count    int64
+	Window   time.Duration
+	Type     string
+	Duration time.Duration
+}
+
+// Strongest steps first; only the first matching step is applied
+var escalationSteps = []escalationStep{
+	{Severity: "high", Count: 6, Window: 7 * 24 * time.Hour, Type: SanctionBan, Duration: 7 * 24 * time.Hour},
+	{Severity: "high", Count: 3, Window: 24 * time.Hour, Type: SanctionMute, Duration: 24 * time.Hour},
+	{Severity: "medium", Count: 5, Window: 24 * time.Hour, Type: SanctionMute, Duration: time.Hour},
+}
+
+// Sanction Management
+// SanctionParticipant mutes, kicks or bans a participant for duration (zero for no expiry).
+// Room sanctions need a moderator who outranks the target; a nil roomID issues a platform-wide sanction,
+// which needs a platform moderator.
+func (s *ChatService) SanctionParticipant(roomID *uint, actorID uint, actorType string, target ParticipantRef, sanctionType, reason string, duration time.Duration) (*models.ChatSanction, error) {
+	if sanctionType != SanctionMute && sanctionType != SanctionKick && sanctionType != SanctionBan {
+		return nil, ErrInvalidSanction
+	}
+	if !participantTypes[target.UserType] {
+		return nil, fmt.Errorf("invalid participant type: %s", target.UserType)
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required for sanctions")
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("sanction duration cannot be negative")
+	}
+	if target.UserID == actorID && target.UserType == actorType {
+		return nil, fmt.Errorf("cannot sanction yourself")
+	}
+
+	var targetParticipant *models.ChatParticipant
+	if roomID != nil {
+		actor, err := s.RequireRole(*roomID, actorID, actorType, models.ChatRoleModerator)
+		if err != nil {
+			return nil, err
+		}
+
+		// Non-members can still be banned pre-emptively
+		participant, err := s.RequireParticipant(*roomID, target.UserID, target.UserType)
+		switch {
+		case err == nil:
+			if roleRank[participant.Role] >= roleRank[actor.Role] {
+				return nil, ErrCannotSanctionUp
+			}
+			targetParticipant = participant
+		case errors.Is(err, ErrNotRoomMember):
+			if sanctionType != SanctionBan {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	} else {
+		if sanctionType == SanctionKick {
+			return nil, fmt.Errorf("kicks only apply to a room")
+		}
+		if err := s.RequirePlatformRole(actorID, actorType, PlatformRoleModerator); err != nil {
+			return nil, err
+		}
+		if s.platformRoles.Has(target.UserID, target.UserType, PlatformRoleAdmin) && !s.platformRoles.Has(actorID, actorType, PlatformRoleAdmin) {
+			return nil, ErrCannotSanctionUp
+		}
+	}
+
+	sanction := &models.ChatSanction{
+		RoomID:     roomID,
+		UserID:     target.UserID,
+		UserType:   target.UserType,
+		Type:       sanctionType,
+		Reason:     reason,
+		IssuedBy:   &actorID,
+		IssuerType: actorType,
+		CreatedAt:  time.Now(),
+	}
+	if duration > 0 {
+		sanction.ExpiresAt = &[]time.Time{time.Now().Add(duration)}[0]
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sanction).Error; err != nil {
+			return err
+		}
+		// Kicked and banned members leave the room straight away
+		if targetParticipant != nil && sanctionType != SanctionMute {
+			return s.deactivateParticipant(tx, targetParticipant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifySanction(sanction)
+
+	return sanction, nil
+}
+
+// SetSlowMode limits how often members can post in a room. A zero interval turns slow mode off.
+func (s *ChatService) SetSlowMode(roomID, actorID uint, actorType string, interval time.Duration, reason string) (*models.ChatSanction, error) {
+	if _, err := s.RequireRole(roomID, actorID, actorType, models.ChatRoleModerator); err != nil {
+		return nil, err
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("slow mode interval cannot be negative")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required for sanctions")
+	}
+
+	var sanction *models.ChatSanction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one slow mode is active per room
+		var current []models.ChatSanction
+		if err := activeSanctions(tx).Where("room_id = ? AND type = ?", roomID, SanctionSlowMode).Find(&current).Error; err != nil {
+			return err
+		}
+		for i := range current {
+			if err := liftSanction(tx, &current[i], actorID, actorType, reason); err != nil {
+				return err
+			}
+		}
+
+		if interval == 0 {
+			return nil
+		}
+
+		sanction = &models.ChatSanction{
+			RoomID:          &roomID,
+			Type:            SanctionSlowMode,
+			IntervalSeconds: int(interval / time.Second),
+			Reason:          reason,
+			IssuedBy:        &actorID,
+			IssuerType:      actorType,
+			CreatedAt:       time.Now(),
+		}
+		return tx.Create(sanction).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sanction, nil
+}
+
+// LiftSanction ends a sanction early. Room sanctions need a room moderator; platform sanctions need a platform moderator.
+func (s *ChatService) LiftSanction(sanctionID, actorID uint, actorType, reason string) (*models.ChatSanction, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to lift a sanction")
+	}
+
+	var sanction models.ChatSanction
+	if err := s.db.First(&sanction, sanctionID).Error; err != nil {
+		return nil, err
+	}
+	if sanction.LiftedAt != nil {
+		return nil, ErrSanctionLifted
+	}
+	if sanction.RoomID != nil {
+		if _, err := s.RequireRole(*sanction.RoomID, actorID, actorType, models.ChatRoleModerator); err != nil {
+			return nil, err
+		}
+	} else if err := s.RequirePlatformRole(actorID, actorType, PlatformRoleModerator); err != nil {
+		return nil, err
+	}
+
+	if err := liftSanction(s.db, &sanction, actorID, actorType, reason); err != nil {
+		return nil, err
+	}
+
+	return &sanction, nil
+}
+
+// GetSanctions lists a room's sanctions for its moderators, or platform-wide ones for platform moderators
+// when roomID is nil, newest first
+func (s *ChatService) GetSanctions(roomID *uint, userID uint, userType string, activeOnly bool) ([]models.ChatSanction, error) {
+	query := s.db.Model(&models.ChatSanction{})
+	if activeOnly {
+		query = activeSanctions(s.db)
+	}
+
+	if roomID != nil {
+		if _, err := s.RequireRole(*roomID, userID, userType, models.ChatRoleModerator); err != nil {
+			return nil, err
+		}
+		query = query.Where("room_id = ?", *roomID)
+	} else {
+		if err := s.RequirePlatformRole(userID, userType, PlatformRoleModerator); err != nil {
+			return nil, err
+		}
+		query = query.Where("room_id IS NULL")
+	}
+
+	var sanctions []models.ChatSanction
+	err := query.Order("created_at DESC").Find(&sanctions).Error
+	return sanctions, err
+}
+
+// enforceSanctions rejects an action from a muted or banned participant, and messages sent too soon in slow mode
+func (s *ChatService) enforceSanctions(participant *models.ChatParticipant, action string) error {
+	var sanctions []models.ChatSanction
+	err := activeSanctions(s.db).
+		Where("(room_id = ? OR room_id IS NULL)", participant.RoomID).
+		Where("(user_id = ? AND user_type = ?) OR type = ?", participant.UserID, participant.UserType, SanctionSlowMode).
+		Find(&sanctions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, sanction := range sanctions {
+		switch sanction.Type {
+		case SanctionBan:
+			return sanctionError(ErrBanned, sanction)
+		case SanctionMute:
+			return sanctionError(ErrMuted, sanction)
+		case SanctionSlowMode:
+			if action != sanctionActionMessage || roleRank[participant.Role] >= roleRank[models.ChatRoleModerator] {
+				continue
+			}
+			if err := s.checkSlowMode(participant, sanction); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *ChatService) checkSlowMode(participant *models.ChatParticipant, sanction models.ChatSanction) error {
+	var last models.ChatMessage
+	err := s.db.Where("room_id = ? AND sender_id = ? AND sender_type = ?", participant.RoomID, participant.UserID, participant.UserType).
+		Order("created_at DESC").
+		First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wait := time.Until(last.CreatedAt.Add(time.Duration(sanction.IntervalSeconds) * time.Second))
+	if wait > 0 {
+		return fmt.Errorf("%w: wait %s before sending another message", ErrSlowMode, wait.Round(time.Second))
+	}
+	return nil
+}
+
+// requireNotBanned rejects a user banned from a room or from the platform; roomID nil checks platform bans only
+func (s *ChatService) requireNotBanned(roomID *uint, ref ParticipantRef) error {
+	query := activeSanctions(s.db).
+		Where("user_id = ? AND user_type = ? AND type IN ?", ref.UserID, ref.UserType, []string{SanctionBan, SanctionKick})
+	if roomID != nil {
+		query = query.Where("room_id = ? OR room_id IS NULL", *roomID)
+	} else {
+		query = query.Where("room_id IS NULL")
+	}
+
+	var sanction models.ChatSanction
+	err := query.Order("created_at DESC").First(&sanction).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Kicks without an expiry only remove the member; timed kicks keep them out until they expire
+	if sanction.Type == SanctionKick && sanction.ExpiresAt == nil {
+		return nil
+	}
+	return sanctionError(ErrBanned, sanction)
+}
+
+// escalateSanctions applies the first escalation step a sender's recent moderation hits reach
+func (s *ChatService) escalateSanctions(userID uint, userType string) {
+	for _, step := range escalationSteps {
+		var hits int64
+		err := s.db.Model(&models.ChatModerationLog{}).
+			Where("user_id = ? AND user_type = ? AND severity = ?", userID, userType, step.Severity).
+			Where("(flagged = ? OR allowed = ?) AND created_at > ?", true, false, time.Now().Add(-step.Window)).
+			Count(&hits).Error
+		if err != nil {
+			fmt.Printf("Failed to count moderation hits: %v\n", err)
+			return
+		}
+		if hits < step.Count {
+			continue
+		}
+
+		// Do not stack a sanction on top of an active one of the same kind
+		var active int64
+		err = activeSanctions(s.db).Model(&models.ChatSanction{}).
+			Where("room_id IS NULL AND user_id = ? AND user_type = ? AND type = ?", userID, userType, step.Type).
+			Count(&active).Error
+		if err != nil {
+			fmt.Printf("Failed to check active sanctions: %v\n", err)
+			return
+		}
+		if active > 0 {
+			return
+		}
+
+		expiresAt := time.Now().Add(step.Duration)
+		sanction := &models.ChatSanction{
+			UserID:     userID,
+			UserType:   userType,
+			Type:       step.Type,
+			Reason:     fmt.Sprintf("Automatic: %d %s-severity moderation hits within %s", hits, step.Severity, step.Window),
+			IssuerType: "system",
+			ExpiresAt:  &expiresAt,
+			CreatedAt:  time.Now(),
+		}
+		if err := s.db.Create(sanction).Error; err != nil {
+			fmt.Printf("Failed to create automatic sanction: %v\n", err)
+			return
+		}
+		s.notifySanction(sanction)
+		return
+	}
+}
+
+// notifySanction tells a sanctioned participant what happened and for how long
+func (s *ChatService) notifySanction(sanction *models.ChatSanction) {
+	until := "until lifted"
+	if sanction.ExpiresAt != nil {
+		until = "until " + sanction.ExpiresAt.Format(time.RFC3339)
+	}
+	scope := "on the platform"
+	var roomID uint
+	if sanction.RoomID != nil {
+		scope = fmt.Sprintf("in room %d", *sanction.RoomID)
+		roomID = *sanction.RoomID
+	}
+
+	text := fmt.Sprintf("You were %s %s %s: %s", sanctionVerb(sanction.Type), scope, until, sanction.Reason)
+	if sanction.Type == SanctionKick && sanction.ExpiresAt == nil {
+		text = fmt.Sprintf("You were removed %s: %s", scope, sanction.Reason)
+	}
+
+	notification := &models.ChatNotification{
+		UserID:    sanction.UserID,
+		UserType:  sanction.UserType,
+		RoomID:    roomID,
+		Type:      "moderation",
+		Message:   text,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(notification).Error; err != nil {
+		fmt.Printf("Failed to create sanction notification: %v\n", err)
+	}
+}
+
+// activeSanctions scopes a query to sanctions that are neither lifted nor expired
+func activeSanctions(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.ChatSanction{}).
+		Where("lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+func liftSanction(tx *gorm.DB, sanction *models.ChatSanction, actorID uint, actorType, reason string) error {
+	now := time.Now()
+	sanction.LiftedAt = &now
+	sanction.LiftedBy = &actorID
+	sanction.LifterType = actorType
+	sanction.LiftReason = reason
+	return tx.Model(sanction).Updates(map[string]interface{}{
+		"lifted_at":   now,
+		"lifted_by":   actorID,
+		"lifter_type": actorType,
+		"lift_reason": reason,
+	}).Error
+}
+
+func sanctionError(base error, sanction models.ChatSanction) error {
+	if sanction.ExpiresAt != nil {
+		return fmt.Errorf("%w until %s: %s", base, sanction.ExpiresAt.Format(time.RFC3339), sanction.Reason)
+	}
+	return fmt.Errorf("%w: %s", base, sanction.Reason)
+}
+
+func sanctionVerb(sanctionType string) string {
+	switch sanctionType {
+	case SanctionMute:
+		return "muted"
+	case SanctionKick:
+		return "kicked"
+	default:
+		return "banned"
+	}
+}
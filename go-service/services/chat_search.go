@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"my-property/go-service/models"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Text search configurations and the generated tsvector column each one is indexed in
+var searchLanguages = map[string]string{
+	"english": "search_en",
+	"arabic":  "search_ar",
+}
+
+// Private-use characters mark highlights inside ts_headline output so the snippet can be escaped safely
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+// MessageSearchFilter narrows a full-text search; zero values mean "any"
+type MessageSearchFilter struct {
+	Query         string
+	RoomID        *uint
+	SenderID      *uint
+	SenderType    string
+	From          *time.Time
+	To            *time.Time
+	MessageType   string
+	HasAttachment *bool
+	Language      string // "english" or "arabic"; empty picks from the query's script
+	Limit         int
+	Offset        int
+}
+
+// MessageSearchResult is a matching message with its relevance and an HTML snippet with <mark> highlights
+type MessageSearchResult struct {
+	Message models.ChatMessage `json:"message"`
+	Snippet string             `json:"snippet"`
+	Rank    float64            `json:"rank"`
+}
+
+// Search runs a ranked full-text search over every room the caller is an active member of
+func (s *ChatService) Search(userID uint, userType string, filter MessageSearchFilter) ([]MessageSearchResult, error) {
+	query := strings.TrimSpace(filter.Query)
+	if query == "" {
+		return nil, fmt.Errorf("search query is required")
+	}
+
+	language := filter.Language
+	if language == "" {
+		language = detectSearchLanguage(query)
+	}
+	column, ok := searchLanguages[language]
+	if !ok {
+		return nil, fmt.Errorf("unsupported search language: %s", language)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	tsQuery := "websearch_to_tsquery(?::regconfig, ?)"
+	headlineOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5", highlightStart, highlightStop)
+
+	db := s.db.Table("chat_messages").
+		Select(fmt.Sprintf("chat_messages.id, ts_rank(chat_messages.%s, %s) AS rank, ts_headline(?::regconfig, chat_messages.content, %s, ?) AS snippet", column, tsQuery, tsQuery),
+			language, query, language, language, query, headlineOptions).
+		Joins(`JOIN chat_participants ON chat_participants.room_id = chat_messages.room_id
+			AND chat_participants.user_id = ? AND chat_participants.user_type = ? AND chat_participants.is_active = ?`, userID, userType, true).
+		Where(fmt.Sprintf("chat_messages.%s @@ %s", column, tsQuery), language, query).
+		Where("chat_messages.is_deleted = ?", false).
+		Where("chat_messages.moderation_status NOT IN ? OR (chat_messages.sender_id = ? AND chat_messages.sender_type = ?)", withheldStatuses, userID, userType)
+
+	if filter.RoomID != nil {
+		db = db.Where("chat_messages.room_id = ?", *filter.RoomID)
+	}
+	if filter.SenderID != nil {
+		db = db.Where("chat_messages.sender_id = ?", *filter.SenderID)
+	}
+	if filter.SenderType != "" {
+		db = db.Where("chat_messages.sender_type = ?", filter.SenderType)
+	}
+	if filter.From != nil {
+		db = db.Where("chat_messages.created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("chat_messages.created_at < ?", *filter.To)
+	}
+	if filter.MessageType != "" {
+		db = db.Where("chat_messages.message_type = ?", filter.MessageType)
+	}
+	if filter.HasAttachment != nil {
+		exists := "EXISTS (SELECT 1 FROM chat_attachments WHERE chat_attachments.message_id = chat_messages.id)"
+		if !*filter.HasAttachment {
+			exists = "NOT " + exists
+		}
+		db = db.Where(exists)
+	}
+
+	var hits []struct {
+		ID      uint
+		Rank    float64
+		Snippet string
+	}
+	err := db.Order("rank DESC").
+		Order("chat_messages.created_at DESC").
+		Limit(limit).
+		Offset(filter.Offset).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+	if len(hits) == 0 {
+		return []MessageSearchResult{}, nil
+	}
+
+	ids := make([]uint, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+
+	var messages []models.ChatMessage
+	err = s.db.Where("id IN ?", ids).
+		Preload("Attachments").
+		Preload("Reactions").
+		Preload("Entities").
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.ChatMessage, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+	}
+
+	// Keep the ranked order of the hits
+	results := make([]MessageSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		message, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, MessageSearchResult{
+			Message: message,
+			Snippet: highlightSnippet(hit.Snippet),
+			Rank:    hit.Rank,
+		})
+	}
+
+	return results, nil
+}
+
+// detectSearchLanguage uses the Arabic configuration when the query contains Arabic script
+func detectSearchLanguage(query string) string {
+	for _, r := range query {
+		if unicode.Is(unicode.Arabic, r) {
+			return "arabic"
+		}
+	}
+	return "english"
+}
+
+// highlightSnippet escapes message text and turns highlight markers into <mark> tags
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
@@ -1,16 +1,16 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"my-property/go-service/models"
+	"my-property/go-service/presence"
 	"my-property/go-service/utils"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -18,10 +18,17 @@ import (
 
 type ChatService struct {
 	db                *gorm.DB
-	kafkaService      *utils.KafkaService
+	broker            utils.Broker
+	rooms             *utils.RoomRouter
 	encryptionService *utils.EncryptionService
+	blobStore         *BlobStore
+	uploadPolicy      *UploadPolicyEngine
+	thumbnails        *ThumbnailGenerator
+	quotas            *StorageQuotaService
+	moderation        *ModerationClient
+	moderationRules   *ModerationRuleEngine
+	platformRoles     *PlatformRoles
 	uploadDir         string
-	aiServiceURL      string
 }
 
 // Moderation types
@@ -39,9 +46,11 @@ type ModerationResponse struct {
 	Reason   string `json:"reason,omitempty"`
 	Severity string `json:"severity,omitempty"` // "low", "medium", "high"
 	Flagged  bool   `json:"flagged"`
+	Pending  bool   `json:"-"` // Set when the service was unavailable and the content is queued for re-moderation
+	RuleID   string `json:"-"` // Local rule that decided or flagged the content, empty for AI decisions
 }
 
-func NewChatService(db *gorm.DB, kafkaService *utils.KafkaService, encryptionService *utils.EncryptionService) *ChatService {
+func NewChatService(db *gorm.DB, broker utils.Broker, encryptionService *utils.EncryptionService) *ChatService {
 	uploadDir := "./uploads/chat"
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		panic(fmt.Sprintf("Failed to create upload directory: %v", err))
@@ -49,19 +58,80 @@ func NewChatService(db *gorm.DB, kafkaService *utils.KafkaService, encryptionSer
 
 	return &ChatService{
 		db:                db,
-		kafkaService:      kafkaService,
+		broker:            broker,
+		rooms:             utils.NewRoomRouter(broker),
 		encryptionService: encryptionService,
+		blobStore:         NewBlobStore(db, encryptionService),
+		uploadPolicy:      NewUploadPolicyEngine(db, encryptionService, utils.NewVirusScannerFromEnv()),
+		thumbnails:        NewThumbnailGenerator(),
+		quotas:            NewStorageQuotaService(db),
+		moderation:        NewModerationClientFromEnv(),
+		moderationRules:   NewModerationRuleEngineFromEnv(),
+		platformRoles:     NewPlatformRolesFromEnv(),
 		uploadDir:         uploadDir,
-		aiServiceURL:      "http://python-ai-service:8000",
 	}
 }
 
-func (s *ChatService) KafkaService() *utils.KafkaService {
-	return s.kafkaService
+// SubscribeToRoom registers a handler for the messages of a room and returns a function that removes it
+func (s *ChatService) SubscribeToRoom(roomID uint, handler utils.MessageHandler) (func(), error) {
+	return s.rooms.SubscribeToRoom(roomID, handler)
+}
+
+// SetStorageQuota changes an owner's storage limit; only platform admins can, so room admins cannot lift their own
+func (s *ChatService) SetStorageQuota(actorID uint, actorType, ownerType string, ownerID uint, limitBytes int64) (*models.StorageQuota, error) {
+	if err := s.RequirePlatformRole(actorID, actorType, PlatformRoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.quotas.SetQuota(ownerType, ownerID, limitBytes)
+}
+
+// GetStorageUsage shows an owner's usage to platform admins, to room admins for their room and to a company
+// or developer for itself
+func (s *ChatService) GetStorageUsage(userID uint, userType, ownerType string, ownerID uint) (*models.StorageQuota, error) {
+	if !s.platformRoles.Has(userID, userType, PlatformRoleAdmin) {
+		switch {
+		case ownerType == QuotaOwnerRoom:
+			if _, err := s.RequireRole(ownerID, userID, userType, models.ChatRoleAdmin); err != nil {
+				return nil, err
+			}
+		case ownerType != userType || ownerID != userID:
+			return nil, s.RequirePlatformRole(userID, userType, PlatformRoleAdmin)
+		}
+	}
+	return s.quotas.GetUsage(ownerType, ownerID)
 }
 
 // Room Management
-func (s *ChatService) CreateRoom(name, description, roomType string, createdBy uint, participantIDs []uint) (*models.ChatRoom, error) {
+func (s *ChatService) CreateRoom(name, description, roomType string, createdBy uint, creatorType string, participants []ParticipantRef) (*models.ChatRoom, error) {
+	creator := ParticipantRef{UserID: createdBy, UserType: creatorType}
+	if !participantTypes[creator.UserType] {
+		return nil, fmt.Errorf("invalid participant type: %s", creator.UserType)
+	}
+
+	// Drop duplicates and the creator, who is added as owner below
+	seen := map[ParticipantRef]bool{creator: true}
+	var members []ParticipantRef
+	for _, participant := range participants {
+		if !participantTypes[participant.UserType] {
+			return nil, fmt.Errorf("invalid participant type: %s", participant.UserType)
+		}
+		if seen[participant] {
+			continue
+		}
+		seen[participant] = true
+		members = append(members, participant)
+	}
+
+	for _, ref := range append([]ParticipantRef{creator}, members...) {
+		if err := s.requireNotBanned(nil, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if roomType == "direct" && len(members) != 1 {
+		return nil, fmt.Errorf("direct rooms must have exactly one other participant")
+	}
+
 	room := &models.ChatRoom{
 		Name:        name,
 		Description: description,
@@ -78,14 +148,15 @@ func (s *ChatService) CreateRoom(name, description, roomType string, createdBy u
 	}
 
 	// Add participants
-	for _, userID := range participantIDs {
+	for _, member := range members {
 		participant := &models.ChatParticipant{
-			RoomID:   room.ID,
-			UserID:   userID,
-			UserType: "user", // Default to user, can be extended
-			Role:     "member",
-			JoinedAt: time.Now(),
-			IsActive: true,
+			RoomID:    room.ID,
+			UserID:    member.UserID,
+			UserType:  member.UserType,
+			Role:      models.ChatRoleMember,
+			JoinedAt:  time.Now(),
+			IsActive:  true,
+			InvitedBy: &createdBy,
 		}
 		if err := tx.Create(participant).Error; err != nil {
 			tx.Rollback()
@@ -93,12 +164,12 @@ func (s *ChatService) CreateRoom(name, description, roomType string, createdBy u
 		}
 	}
 
-	// Add creator as admin
+	// Add creator as owner
 	creatorParticipant := &models.ChatParticipant{
 		RoomID:   room.ID,
 		UserID:   createdBy,
-		UserType: "user",
-		Role:     "admin",
+		UserType: creatorType,
+		Role:     models.ChatRoleOwner,
 		JoinedAt: time.Now(),
 		IsActive: true,
 	}
@@ -111,53 +182,65 @@ func (s *ChatService) CreateRoom(name, description, roomType string, createdBy u
 	return room, nil
 }
 
-func (s *ChatService) GetRoomsByUser(userID uint) ([]models.ChatRoom, error) {
+// GetRoomsByUser lists the caller's rooms with unread counts and last messages instead of full histories
+func (s *ChatService) GetRoomsByUser(userID uint, userType string) ([]models.ChatRoom, error) {
 	var rooms []models.ChatRoom
 	err := s.db.Joins("JOIN chat_participants ON chat_rooms.id = chat_participants.room_id").
-		Where("chat_participants.user_id = ? AND chat_participants.is_active = ?", userID, true).
-		Preload("Participants").
-		Preload("Messages", "is_deleted = ?", false).
+		Where("chat_participants.user_id = ? AND chat_participants.user_type = ? AND chat_participants.is_active = ?", userID, userType, true).
+		Preload("Participants", "is_active = ?", true).
 		Find(&rooms).Error
-	return rooms, err
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.populateRoomSummaries(rooms, userID, userType); err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
 }
 
-func (s *ChatService) GetRoomByID(roomID uint) (*models.ChatRoom, error) {
+func (s *ChatService) GetRoomByID(roomID, userID uint, userType string) (*models.ChatRoom, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
 	var room models.ChatRoom
-	err := s.db.Preload("Participants").
-		Preload("Messages", "is_deleted = ?", false).
+	err := s.db.Preload("Participants", "is_active = ?", true).
+		Preload("Messages", "is_deleted = ? AND (moderation_status NOT IN ? OR (sender_id = ? AND sender_type = ?))", false, withheldStatuses, userID, userType).
 		Preload("Messages.Attachments").
 		Preload("Messages.Reactions").
 		Preload("Messages.ReplyTo").
 		Preload("Messages.Referenced").
+		Preload("Messages.Entities").
 		First(&room, roomID).Error
 	return &room, err
 }
 
 // Message Management
-func (s *ChatService) SendMessage(roomID, senderID uint, senderType, content, messageType string, replyToID, referenceID *uint) (*models.ChatMessage, error) {
-	// AI Moderation for text content
-	moderationResult, err := s.ModerateMessage(content, "", senderID, senderType, roomID)
+// SendMessage posts a message; replies join the thread of the message they answer and
+// threadOnly keeps a reply out of the main room timeline
+func (s *ChatService) SendMessage(roomID, senderID uint, senderType, content, messageType string, replyToID, referenceID *uint, threadOnly bool) (*models.ChatMessage, error) {
+	participant, err := s.RequireParticipant(roomID, senderID, senderType)
 	if err != nil {
-		// Log moderation error but continue (fail open)
-		fmt.Printf("Moderation error: %v\n", err)
-		moderationResult = &ModerationResponse{
-			Allowed: true,
-			Reason:  "Moderation service unavailable",
-		}
+		return nil, err
+	}
+	if err := s.enforceSanctions(participant, sanctionActionMessage); err != nil {
+		return nil, err
 	}
 
-	// Log moderation event
-	s.LogModerationEvent(senderID, senderType, roomID, content, moderationResult)
-
-	// Check if message is allowed
-	if !moderationResult.Allowed {
-		return nil, fmt.Errorf("message blocked by AI moderation: %s", moderationResult.Reason)
+	threadRootID, err := s.resolveThreadRoot(roomID, replyToID)
+	if err != nil {
+		return nil, err
+	}
+	if threadOnly && threadRootID == nil {
+		return nil, fmt.Errorf("only thread replies can be kept out of the timeline")
 	}
 
-	// Set moderation status
-	moderationStatus := "approved"
-	if moderationResult.Flagged {
-		moderationStatus = "flagged"
+	// AI Moderation for text content
+	moderationStatus, moderationLog, err := s.moderateText(content, senderID, senderType, roomID, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	message := &models.ChatMessage{
@@ -168,46 +251,117 @@ func (s *ChatService) SendMessage(roomID, senderID uint, senderType, content, me
 		MessageType:      messageType,
 		ReplyToID:        replyToID,
 		ReferenceID:      referenceID,
+		ThreadRootID:     threadRootID,
+		ThreadOnly:       threadOnly,
 		IsEdited:         false,
 		IsDeleted:        false,
-		IsModerated:      true,
+		IsModerated:      moderationStatus != ModerationStatusPending,
 		ModerationStatus: moderationStatus,
 		CreatedAt:        time.Now(),
 		UpdatedAt:        time.Now(),
 	}
 
-	if err := s.db.Create(message).Error; err != nil {
+	var mentions []models.ChatMention
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		if err := linkModerationLog(tx, moderationLog, message.ID, nil); err != nil {
+			return err
+		}
+		if mentions, err = s.saveMentions(tx, message); err != nil {
+			return err
+		}
+		// Messages waiting for moderation are published once they are approved
+		if moderationStatus != ModerationStatusPending {
+			return s.publishMessage(tx, message)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Publish to Kafka for real-time updates
-	kafkaMessage := &utils.ChatMessage{
-		ID:          message.ID,
-		RoomID:      message.RoomID,
-		SenderID:    message.SenderID,
-		SenderType:  message.SenderType,
-		Content:     message.Content,
-		MessageType: message.MessageType,
-		ReplyToID:   message.ReplyToID,
-		ReferenceID: message.ReferenceID,
-		CreatedAt:   message.CreatedAt,
+	if moderationStatus != ModerationStatusPending {
+		s.notifyDelivery(message, mentions)
 	}
 
-	if err := s.kafkaService.PublishRoomMessage(roomID, kafkaMessage); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Failed to publish message to Kafka: %v\n", err)
+	// Sending ends the sender's typing indicator
+	presence.GetInstance().SetTyping(roomID, presence.User{ID: senderID, Type: senderType}, false)
+
+	// Senders have seen their own message
+	if err := s.advanceReadPointer(participant.ID, message.ID); err != nil {
+		fmt.Printf("Failed to update read pointer: %v\n", err)
 	}
 
 	return message, nil
 }
 
-func (s *ChatService) GetMessages(roomID uint, limit, offset int) ([]models.ChatMessage, error) {
+// notifyDelivery notifies mentioned people and thread followers of a delivered message
+func (s *ChatService) notifyDelivery(message *models.ChatMessage, mentions []models.ChatMention) {
+	s.notifyMentions(message, mentions)
+	if message.ThreadRootID != nil {
+		s.notifyThreadParticipants(message)
+	}
+}
+
+// publishMessage queues a new message's event in tx for real-time updates and counts a reply in its thread.
+// The message id is the idempotency key, so a message is published once however many times it is queued.
+func (s *ChatService) publishMessage(tx *gorm.DB, message *models.ChatMessage) error {
+	kafkaMessage := &utils.ChatMessage{
+		ID:           message.ID,
+		RoomID:       message.RoomID,
+		SenderID:     message.SenderID,
+		SenderType:   message.SenderType,
+		Content:      message.Content,
+		MessageType:  message.MessageType,
+		ReplyToID:    message.ReplyToID,
+		ReferenceID:  message.ReferenceID,
+		ThreadRootID: message.ThreadRootID,
+		ThreadOnly:   message.ThreadOnly,
+		CreatedAt:    message.CreatedAt,
+	}
+
+	// A reply counts towards its thread once members can see it
+	if message.ThreadRootID != nil {
+		if err := refreshThreadReplies(tx, *message.ThreadRootID); err != nil {
+			return err
+		}
+	}
+	return enqueueRoomEvent(tx, message.RoomID, utils.EventChatMessage, fmt.Sprintf("%s:%d", utils.EventChatMessage, message.ID), kafkaMessage)
+}
+
+// publishEdit queues the event for an edit of a delivered message
+func publishEdit(tx *gorm.DB, message *models.ChatMessage, editorID uint, editorType string) error {
+	return enqueueRoomEvent(tx, message.RoomID, utils.EventChatMessageEdited, "", &utils.ChatMessageEditedEvent{
+		MessageID:  message.ID,
+		RoomID:     message.RoomID,
+		Content:    message.Content,
+		EditedBy:   editorID,
+		EditorType: editorType,
+		OccurredAt: message.UpdatedAt,
+	})
+}
+
+// GetMessages returns the room timeline, leaving out thread-only replies
+func (s *ChatService) GetMessages(roomID, userID uint, userType string, limit, offset int) ([]models.ChatMessage, error) {
+	participant, err := s.RequireParticipant(roomID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("room_id = ? AND is_deleted = ? AND thread_only = ?", roomID, false, false)
+	// Moderators still see withheld messages so they can review them; senders see their own
+	if roleRank[participant.Role] < roleRank[models.ChatRoleModerator] {
+		query = query.Where("moderation_status NOT IN ? OR (sender_id = ? AND sender_type = ?)", withheldStatuses, userID, userType)
+	}
+
 	var messages []models.ChatMessage
-	err := s.db.Where("room_id = ? AND is_deleted = ?", roomID, false).
-		Preload("Attachments").
+	err = query.Preload("Attachments").
 		Preload("Reactions").
 		Preload("ReplyTo").
 		Preload("Referenced").
+		Preload("Entities").
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
@@ -215,48 +369,167 @@ func (s *ChatService) GetMessages(roomID uint, limit, offset int) ([]models.Chat
 	return messages, err
 }
 
-func (s *ChatService) EditMessage(messageID, senderID uint, newContent string) (*models.ChatMessage, error) {
-	var message models.ChatMessage
-	if err := s.db.First(&message, messageID).Error; err != nil {
+func (s *ChatService) EditMessage(messageID, senderID uint, senderType, newContent string) (*models.ChatMessage, error) {
+	message, participant, err := s.requireMessageParticipant(messageID, senderID, senderType)
+	if err != nil {
 		return nil, err
 	}
 
-	if message.SenderID != senderID {
+	if message.SenderID != senderID || message.SenderType != senderType {
 		return nil, fmt.Errorf("unauthorized to edit this message")
 	}
+	if err := s.enforceSanctions(participant, sanctionActionMessage); err != nil {
+		return nil, err
+	}
+	if err := requireEditable(message); err != nil {
+		return nil, err
+	}
 
-	message.Content = newContent
-	message.IsEdited = true
-	message.UpdatedAt = time.Now()
+	// Edits go through the same moderation as new messages
+	moderationStatus, _, err := s.moderateText(newContent, senderID, senderType, message.RoomID, &message.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only people newly mentioned by the edit get notified
+	var mentions []models.ChatMention
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent edits of a message are recorded one after another
+		if err := lockMessage(tx, message); err != nil {
+			return err
+		}
+		if err := requireEditable(message); err != nil {
+			return err
+		}
+
+		// Keep the version being replaced
+		revision := &models.ChatMessageRevision{
+			MessageID:        message.ID,
+			Content:          message.Content,
+			ModerationStatus: message.ModerationStatus,
+			EditedBy:         senderID,
+			EditorType:       senderType,
+			CreatedAt:        time.Now(),
+		}
+		if err := s.saveRevision(tx, revision); err != nil {
+			return err
+		}
 
-	if err := s.db.Save(&message).Error; err != nil {
+		message.Content = newContent
+		message.IsEdited = true
+		message.IsModerated = moderationStatus != ModerationStatusPending
+		message.ModerationStatus = moderationStatus
+		message.UpdatedAt = time.Now()
+		if err := tx.Save(message).Error; err != nil {
+			return err
+		}
+		if mentions, err = s.saveMentions(tx, message); err != nil {
+			return err
+		}
+		// Edits waiting for moderation are published once they are approved
+		if moderationStatus != ModerationStatusPending {
+			return publishEdit(tx, message, senderID, senderType)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &message, nil
+	if moderationStatus != ModerationStatusPending {
+		s.notifyMentions(message, mentions)
+	}
+
+	return message, nil
 }
 
-func (s *ChatService) DeleteMessage(messageID, senderID uint) error {
-	var message models.ChatMessage
-	if err := s.db.First(&message, messageID).Error; err != nil {
+// requireEditable rejects edits of deleted messages and of messages moderation blocked or hid, which an edit
+// to harmless text must not bring back
+func requireEditable(message *models.ChatMessage) error {
+	if message.IsDeleted {
+		return fmt.Errorf("message has been deleted")
+	}
+	if message.ModerationStatus == ModerationStatusBlocked || message.ModerationStatus == ModerationStatusHidden {
+		return fmt.Errorf("message was %s by moderation and cannot be edited", message.ModerationStatus)
+	}
+	return nil
+}
+
+func (s *ChatService) DeleteMessage(messageID, senderID uint, senderType string) error {
+	message, participant, err := s.requireMessageParticipant(messageID, senderID, senderType)
+	if err != nil {
 		return err
 	}
 
-	if message.SenderID != senderID {
+	// Moderators and above may remove anyone's message
+	isSender := message.SenderID == senderID && message.SenderType == senderType
+	if !isSender && roleRank[participant.Role] < roleRank[models.ChatRoleModerator] {
 		return fmt.Errorf("unauthorized to delete this message")
 	}
 
+	var wasPinned bool
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		wasPinned, err = s.deleteMessage(tx, message)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if wasPinned {
+		if _, err := s.publishPins(message.RoomID); err != nil {
+			fmt.Printf("Failed to publish pinned messages: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteMessage soft-deletes a message and reports whether it was pinned
+func (s *ChatService) deleteMessage(tx *gorm.DB, message *models.ChatMessage) (bool, error) {
 	message.IsDeleted = true
 	message.UpdatedAt = time.Now()
+	if err := tx.Save(message).Error; err != nil {
+		return false, err
+	}
+	err := enqueueRoomEvent(tx, message.RoomID, utils.EventChatMessageDeleted, "", &utils.ChatMessageDeletedEvent{
+		MessageID:  message.ID,
+		RoomID:     message.RoomID,
+		OccurredAt: message.UpdatedAt,
+	})
+	if err != nil {
+		return false, err
+	}
 
-	return s.db.Save(&message).Error
+	// Deleted messages drop off the pinned bar
+	wasPinned, err := s.removePin(tx, message.RoomID, message.ID)
+	if err != nil {
+		return false, err
+	}
+	if message.ThreadRootID != nil {
+		if err := refreshThreadReplies(tx, *message.ThreadRootID); err != nil {
+			return false, err
+		}
+	}
+	return wasPinned, nil
 }
 
 // File Upload Management
-func (s *ChatService) UploadFile(messageID uint, fileHeader *multipart.FileHeader, file io.Reader) (*models.ChatAttachment, error) {
+func (s *ChatService) UploadFile(messageID, uploaderID uint, uploaderType string, folderID *uint, fileHeader *multipart.FileHeader, file io.Reader) (*models.ChatAttachment, error) {
+	message, participant, err := s.requireMessageParticipant(messageID, uploaderID, uploaderType)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != uploaderID || message.SenderType != uploaderType {
+		return nil, fmt.Errorf("unauthorized to attach files to this message")
+	}
+	if err := s.enforceSanctions(participant, sanctionActionUpload); err != nil {
+		return nil, err
+	}
+
 	// Create unique filename
 	ext := filepath.Ext(fileHeader.Filename)
-	filename := fmt.Sprintf("%d_%d%s", messageID, time.Now().Unix(), ext)
+	filename := fmt.Sprintf("%d_%d%s", messageID, time.Now().UnixNano(), ext)
 	filePath := filepath.Join(s.uploadDir, filename)
 
 	// Save original file temporarily
@@ -275,75 +548,120 @@ func (s *ChatService) UploadFile(messageID uint, fileHeader *multipart.FileHeade
 		return nil, err
 	}
 
-	// Get message to extract user info for moderation
-	var message models.ChatMessage
-	if err := s.db.First(&message, messageID).Error; err != nil {
+	// Check detected type, size and malware before anything else sees the file
+	inspection, err := s.uploadPolicy.Inspect(UploadContextChat, strconv.FormatUint(uint64(messageID), 10), fileHeader.Filename, filePath)
+	if err != nil {
+		os.Remove(filePath)
 		return nil, err
 	}
 
+	if folderID != nil {
+		if _, err := s.getRoomFolder(message.RoomID, *folderID); err != nil {
+			os.Remove(filePath)
+			return nil, err
+		}
+	}
+
 	// AI Moderation for file content
-	moderationResult, err := s.ModerateMessage("", filePath, message.SenderID, message.SenderType, message.RoomID)
+	moderationResult, err := s.screenContent("", filePath, message.SenderID, message.SenderType, message.RoomID)
 	if err != nil {
-		// Log moderation error but continue (fail open)
-		fmt.Printf("File moderation error: %v\n", err)
-		moderationResult = &ModerationResponse{
-			Allowed: true,
-			Reason:  "File moderation service unavailable",
-		}
+		os.Remove(filePath)
+		return nil, err
 	}
 
 	// Log moderation event
-	s.LogModerationEvent(message.SenderID, message.SenderType, message.RoomID, "File: "+fileHeader.Filename, moderationResult)
+	moderationLog, err := s.LogModerationEvent(message.SenderID, message.SenderType, message.RoomID, &message.ID, "file", "File: "+fileHeader.Filename, moderationResult)
+	if err != nil {
+		fmt.Printf("Failed to log moderation event: %v\n", err)
+	}
 
 	// Check if file is allowed
 	if !moderationResult.Allowed {
 		// Remove the temporary file
 		os.Remove(filePath)
+		if moderationLog != nil {
+			return nil, fmt.Errorf("file blocked by AI moderation: %s (appeal with moderation log %d)", moderationResult.Reason, moderationLog.ID)
+		}
 		return nil, fmt.Errorf("file blocked by AI moderation: %s", moderationResult.Reason)
 	}
 
 	// Set moderation status
-	moderationStatus := "approved"
-	if moderationResult.Flagged {
-		moderationStatus = "flagged"
+	moderationStatus := moderationStatusOf(moderationResult)
+
+	// Charge the upload to the room and sender before storing anything
+	quotaOwners := ChatQuotaOwners(message.RoomID, message.SenderID, message.SenderType)
+	if err := s.quotas.Reserve(quotaOwners, inspection.Size); err != nil {
+		os.Remove(filePath)
+		return nil, err
 	}
 
-	// Encrypt the file
-	encryptedPath := filePath + ".enc"
-	encryptedKey, err := s.encryptionService.EncryptFile(filePath, encryptedPath)
+	// Hash and encrypt the file, reusing the stored copy of identical content
+	blob, err := s.blobStore.Put(s.db, filePath)
 	if err != nil {
+		os.Remove(filePath)
+		s.quotas.Release(s.db, quotaOwners, inspection.Size)
 		return nil, err
 	}
 
+	// Render an encrypted preview while the plaintext is still on disk
+	thumbnailPath, thumbnailKey := s.createThumbnail(filePath, inspection.ContentType)
+
 	// Remove original file
 	os.Remove(filePath)
 
 	// Create attachment record
 	attachment := &models.ChatAttachment{
 		MessageID:        messageID,
+		FolderID:         folderID,
 		FileName:         fileHeader.Filename,
-		FileType:         fileHeader.Header.Get("Content-Type"),
-		FileSize:         fileHeader.Size,
-		EncryptedPath:    encryptedPath,
-		EncryptedKey:     encryptedKey,
+		FileType:         inspection.ContentType,
+		FileSize:         inspection.Size,
+		EncryptedPath:    blob.EncryptedPath,
+		EncryptedKey:     blob.EncryptedKey,
+		ContentHash:      blob.ContentHash,
+		ThumbnailPath:    thumbnailPath,
+		ThumbnailKey:     thumbnailKey,
 		UploadedAt:       time.Now(),
-		IsModerated:      true,
+		IsModerated:      !moderationResult.Pending,
 		ModerationStatus: moderationStatus,
 	}
 
 	if err := s.db.Create(attachment).Error; err != nil {
+		orphaned, _ := s.blobStore.Release(s.db, blob.ContentHash)
+		s.quotas.Release(s.db, quotaOwners, inspection.Size)
+		removeFiles([]string{orphaned, thumbnailPath})
 		return nil, err
 	}
 
+	if err := linkModerationLog(s.db, moderationLog, message.ID, &attachment.ID); err != nil {
+		fmt.Printf("Failed to link moderation log: %v\n", err)
+	}
+
 	return attachment, nil
 }
 
-func (s *ChatService) DownloadFile(attachmentID uint) ([]byte, string, error) {
+func (s *ChatService) DownloadFile(attachmentID, userID uint, userType string) ([]byte, string, error) {
 	var attachment models.ChatAttachment
 	if err := s.db.First(&attachment, attachmentID).Error; err != nil {
 		return nil, "", err
 	}
 
+	message, _, err := s.requireMessageParticipant(attachment.MessageID, userID, userType)
+	if err != nil {
+		return nil, "", err
+	}
+	switch attachment.ModerationStatus {
+	case ModerationStatusHidden, ModerationStatusRemoved:
+		return nil, "", fmt.Errorf("attachment was removed by a moderator")
+	case ModerationStatusBlocked:
+		return nil, "", fmt.Errorf("attachment was blocked by moderation")
+	case ModerationStatusPending:
+		// Only the uploader can open a file that is still waiting for moderation
+		if message.SenderID != userID || message.SenderType != userType {
+			return nil, "", fmt.Errorf("attachment is waiting for moderation")
+		}
+	}
+
 	// Create temporary file for decryption
 	tempPath := filepath.Join(s.uploadDir, "temp_"+filepath.Base(attachment.FileName))
 	defer os.Remove(tempPath)
@@ -353,6 +671,11 @@ func (s *ChatService) DownloadFile(attachmentID uint) ([]byte, string, error) {
 		return nil, "", err
 	}
 
+	// Verify the decrypted content against the hash recorded at upload
+	if err := s.blobStore.Verify(tempPath, attachment.ContentHash); err != nil {
+		return nil, "", err
+	}
+
 	// Read decrypted file
 	data, err := os.ReadFile(tempPath)
 	if err != nil {
@@ -362,70 +685,167 @@ func (s *ChatService) DownloadFile(attachmentID uint) ([]byte, string, error) {
 	return data, attachment.FileName, nil
 }
 
-// Reaction Management
-func (s *ChatService) AddReaction(messageID, userID uint, userType, emoji string) (*models.ChatReaction, error) {
-	// Check if reaction already exists
-	var existingReaction models.ChatReaction
-	err := s.db.Where("message_id = ? AND user_id = ? AND user_type = ?", messageID, userID, userType).
-		First(&existingReaction).Error
+// createThumbnail renders and encrypts a preview, returning empty values when none is available
+func (s *ChatService) createThumbnail(filePath, contentType string) (string, string) {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	previewPath := base + "_thumb.jpg"
+	defer os.Remove(previewPath)
 
-	if err == nil {
-		// Update existing reaction
-		existingReaction.Emoji = emoji
-		existingReaction.CreatedAt = time.Now()
-		if err := s.db.Save(&existingReaction).Error; err != nil {
-			return nil, err
-		}
-		return &existingReaction, nil
+	generated, err := s.thumbnails.Generate(filePath, contentType, previewPath)
+	if err != nil {
+		// Previews are best effort and never block the upload
+		fmt.Printf("Thumbnail generation error: %v\n", err)
+		return "", ""
+	}
+	if !generated {
+		return "", ""
 	}
 
-	// Create new reaction
-	reaction := &models.ChatReaction{
-		MessageID: messageID,
-		UserID:    userID,
-		UserType:  userType,
-		Emoji:     emoji,
-		CreatedAt: time.Now(),
+	encryptedPath := base + "_thumb.enc"
+	encryptedKey, err := s.encryptionService.EncryptFile(previewPath, encryptedPath)
+	if err != nil {
+		fmt.Printf("Thumbnail encryption error: %v\n", err)
+		os.Remove(encryptedPath)
+		return "", ""
+	}
+
+	return encryptedPath, encryptedKey
+}
+
+// ThumbnailURL returns a short-lived signed URL for an attachment's preview, or "" if it has none
+func (s *ChatService) ThumbnailURL(attachment *models.ChatAttachment) string {
+	if attachment.ThumbnailPath == "" || isTakenDown(attachment.ModerationStatus) {
+		return ""
 	}
+	return s.encryptionService.SignURL(thumbnailURLPath(attachment.ID), thumbnailURLTTL)
+}
 
-	if err := s.db.Create(reaction).Error; err != nil {
+// ReadThumbnail verifies a signed thumbnail URL and returns the decrypted JPEG
+func (s *ChatService) ReadThumbnail(attachmentID uint, expires, signature string) ([]byte, error) {
+	if err := s.encryptionService.VerifySignedURL(thumbnailURLPath(attachmentID), expires, signature); err != nil {
 		return nil, err
 	}
 
-	return reaction, nil
+	var attachment models.ChatAttachment
+	if err := s.db.First(&attachment, attachmentID).Error; err != nil {
+		return nil, err
+	}
+	if attachment.ThumbnailPath == "" {
+		return nil, fmt.Errorf("attachment has no thumbnail")
+	}
+
+	// A link handed out before moderation took the file or its message down stops working
+	if isTakenDown(attachment.ModerationStatus) {
+		return nil, fmt.Errorf("attachment was removed by moderation")
+	}
+	var message models.ChatMessage
+	if err := s.db.First(&message, attachment.MessageID).Error; err != nil {
+		return nil, err
+	}
+	if message.IsDeleted || message.ModerationStatus == ModerationStatusBlocked || message.ModerationStatus == ModerationStatusHidden {
+		return nil, fmt.Errorf("message was removed")
+	}
+
+	tempPath := filepath.Join(s.uploadDir, fmt.Sprintf("temp_thumb_%d_%d.jpg", attachment.ID, time.Now().UnixNano()))
+	defer os.Remove(tempPath)
+
+	if err := s.encryptionService.DecryptFile(attachment.ThumbnailPath, tempPath, attachment.ThumbnailKey); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(tempPath)
 }
 
-func (s *ChatService) RemoveReaction(messageID, userID uint, userType string) error {
-	return s.db.Where("message_id = ? AND user_id = ? AND user_type = ?", messageID, userID, userType).
-		Delete(&models.ChatReaction{}).Error
+const thumbnailURLTTL = 15 * time.Minute
+
+func thumbnailURLPath(attachmentID uint) string {
+	return fmt.Sprintf("/chat/attachments/%d/thumbnail", attachmentID)
 }
 
-// Folder Management
-func (s *ChatService) CreateFolder(roomID uint, name, description string, parentID *uint, createdBy uint) (*models.ChatFolder, error) {
-	folder := &models.ChatFolder{
-		RoomID:      roomID,
-		Name:        name,
-		Description: description,
-		ParentID:    parentID,
-		CreatedBy:   createdBy,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+// Reaction Management
+func (s *ChatService) AddReaction(messageID, userID uint, userType, emoji string) (*models.ChatReaction, error) {
+	message, participant, err := s.requireMessageParticipant(messageID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.enforceSanctions(participant, sanctionActionReaction); err != nil {
+		return nil, err
 	}
 
-	if err := s.db.Create(folder).Error; err != nil {
+	reaction := &models.ChatReaction{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Check if reaction already exists
+		err := tx.Where("message_id = ? AND user_id = ? AND user_type = ?", messageID, userID, userType).
+			First(reaction).Error
+
+		if err == nil {
+			// Update existing reaction
+			reaction.Emoji = emoji
+			reaction.CreatedAt = time.Now()
+			if err := tx.Save(reaction).Error; err != nil {
+				return err
+			}
+		} else {
+			// Create new reaction
+			reaction = &models.ChatReaction{
+				MessageID: messageID,
+				UserID:    userID,
+				UserType:  userType,
+				Emoji:     emoji,
+				CreatedAt: time.Now(),
+			}
+			if err := tx.Create(reaction).Error; err != nil {
+				return err
+			}
+		}
+
+		return enqueueRoomEvent(tx, message.RoomID, utils.EventChatReactionAdded, "", reactionEvent(reaction, message.RoomID))
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return folder, nil
+	return reaction, nil
 }
 
-func (s *ChatService) GetFolders(roomID uint) ([]models.ChatFolder, error) {
-	var folders []models.ChatFolder
-	err := s.db.Where("room_id = ?", roomID).
-		Preload("Children").
-		Preload("Files").
-		Find(&folders).Error
-	return folders, err
+func (s *ChatService) RemoveReaction(messageID, userID uint, userType string) error {
+	message, _, err := s.requireMessageParticipant(messageID, userID, userType)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var reactions []models.ChatReaction
+		err := tx.Where("message_id = ? AND user_id = ? AND user_type = ?", messageID, userID, userType).
+			Find(&reactions).Error
+		if err != nil || len(reactions) == 0 {
+			return err
+		}
+		if err := tx.Delete(&reactions).Error; err != nil {
+			return err
+		}
+
+		for i := range reactions {
+			event := reactionEvent(&reactions[i], message.RoomID)
+			event.OccurredAt = time.Now()
+			if err := enqueueRoomEvent(tx, message.RoomID, utils.EventChatReactionRemoved, "", event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func reactionEvent(reaction *models.ChatReaction, roomID uint) *utils.ChatReactionEvent {
+	return &utils.ChatReactionEvent{
+		ReactionID: reaction.ID,
+		MessageID:  reaction.MessageID,
+		RoomID:     roomID,
+		UserID:     reaction.UserID,
+		UserType:   reaction.UserType,
+		Emoji:      reaction.Emoji,
+		OccurredAt: reaction.CreatedAt,
+	}
 }
 
 // Notification Management
@@ -447,33 +867,49 @@ func (s *ChatService) CreateNotification(userID uint, userType string, roomID ui
 	return notification, nil
 }
 
-func (s *ChatService) GetNotifications(userID uint) ([]models.ChatNotification, error) {
+func (s *ChatService) GetNotifications(userID uint, userType string) ([]models.ChatNotification, error) {
 	var notifications []models.ChatNotification
-	err := s.db.Where("user_id = ?", userID).
+	err := s.db.Where("user_id = ? AND user_type = ?", userID, userType).
 		Order("created_at DESC").
 		Find(&notifications).Error
 	return notifications, err
 }
 
-func (s *ChatService) MarkNotificationAsRead(notificationID uint) error {
+func (s *ChatService) MarkNotificationAsRead(notificationID, userID uint, userType string) error {
 	return s.db.Model(&models.ChatNotification{}).
-		Where("id = ?", notificationID).
+		Where("id = ? AND user_id = ? AND user_type = ?", notificationID, userID, userType).
 		Update("is_read", true).Error
 }
 
 // Search functionality
-func (s *ChatService) SearchMessages(roomID uint, query string) ([]models.ChatMessage, error) {
-	var messages []models.ChatMessage
-	err := s.db.Where("room_id = ? AND content ILIKE ? AND is_deleted = ?", roomID, "%"+query+"%", false).
-		Preload("Attachments").
-		Preload("Reactions").
-		Order("created_at DESC").
-		Find(&messages).Error
-	return messages, err
+// SearchMessages runs a full-text search within a single room, best matches first
+func (s *ChatService) SearchMessages(roomID, userID uint, userType, query string) ([]models.ChatMessage, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
+	results, err := s.Search(userID, userType, MessageSearchFilter{
+		Query:  query,
+		RoomID: &roomID,
+		Limit:  100,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]models.ChatMessage, 0, len(results))
+	for _, result := range results {
+		messages = append(messages, result.Message)
+	}
+	return messages, nil
 }
 
 // Get message statistics
-func (s *ChatService) GetMessageStats(roomID uint) (map[string]interface{}, error) {
+func (s *ChatService) GetMessageStats(roomID, userID uint, userType string) (map[string]interface{}, error) {
+	if _, err := s.RequireParticipant(roomID, userID, userType); err != nil {
+		return nil, err
+	}
+
 	var stats map[string]interface{}
 
 	var totalMessages int64
@@ -506,38 +942,125 @@ func (s *ChatService) ModerateMessage(content, imagePath string, userID uint, us
 		RoomID:    roomID,
 	}
 
-	data, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal moderation request: %v", err)
+	return s.moderation.Moderate(reqBody)
+}
+
+// moderateText runs text through AI moderation and logs the decision against messageID, which is nil for new messages.
+// It returns the moderation status to store and the log entry, or an error when the content is blocked.
+func (s *ChatService) moderateText(content string, senderID uint, senderType string, roomID uint, messageID *uint) (string, *models.ChatModerationLog, error) {
+	// Local rules settle obvious cases without a round trip to the AI service
+	verdict := s.moderationRules.Check(ParticipantRef{UserID: senderID, UserType: senderType}, content)
+
+	var moderationResult *ModerationResponse
+	if verdict.Decision == RuleDecisionAllow || verdict.Decision == RuleDecisionBlock {
+		moderationResult = verdict.Response()
+	} else {
+		var err error
+		moderationResult, err = s.screenContent(content, "", senderID, senderType, roomID)
+		if err != nil {
+			return "", nil, err
+		}
+		applyRuleFlag(verdict, moderationResult)
 	}
 
-	resp, err := http.Post(s.aiServiceURL+"/moderate", "application/json", bytes.NewBuffer(data))
+	// Log moderation event
+	logEntry, err := s.LogModerationEvent(senderID, senderType, roomID, messageID, "text", content, moderationResult)
 	if err != nil {
-		return nil, fmt.Errorf("AI service error: %v", err)
+		fmt.Printf("Failed to log moderation event: %v\n", err)
 	}
-	defer resp.Body.Close()
 
-	var result ModerationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode AI response: %v", err)
+	// Check if message is allowed
+	if !moderationResult.Allowed {
+		if logEntry != nil {
+			return "", nil, fmt.Errorf("message blocked by moderation: %s (appeal with moderation log %d)", moderationResult.Reason, logEntry.ID)
+		}
+		return "", nil, fmt.Errorf("message blocked by moderation: %s", moderationResult.Reason)
+	}
+
+	return moderationStatusOf(moderationResult), logEntry, nil
+}
+
+// applyRuleFlag keeps a rule flag even when the AI service lets the content through
+func applyRuleFlag(verdict RuleVerdict, moderationResult *ModerationResponse) {
+	if verdict.Decision == RuleDecisionFlag && moderationResult.Allowed && !moderationResult.Flagged {
+		moderationResult.Flagged = true
+		moderationResult.Reason = verdict.Reason
+		moderationResult.Severity = verdict.Severity
+		moderationResult.RuleID = verdict.RuleID
+	}
+}
+
+// screenContent moderates content, applying the room type's failure policy when the AI service is unavailable.
+// Fail-closed rooms get an error; queued content comes back allowed and marked Pending.
+func (s *ChatService) screenContent(content, imagePath string, senderID uint, senderType string, roomID uint) (*ModerationResponse, error) {
+	moderationResult, err := s.ModerateMessage(content, imagePath, senderID, senderType, roomID)
+	if err == nil {
+		return moderationResult, nil
+	}
+	fmt.Printf("Moderation error: %v\n", err)
+
+	var room models.ChatRoom
+	if err := s.db.Select("type").First(&room, roomID).Error; err != nil {
+		return nil, err
+	}
+
+	switch s.moderation.PolicyFor(room.Type) {
+	case ModerationPolicyFailOpen:
+		return &ModerationResponse{
+			Allowed: true,
+			Reason:  "Moderation service unavailable (fail-open)",
+		}, nil
+	case ModerationPolicyFailClosed:
+		return nil, fmt.Errorf("%w, please try again later", ErrModerationUnavailable)
+	default:
+		return &ModerationResponse{
+			Allowed: true,
+			Pending: true,
+			Reason:  "Moderation service unavailable, queued for re-moderation",
+		}, nil
 	}
+}
 
-	return &result, nil
+// moderationStatusOf maps an allowed moderation result to the status stored on the content
+func moderationStatusOf(moderationResult *ModerationResponse) string {
+	switch {
+	case moderationResult.Pending:
+		return ModerationStatusPending
+	case moderationResult.Flagged:
+		return ModerationStatusFlagged
+	default:
+		return ModerationStatusApproved
+	}
 }
 
-// Log moderation event
-func (s *ChatService) LogModerationEvent(userID uint, userType string, roomID uint, content string, moderationResult *ModerationResponse) error {
+// Log moderation event; flagged and blocked content is queued for moderator review
+func (s *ChatService) LogModerationEvent(userID uint, userType string, roomID uint, messageID *uint, contentType, content string, moderationResult *ModerationResponse) (*models.ChatModerationLog, error) {
 	logEntry := &models.ChatModerationLog{
-		UserID:    userID,
-		UserType:  userType,
-		RoomID:    roomID,
-		Content:   content,
-		Allowed:   moderationResult.Allowed,
-		Reason:    moderationResult.Reason,
-		Severity:  moderationResult.Severity,
-		Flagged:   moderationResult.Flagged,
-		CreatedAt: time.Now(),
+		UserID:      userID,
+		UserType:    userType,
+		RoomID:      roomID,
+		MessageID:   messageID,
+		ContentType: contentType,
+		Content:     content,
+		Allowed:     moderationResult.Allowed,
+		Reason:      moderationResult.Reason,
+		Severity:    moderationResult.Severity,
+		Flagged:     moderationResult.Flagged,
+		RuleID:      moderationResult.RuleID,
+		CreatedAt:   time.Now(),
+	}
+	if moderationResult.Flagged || !moderationResult.Allowed {
+		logEntry.ReviewStatus = ReviewStatusPending
+	}
+
+	if err := s.db.Create(logEntry).Error; err != nil {
+		return nil, err
+	}
+
+	// Repeated hits escalate to automatic sanctions
+	if logEntry.ReviewStatus == ReviewStatusPending {
+		s.escalateSanctions(userID, userType)
 	}
 
-	return s.db.Create(logEntry).Error
+	return logEntry, nil
 }
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"my-property/go-service/models"
+	"testing"
+)
+
+func TestRequireEditable(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  models.ChatMessage
+		editable bool
+	}{
+		{"approved", models.ChatMessage{ModerationStatus: ModerationStatusApproved}, true},
+		{"flagged", models.ChatMessage{ModerationStatus: ModerationStatusFlagged}, true},
+		// The sender may fix content that is still waiting for moderation
+		{"pending", models.ChatMessage{ModerationStatus: ModerationStatusPending}, true},
+		{"blocked", models.ChatMessage{ModerationStatus: ModerationStatusBlocked}, false},
+		{"hidden", models.ChatMessage{ModerationStatus: ModerationStatusHidden}, false},
+		{"deleted", models.ChatMessage{ModerationStatus: ModerationStatusApproved, IsDeleted: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := requireEditable(&tt.message)
+			if (err == nil) != tt.editable {
+				t.Errorf("requireEditable = %v, want editable %v", err, tt.editable)
+			}
+		})
+	}
+}
+
+func TestApplyRuleFlag(t *testing.T) {
+	flag := RuleVerdict{Decision: RuleDecisionFlag, RuleID: "link:domain", Reason: "link", Severity: "low"}
+
+	tests := []struct {
+		name    string
+		verdict RuleVerdict
+		result  ModerationResponse
+		want    ModerationResponse
+	}{
+		{"flags allowed content", flag, ModerationResponse{Allowed: true},
+			ModerationResponse{Allowed: true, Flagged: true, Reason: "link", Severity: "low", RuleID: "link:domain"}},
+		{"keeps the AI flag", flag, ModerationResponse{Allowed: true, Flagged: true, Reason: "ai"},
+			ModerationResponse{Allowed: true, Flagged: true, Reason: "ai"}},
+		{"keeps the AI block", flag, ModerationResponse{Allowed: false, Reason: "ai"},
+			ModerationResponse{Allowed: false, Reason: "ai"}},
+		{"no rule verdict", RuleVerdict{}, ModerationResponse{Allowed: true},
+			ModerationResponse{Allowed: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			applyRuleFlag(tt.verdict, &result)
+			if result.Allowed != tt.want.Allowed || result.Flagged != tt.want.Flagged || result.Reason != tt.want.Reason ||
+				result.Severity != tt.want.Severity || result.RuleID != tt.want.RuleID {
+				t.Errorf("applyRuleFlag = %+v, want %+v", result, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MessageThread is a root message with one page of its replies, oldest first
+type MessageThread struct {
+	Root         models.ChatMessage   `json:"root"`
+	Replies      []models.ChatMessage `json:"replies"`
+	TotalReplies int64                `json:"total_replies"`
+}
+
+// Thread Management
+func (s *ChatService) GetThread(rootMessageID, userID uint, userType string, limit, offset int) (*MessageThread, error) {
+	message, participant, err := s.requireMessageParticipant(rootMessageID, userID, userType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Asking for a reply opens the thread it belongs to
+	rootID := message.ID
+	if message.ThreadRootID != nil {
+		rootID = *message.ThreadRootID
+	}
+
+	var root models.ChatMessage
+	if err := s.db.Preload("Attachments").Preload("Reactions").Preload("Entities").First(&root, rootID).Error; err != nil {
+		return nil, err
+	}
+	// Replies outlive a deleted or withheld root, which only its sender and moderators may still read
+	isSender := root.SenderID == userID && root.SenderType == userType
+	if root.IsDeleted || (isWithheld(root.ModerationStatus) && !isSender && roleRank[participant.Role] < roleRank[models.ChatRoleModerator]) {
+		root = threadRootPlaceholder(root)
+	}
+
+	// Session lets the count and the page reuse the same conditions
+	query := s.db.Model(&models.ChatMessage{}).
+		Where("thread_root_id = ? AND is_deleted = ?", rootID, false).
+		Where("moderation_status NOT IN ? OR (sender_id = ? AND sender_type = ?)", withheldStatuses, userID, userType).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var replies []models.ChatMessage
+	err = query.Preload("Attachments").
+		Preload("Reactions").
+		Preload("ReplyTo").
+		Preload("Entities").
+		Order("created_at ASC").
+		Limit(limit).
+		Offset(offset).
+		Find(&replies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageThread{
+		Root:         root,
+		Replies:      replies,
+		TotalReplies: total,
+	}, nil
+}
+
+// threadRootPlaceholder keeps what places a root in the timeline and drops its content
+func threadRootPlaceholder(root models.ChatMessage) models.ChatMessage {
+	return models.ChatMessage{
+		ID:               root.ID,
+		RoomID:           root.RoomID,
+		MessageType:      root.MessageType,
+		ReplyCount:       root.ReplyCount,
+		LastReplyAt:      root.LastReplyAt,
+		IsDeleted:        root.IsDeleted,
+		ModerationStatus: root.ModerationStatus,
+		CreatedAt:        root.CreatedAt,
+		UpdatedAt:        root.UpdatedAt,
+	}
+}
+
+// resolveThreadRoot checks a reply target is in the room and returns the root of its thread
+func (s *ChatService) resolveThreadRoot(roomID uint, replyToID *uint) (*uint, error) {
+	if replyToID == nil {
+		return nil, nil
+	}
+
+	var target models.ChatMessage
+	if err := s.db.Where("id = ? AND room_id = ?", *replyToID, roomID).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("reply target %d not found in room %d", *replyToID, roomID)
+		}
+		return nil, err
+	}
+
+	if target.ThreadRootID != nil {
+		return target.ThreadRootID, nil
+	}
+	return &target.ID, nil
+}
+
+// refreshThreadReplies recounts the replies of a thread that members can see, so withheld replies are not
+// given away by the count. The root is locked first so concurrent replies count each other.
+func refreshThreadReplies(tx *gorm.DB, rootID uint) error {
+	root := models.ChatMessage{ID: rootID}
+	if err := lockMessage(tx, &root); err != nil {
+		return err
+	}
+
+	var summary struct {
+		Count int
+		Last  *time.Time
+	}
+	err := tx.Model(&models.ChatMessage{}).
+		Select("COUNT(*) AS count, MAX(created_at) AS last").
+		Where("thread_root_id = ? AND is_deleted = ? AND moderation_status NOT IN ?", rootID, false, withheldStatuses).
+		Scan(&summary).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.ChatMessage{}).
+		Where("id = ?", rootID).
+		Updates(map[string]interface{}{
+			"reply_count":   summary.Count,
+			"last_reply_at": summary.Last,
+		}).Error
+}
+
+// notifyThreadParticipants tells the root author and everyone who replied before about a new reply
+func (s *ChatService) notifyThreadParticipants(reply *models.ChatMessage) {
+	rootID := *reply.ThreadRootID
+
+	var followers []ParticipantRef
+	err := s.db.Model(&models.ChatMessage{}).
+		Select("DISTINCT sender_id AS user_id, sender_type AS user_type").
+		Where("(id = ? OR thread_root_id = ?) AND is_deleted = ?", rootID, rootID, false).
+		Scan(&followers).Error
+	if err != nil {
+		fmt.Printf("Failed to load thread participants: %v\n", err)
+		return
+	}
+
+	for _, follower := range followers {
+		if follower.UserID == reply.SenderID && follower.UserType == reply.SenderType {
+			continue
+		}
+		// People who left the room stop following its threads
+		if _, err := s.RequireParticipant(reply.RoomID, follower.UserID, follower.UserType); err != nil {
+			continue
+		}
+
+		notification := &models.ChatNotification{
+			UserID:    follower.UserID,
+			UserType:  follower.UserType,
+			RoomID:    reply.RoomID,
+			Type:      "thread_reply",
+			MessageID: &reply.ID,
+			Message:   "New reply in a thread you follow",
+			CreatedAt: time.Now(),
+		}
+		if err := s.db.Create(notification).Error; err != nil {
+			fmt.Printf("Failed to create thread notification: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"my-property/go-service/models"
+	"my-property/go-service/utils"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Dead letter statuses
+const (
+	DeadLetterStatusPending   = "pending"
+	DeadLetterStatusReplayed  = "replayed"
+	DeadLetterStatusDiscarded = "discarded"
+)
+
+// ErrDeadLetterResolved is returned when replaying or discarding a dead letter that was already replayed or discarded
+var ErrDeadLetterResolved = errors.New("dead letter has already been replayed or discarded")
+
+// DeadLetterTopics returns the dead-letter topics of the event streams this service consumes
+func DeadLetterTopics() []string {
+	return []string{
+		utils.DeadLetterTopic(utils.ChatEventsTopic),
+		utils.DeadLetterTopic(utils.FinancialEventsTopic),
+	}
+}
+
+// StartDeadLetterCollector stores messages from the dead-letter topics so they can be inspected, replayed or discarded
+func (s *ChatService) StartDeadLetterCollector() error {
+	return s.broker.ConsumeDeadLetters(DeadLetterTopics(), s.collectDeadLetter)
+}
+
+// collectDeadLetter stores a dead-lettered message once, however often it is read
+func (s *ChatService) collectDeadLetter(message *utils.DeadLetterMessage) error {
+	entry := &models.DeadLetter{
+		DLQTopic:          message.Topic,
+		DLQPartition:      message.Partition,
+		DLQOffset:         message.Offset,
+		Topic:             message.OriginalTopic,
+		OriginalPartition: message.OriginalPartition,
+		OriginalOffset:    message.OriginalOffset,
+		MessageKey:        string(message.Key),
+		Payload:           string(message.Value),
+		Handler:           message.Handler,
+		Error:             message.Error,
+		Attempts:          message.Attempts,
+		Status:            DeadLetterStatusPending,
+		FailedAt:          message.FailedAt,
+		CreatedAt:         time.Now(),
+	}
+	if !utf8.Valid(message.Key) || !utf8.Valid(message.Value) {
+		entry.MessageKey = base64.StdEncoding.EncodeToString(message.Key)
+		entry.Payload = base64.StdEncoding.EncodeToString(message.Value)
+		entry.Encoding = "base64"
+	}
+
+	// Payloads that do not decode leave the event fields empty
+	var event utils.EventEnvelope
+	if err := json.Unmarshal(message.Value, &event); err == nil {
+		entry.EventID = event.ID
+		entry.EventType = event.Type
+	}
+
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "dlq_topic"}, {Name: "dlq_partition"}, {Name: "dlq_offset"}},
+		DoNothing: true,
+	}).Create(entry).Error
+}
+
+// Dead letters hold raw event payloads from every room, so only platform admins can see or resolve them
+
+// GetDeadLetters returns dead letters, newest first. Empty topic and status match all.
+func (s *ChatService) GetDeadLetters(userID uint, userType, topic, status string, limit, offset int) ([]models.DeadLetter, error) {
+	if err := s.RequirePlatformRole(userID, userType, PlatformRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	query := s.db.Model(&models.DeadLetter{})
+	if topic != "" {
+		query = query.Where("topic = ?", topic)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var entries []models.DeadLetter
+	err := query.Order("failed_at DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries).Error
+	return entries, err
+}
+
+func (s *ChatService) GetDeadLetter(id, userID uint, userType string) (*models.DeadLetter, error) {
+	if err := s.RequirePlatformRole(userID, userType, PlatformRoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.findDeadLetter(id)
+}
+
+func (s *ChatService) findDeadLetter(id uint) (*models.DeadLetter, error) {
+	var entry models.DeadLetter
+	if err := s.db.First(&entry, id).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ReplayDeadLetter publishes a pending dead letter to its original topic for the handler that gave up on it.
+// If it fails again it comes back as a new dead letter.
+func (s *ChatService) ReplayDeadLetter(id, userID uint, userType string) (*models.DeadLetter, error) {
+	if err := s.RequirePlatformRole(userID, userType, PlatformRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var entry models.DeadLetter
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.resolveDeadLetter(tx, id, userID, userType, DeadLetterStatusReplayed); err != nil {
+			return err
+		}
+		if err := tx.First(&entry, id).Error; err != nil {
+			return err
+		}
+
+		key, value, err := deadLetterContent(&entry)
+		if err != nil {
+			return err
+		}
+		// Publishing last lets a failed publish roll the status back
+		return s.broker.ReplayDeadLetter(entry.Topic, key, value, entry.Handler)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// DiscardDeadLetter marks a pending dead letter as not to be replayed
+func (s *ChatService) DiscardDeadLetter(id, userID uint, userType string) (*models.DeadLetter, error) {
+	if err := s.RequirePlatformRole(userID, userType, PlatformRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if err := s.resolveDeadLetter(s.db, id, userID, userType, DeadLetterStatusDiscarded); err != nil {
+		return nil, err
+	}
+	return s.findDeadLetter(id)
+}
+
+// resolveDeadLetter moves a pending dead letter to status, failing if someone else resolved it first
+func (s *ChatService) resolveDeadLetter(tx *gorm.DB, id, userID uint, userType, status string) error {
+	updates := map[string]interface{}{
+		"status":        status,
+		"resolved_by":   userID,
+		"resolver_type": userType,
+		"resolved_at":   time.Now(),
+	}
+	if status == DeadLetterStatusReplayed {
+		updates["replay_count"] = gorm.Expr("replay_count + 1")
+	}
+
+	result := tx.Model(&models.DeadLetter{}).
+		Where("id = ? AND status = ?", id, DeadLetterStatusPending).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.findDeadLetter(id); err != nil {
+			return err
+		}
+		return ErrDeadLetterResolved
+	}
+	return nil
+}
+
+func deadLetterContent(entry *models.DeadLetter) ([]byte, []byte, error) {
+	if entry.Encoding != "base64" {
+		return []byte(entry.MessageKey), []byte(entry.Payload), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(entry.MessageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(entry.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
@@ -47,7 +47,13 @@ func (s *FinancialService) CreateSaleTransaction(input CreateSaleTransactionInpu
 		UpdatedAt:     time.Now(),
 	}
 
-	if err := s.db.Create(transaction).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(transaction).Error; err != nil {
+			return err
+		}
+		return enqueueSaleTransactionEvent(tx, utils.EventSaleTransactionCreated, transaction)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -68,13 +74,33 @@ func (s *FinancialService) UpdateSaleTransactionStatus(transactionID uuid.UUID,
 		transaction.CompletedAt = &now
 	}
 
-	if err := s.db.Save(&transaction).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&transaction).Error; err != nil {
+			return err
+		}
+		return enqueueSaleTransactionEvent(tx, utils.EventSaleTransactionStatusChanged, &transaction)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &transaction, nil
 }
 
+// enqueueSaleTransactionEvent queues a transaction event keyed by transaction id
+func enqueueSaleTransactionEvent(tx *gorm.DB, eventType string, transaction *models.SaleTransaction) error {
+	return enqueueEvent(tx, utils.FinancialEventsTopic, transaction.ID.String(), eventType, "", &utils.SaleTransactionEvent{
+		TransactionID: transaction.ID,
+		BuildingID:    transaction.BuildingID,
+		BuyerID:       transaction.BuyerID,
+		SellerID:      transaction.SellerID,
+		AgentID:       transaction.AgentID,
+		Status:        string(transaction.Status),
+		TotalAmount:   transaction.TotalAmount,
+		OccurredAt:    transaction.UpdatedAt,
+	})
+}
+
 func (s *FinancialService) GetSaleTransactions(filters SaleTransactionFilters) ([]models.SaleTransaction, error) {
 	var transactions []models.SaleTransaction
 	query := s.db.Preload("Building").Preload("Buyer").Preload("Seller").Preload("Agent").Preload("Documents")
@@ -132,7 +158,24 @@ func (s *FinancialService) CreateLeaseContract(input CreateLeaseContractInput) (
 		UpdatedAt:         time.Now(),
 	}
 
-	if err := s.db.Create(lease).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(lease).Error; err != nil {
+			return err
+		}
+		return enqueueEvent(tx, utils.FinancialEventsTopic, lease.ID.String(), utils.EventLeaseContractCreated, "", &utils.LeaseContractEvent{
+			LeaseID:     lease.ID,
+			PropertyID:  lease.PropertyID,
+			TenantID:    lease.TenantID,
+			LandlordID:  lease.LandlordID,
+			AgentID:     lease.AgentID,
+			StartDate:   lease.StartDate,
+			EndDate:     lease.EndDate,
+			MonthlyRent: lease.MonthlyRent,
+			Status:      string(lease.Status),
+			OccurredAt:  lease.CreatedAt,
+		})
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -145,6 +188,7 @@ func (s *FinancialService) UpdateLeaseStatus(leaseID uuid.UUID, status models.Le
 		return nil, err
 	}
 
+	previousStatus := lease.Status
 	lease.Status = status
 	lease.UpdatedAt = time.Now()
 
@@ -156,7 +200,22 @@ func (s *FinancialService) UpdateLeaseStatus(leaseID uuid.UUID, status models.Le
 		lease.TerminatedAt = &now
 	}
 
-	if err := s.db.Save(&lease).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&lease).Error; err != nil {
+			return err
+		}
+		return enqueueEvent(tx, utils.FinancialEventsTopic, lease.ID.String(), utils.EventLeaseStatusChanged, "", &utils.LeaseStatusEvent{
+			LeaseID:        lease.ID,
+			PropertyID:     lease.PropertyID,
+			TenantID:       lease.TenantID,
+			LandlordID:     lease.LandlordID,
+			AgentID:        lease.AgentID,
+			Status:         string(lease.Status),
+			PreviousStatus: string(previousStatus),
+			OccurredAt:     lease.UpdatedAt,
+		})
+	})
+	if err != nil {
 		return nil, err
 	}
 
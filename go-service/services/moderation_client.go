@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrModerationUnavailable is returned when the AI service cannot be reached or the circuit breaker is open
+var ErrModerationUnavailable = errors.New("moderation service unavailable")
+
+// Moderation failure policies, chosen per room type
+const (
+	ModerationPolicyFailOpen   = "fail_open"   // Accept content unmoderated
+	ModerationPolicyFailClosed = "fail_closed" // Reject content until the service recovers
+	ModerationPolicyQueue      = "queue"       // Store content as "pending" and re-moderate it later
+)
+
+// ModerationClient calls the AI moderation service with a timeout, retries and a circuit breaker
+type ModerationClient struct {
+	baseURL       string
+	httpClient    *http.Client
+	maxRetries    int
+	retryBackoff  time.Duration
+	breaker       *circuitBreaker
+	defaultPolicy string
+	policies      map[string]string // Room type to failure policy
+}
+
+// NewModerationClientFromEnv configures the client from AI_SERVICE_URL and the MODERATION_* variables.
+// MODERATION_FAILURE_POLICY takes a default policy and room type overrides, e.g. "queue,direct=fail_open,company=fail_closed".
+func NewModerationClientFromEnv() *ModerationClient {
+	baseURL := os.Getenv("AI_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://python-ai-service:8000"
+	}
+
+	client := &ModerationClient{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		httpClient:    &http.Client{Timeout: durationFromEnv("MODERATION_TIMEOUT", 5*time.Second)},
+		maxRetries:    intFromEnv("MODERATION_RETRIES", 2),
+		retryBackoff:  durationFromEnv("MODERATION_RETRY_BACKOFF", 200*time.Millisecond),
+		breaker:       newCircuitBreaker(intFromEnv("MODERATION_BREAKER_THRESHOLD", 5), durationFromEnv("MODERATION_BREAKER_COOLDOWN", 30*time.Second)),
+		defaultPolicy: ModerationPolicyQueue,
+		policies:      make(map[string]string),
+	}
+
+	for _, part := range strings.Split(os.Getenv("MODERATION_FAILURE_POLICY"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		roomType, policy, scoped := strings.Cut(part, "=")
+		if !scoped {
+			policy = roomType
+		}
+		if !validModerationPolicy(policy) {
+			fmt.Printf("Ignoring invalid moderation policy: %s\n", part)
+			continue
+		}
+		if scoped {
+			client.policies[roomType] = policy
+		} else {
+			client.defaultPolicy = policy
+		}
+	}
+
+	return client
+}
+
+// PolicyFor returns the failure policy for a room type
+func (c *ModerationClient) PolicyFor(roomType string) string {
+	if policy, ok := c.policies[roomType]; ok {
+		return policy
+	}
+	return c.defaultPolicy
+}
+
+// Available reports whether the circuit breaker would let a request through
+func (c *ModerationClient) Available() bool {
+	return c.breaker.state() != breakerOpen
+}
+
+// Moderate sends a request to the AI service, retrying network errors, 5xx and 429 responses with backoff.
+// Transport errors, timeouts and 5xx responses count towards the circuit breaker; other rejections mean the
+// service is up and do not. While the breaker is open calls fail fast with ErrModerationUnavailable.
+func (c *ModerationClient) Moderate(request ModerationRequest) (*ModerationResponse, error) {
+	if !c.breaker.allow() {
+		return nil, ErrModerationUnavailable
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal moderation request: %v", err)
+	}
+
+	var lastErr error
+	lastStatus := 0
+	backoff := c.retryBackoff
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		result, status, err := c.post(data)
+		if err == nil {
+			c.breaker.success()
+			return result, nil
+		}
+		lastErr = err
+		lastStatus = status
+		if !isServiceFailure(status) && status != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	if isServiceFailure(lastStatus) {
+		c.breaker.failure()
+	} else {
+		// The service answered, so it is reachable even though it refused this request
+		c.breaker.success()
+	}
+	return nil, fmt.Errorf("%w: %v", ErrModerationUnavailable, lastErr)
+}
+
+// post returns the HTTP status of the response, or 0 when the request never got one
+func (c *ModerationClient) post(data []byte) (*ModerationResponse, int, error) {
+	resp, err := c.httpClient.Post(c.baseURL+"/moderate", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("AI service error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, resp.StatusCode, fmt.Errorf("AI service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result ModerationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to decode AI response: %v", err)
+	}
+
+	return &result, resp.StatusCode, nil
+}
+
+// isServiceFailure reports whether a post outcome means the AI service is down: a transport error or timeout (status 0) or a 5xx
+func isServiceFailure(status int) bool {
+	return status == 0 || status >= 500
+}
+
+func validModerationPolicy(policy string) bool {
+	return policy == ModerationPolicyFailOpen || policy == ModerationPolicyFailClosed || policy == ModerationPolicyQueue
+}
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker opens after threshold consecutive failures and lets a single probe through once cooldown has passed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *circuitBreaker) state() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.stateLocked()
+}
+
+func (b *circuitBreaker) stateLocked() string {
+	if b.failures < b.threshold {
+		return breakerClosed
+	}
+	if time.Since(b.openedAt) < b.cooldown {
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked() {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		// Only one request probes a recovering service
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+func intFromEnv(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
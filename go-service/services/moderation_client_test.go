@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	type step struct {
+		action string // "allow", "success", "failure" or "wait"
+		allow  bool   // For "allow", whether the request is let through
+		state  string // State after the step
+	}
+
+	const cooldown = 20 * time.Millisecond
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below the threshold", []step{
+			{"failure", false, breakerClosed},
+			{"allow", true, breakerClosed},
+			{"success", false, breakerClosed},
+			{"failure", false, breakerClosed},
+			{"allow", true, breakerClosed},
+		}},
+		{"opens at the threshold", []step{
+			{"failure", false, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"allow", false, breakerOpen},
+		}},
+		{"half open lets a single probe through", []step{
+			{"failure", false, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", false, breakerHalfOpen},
+			{"allow", true, breakerHalfOpen},
+			{"allow", false, breakerHalfOpen},
+		}},
+		{"successful probe closes", []step{
+			{"failure", false, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", false, breakerHalfOpen},
+			{"allow", true, breakerHalfOpen},
+			{"success", false, breakerClosed},
+			{"allow", true, breakerClosed},
+			{"allow", true, breakerClosed},
+		}},
+		{"failed probe opens again", []step{
+			{"failure", false, breakerClosed},
+			{"failure", false, breakerOpen},
+			{"wait", false, breakerHalfOpen},
+			{"allow", true, breakerHalfOpen},
+			{"failure", false, breakerOpen},
+			{"allow", false, breakerOpen},
+			{"wait", false, breakerHalfOpen},
+			{"allow", true, breakerHalfOpen},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := newCircuitBreaker(2, cooldown)
+			for i, step := range tt.steps {
+				switch step.action {
+				case "allow":
+					if got := breaker.allow(); got != step.allow {
+						t.Fatalf("step %d: allow() = %v, want %v", i, got, step.allow)
+					}
+				case "success":
+					breaker.success()
+				case "failure":
+					breaker.failure()
+				case "wait":
+					time.Sleep(cooldown + 5*time.Millisecond)
+				}
+				if got := breaker.state(); got != step.state {
+					t.Fatalf("step %d (%s): state %s, want %s", i, step.action, got, step.state)
+				}
+			}
+		})
+	}
+}
+
+func TestModerationClientCountsOnlyServiceFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		attempts  int32 // Requests the server sees for one Moderate call
+		available bool  // Whether the breaker is still closed afterwards
+	}{
+		{"ok", http.StatusOK, 1, true},
+		{"bad request is not retried", http.StatusBadRequest, 1, true},
+		{"rate limit is retried", http.StatusTooManyRequests, 3, true},
+		{"server error opens the breaker", http.StatusInternalServerError, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusOK {
+					w.Write([]byte(`{"allowed":true}`))
+				}
+			}))
+			defer server.Close()
+
+			client := &ModerationClient{
+				baseURL:      server.URL,
+				httpClient:   server.Client(),
+				maxRetries:   2,
+				retryBackoff: time.Millisecond,
+				breaker:      newCircuitBreaker(1, time.Minute),
+			}
+
+			_, err := client.Moderate(ModerationRequest{})
+			if (err == nil) != (tt.status == http.StatusOK) {
+				t.Errorf("Moderate error = %v", err)
+			}
+			if err != nil && !errors.Is(err, ErrModerationUnavailable) {
+				t.Errorf("Moderate error %v does not wrap ErrModerationUnavailable", err)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.attempts {
+				t.Errorf("server saw %d requests, want %d", got, tt.attempts)
+			}
+			if client.Available() != tt.available {
+				t.Errorf("Available() = %v, want %v", client.Available(), tt.available)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Rule decisions; an empty decision leaves the content to the AI service
+const (
+	RuleDecisionAllow = "allow"
+	RuleDecisionBlock = "block"
+	RuleDecisionFlag  = "flag"
+)
+
+// ModerationRuleConfig configures the local pre-filter. It is read from the JSON file at MODERATION_RULES_PATH.
+type ModerationRuleConfig struct {
+	WordLists          map[string][]string `json:"word_lists"`           // Blocked words and phrases by language, e.g. "english", "arabic"
+	ContactAction      string              `json:"contact_action"`       // "block" or "flag" for phone numbers, emails and IBANs
+	AllowedLinkDomains []string            `json:"allowed_link_domains"` // Links to these domains and their subdomains pass
+	LinkAction         string              `json:"link_action"`          // "block" or "flag" for links to other domains
+	RateLimitMessages  int                 `json:"rate_limit_messages"`  // Messages a sender may send within RateLimitWindow, 0 to disable
+	RateLimitWindow    string              `json:"rate_limit_window"`    // Go duration, e.g. "10s"
+}
+
+// RuleVerdict is the outcome of the local rules for one message
+type RuleVerdict struct {
+	Decision string
+	RuleID   string // e.g. "wordlist:english", "contact:phone", "link:domain", "rate:spike"
+	Reason   string
+	Severity string
+}
+
+// Response converts an allow or block verdict into a moderation result
+func (v RuleVerdict) Response() *ModerationResponse {
+	return &ModerationResponse{
+		Allowed:  v.Decision != RuleDecisionBlock,
+		Flagged:  v.Decision == RuleDecisionFlag,
+		Reason:   v.Reason,
+		Severity: v.Severity,
+		RuleID:   v.RuleID,
+	}
+}
+
+var (
+	phonePattern = regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`) // Matched against text with digits folded to ASCII
+	emailPattern = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`)
+	ibanPattern  = regexp.MustCompile(`(?i)\b[a-z]{2}\d{2}(?:\s?[a-z0-9]){11,30}\b`)
+	linkPattern  = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+)
+
+// ModerationRuleEngine is an in-process pre-filter that settles obvious cases before the AI service is called
+type ModerationRuleEngine struct {
+	wordLists      map[string][]string
+	contactAction  string
+	allowedDomains []string
+	linkAction     string
+	rateLimit      int
+	rateWindow     time.Duration
+
+	mu     sync.Mutex
+	recent map[ParticipantRef][]time.Time
+}
+
+// NewModerationRuleEngineFromEnv loads MODERATION_RULES_PATH, falling back to the defaults for anything not set
+func NewModerationRuleEngineFromEnv() *ModerationRuleEngine {
+	config := ModerationRuleConfig{}
+	if path := os.Getenv("MODERATION_RULES_PATH"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &config)
+		}
+		if err != nil {
+			fmt.Printf("Failed to load moderation rules from %s: %v\n", path, err)
+		}
+	}
+
+	return NewModerationRuleEngine(config)
+}
+
+func NewModerationRuleEngine(config ModerationRuleConfig) *ModerationRuleEngine {
+	engine := &ModerationRuleEngine{
+		wordLists:     make(map[string][]string),
+		contactAction: RuleDecisionBlock,
+		linkAction:    RuleDecisionFlag,
+		rateLimit:     10,
+		rateWindow:    10 * time.Second,
+		recent:        make(map[ParticipantRef][]time.Time),
+	}
+
+	for language, words := range config.WordLists {
+		engine.SetWordList(language, words)
+	}
+	if config.ContactAction == RuleDecisionBlock || config.ContactAction == RuleDecisionFlag {
+		engine.contactAction = config.ContactAction
+	}
+	if config.LinkAction == RuleDecisionBlock || config.LinkAction == RuleDecisionFlag {
+		engine.linkAction = config.LinkAction
+	}
+	for _, domain := range config.AllowedLinkDomains {
+		engine.allowedDomains = append(engine.allowedDomains, strings.ToLower(strings.TrimPrefix(domain, ".")))
+	}
+	if config.RateLimitMessages != 0 {
+		engine.rateLimit = config.RateLimitMessages
+	}
+	if window, err := time.ParseDuration(config.RateLimitWindow); err == nil && window > 0 {
+		engine.rateWindow = window
+	}
+
+	return engine
+}
+
+// SetWordList replaces the blocked words and phrases for a language
+func (e *ModerationRuleEngine) SetWordList(language string, words []string) {
+	var normalized []string
+	for _, word := range words {
+		if word = normalizeRuleText(word); word != "" {
+			normalized = append(normalized, word)
+		}
+	}
+	e.wordLists[language] = normalized
+}
+
+// Check counts a new message from sender against the rate limit and then runs the content rules
+func (e *ModerationRuleEngine) Check(sender ParticipantRef, content string) RuleVerdict {
+	if e.rateSpike(sender) {
+		return RuleVerdict{
+			Decision: RuleDecisionBlock,
+			RuleID:   "rate:spike",
+			Reason:   fmt.Sprintf("More than %d messages in %s", e.rateLimit, e.rateWindow),
+			Severity: "low",
+		}
+	}
+	return e.CheckContent(content)
+}
+
+// CheckContent runs the content rules in order of severity. The first block wins; a flag is returned only if
+// nothing blocks. Content with no letters or digits (emoji, punctuation) is allowed without calling the AI service.
+func (e *ModerationRuleEngine) CheckContent(content string) RuleVerdict {
+	if !hasLettersOrDigits(content) {
+		return RuleVerdict{Decision: RuleDecisionAllow, RuleID: "allow:no_text", Reason: "No text to moderate"}
+	}
+
+	normalized := " " + normalizeRuleText(content) + " "
+	for language, words := range e.wordLists {
+		for _, word := range words {
+			if strings.Contains(normalized, " "+word+" ") {
+				return RuleVerdict{
+					Decision: RuleDecisionBlock,
+					RuleID:   "wordlist:" + language,
+					Reason:   "Message contains blocked language",
+					Severity: "high",
+				}
+			}
+		}
+	}
+
+	var flagged *RuleVerdict
+	for _, rule := range e.contactRules(content) {
+		if rule.Decision == RuleDecisionBlock {
+			return rule
+		}
+		if flagged == nil {
+			flagged = &rule
+		}
+	}
+
+	if rule, ok := e.linkRule(content); ok {
+		if rule.Decision == RuleDecisionBlock {
+			return rule
+		}
+		if flagged == nil {
+			flagged = &rule
+		}
+	}
+
+	if flagged != nil {
+		return *flagged
+	}
+	return RuleVerdict{}
+}
+
+// contactRules detects contact details shared to take a deal off-platform
+func (e *ModerationRuleEngine) contactRules(content string) []RuleVerdict {
+	var verdicts []RuleVerdict
+	contact := func(ruleID, reason string) {
+		verdicts = append(verdicts, RuleVerdict{
+			Decision: e.contactAction,
+			RuleID:   ruleID,
+			Reason:   reason,
+			Severity: "medium",
+		})
+	}
+
+	// Numbers written in Arabic-Indic or other digits, or spaced out, count the same
+	for _, match := range phonePattern.FindAllString(foldDigits(content), -1) {
+		if digits := countDigits(match); digits >= 9 && digits <= 15 {
+			contact("contact:phone", "Sharing phone numbers is not allowed")
+			break
+		}
+	}
+	if emailPattern.MatchString(content) {
+		contact("contact:email", "Sharing email addresses is not allowed")
+	}
+	for _, match := range ibanPattern.FindAllString(content, -1) {
+		if validIBAN(match) {
+			contact("contact:iban", "Sharing bank account numbers is not allowed")
+			break
+		}
+	}
+
+	return verdicts
+}
+
+// linkRule checks links against the allow-list
+func (e *ModerationRuleEngine) linkRule(content string) (RuleVerdict, bool) {
+	for _, match := range linkPattern.FindAllString(content, -1) {
+		raw := match
+		if !strings.Contains(strings.ToLower(raw), "://") {
+			raw = "http://" + raw
+		}
+		parsed, err := url.Parse(strings.TrimRight(raw, ".,;:!?)"))
+		if err != nil || !e.domainAllowed(parsed.Hostname()) {
+			return RuleVerdict{
+				Decision: e.linkAction,
+				RuleID:   "link:domain",
+				Reason:   "Links to external sites are not allowed",
+				Severity: "low",
+			}, true
+		}
+	}
+	return RuleVerdict{}, false
+}
+
+func (e *ModerationRuleEngine) domainAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range e.allowedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateSpike records a message from sender and reports whether they exceeded the rate limit
+func (e *ModerationRuleEngine) rateSpike(sender ParticipantRef) bool {
+	if e.rateLimit <= 0 {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-e.rateWindow)
+	recent := e.recent[sender][:0]
+	for _, sentAt := range e.recent[sender] {
+		if sentAt.After(cutoff) {
+			recent = append(recent, sentAt)
+		}
+	}
+	recent = append(recent, now)
+	e.recent[sender] = recent
+
+	// Drop idle senders so the map does not grow without bound
+	if len(e.recent) > 10000 {
+		for ref, times := range e.recent {
+			if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+				delete(e.recent, ref)
+			}
+		}
+	}
+
+	return len(recent) > e.rateLimit
+}
+
+// normalizeRuleText lowercases text and collapses everything but letters and digits into single spaces
+func normalizeRuleText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func hasLettersOrDigits(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func countDigits(text string) int {
+	count := 0
+	for _, r := range text {
+		if unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// foldDigits replaces decimal digits of any script with ASCII digits. A digit's value is its distance from the
+// start of its run of digits, which is exact for scripts with a single block of ten such as Arabic-Indic,
+// Persian and fullwidth digits.
+func foldDigits(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII || !unicode.IsDigit(r) {
+			return r
+		}
+		zero := r
+		for r-zero < 9 && unicode.IsDigit(zero-1) {
+			zero--
+		}
+		return '0' + (r - zero)
+	}, text)
+}
+
+// validIBAN checks the ISO 13616 mod-97 checksum so ordinary codes are not mistaken for account numbers
+func validIBAN(candidate string) bool {
+	iban := strings.ToUpper(strings.ReplaceAll(candidate, " ", ""))
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		default:
+			return false
+		}
+	}
+
+	value, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(value, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModerationRuleEngineCheckContent(t *testing.T) {
+	engine := NewModerationRuleEngine(ModerationRuleConfig{
+		WordLists:          map[string][]string{"english": {"scam", "wire the deposit"}},
+		AllowedLinkDomains: []string{"my-property.com"},
+	})
+
+	tests := []struct {
+		name     string
+		content  string
+		decision string
+		ruleID   string
+	}{
+		{"plain text", "Is the apartment still available?", "", ""},
+		{"emoji only", "👍🏼 !!", RuleDecisionAllow, "allow:no_text"},
+		{"blocked word", "This is a SCAM.", RuleDecisionBlock, "wordlist:english"},
+		{"blocked phrase across punctuation", "Please wire, the deposit today", RuleDecisionBlock, "wordlist:english"},
+		{"blocked word inside another word", "scampi for dinner", "", ""},
+		{"international phone", "Call me on +971 50 123 4567", RuleDecisionBlock, "contact:phone"},
+		{"local phone", "my number is 0501234567", RuleDecisionBlock, "contact:phone"},
+		{"phone without prefix", "text 501-234-5678 after six", RuleDecisionBlock, "contact:phone"},
+		{"arabic-indic phone", "رقمي ٠٥٠١٢٣٤٥٦٧", RuleDecisionBlock, "contact:phone"},
+		{"persian phone", "شماره ۰۹۱۲ ۳۴۵ ۶۷۸۹", RuleDecisionBlock, "contact:phone"},
+		{"price is not a phone", "The rent is 85000 per year, unit 1204", "", ""},
+		{"email", "write to owner.name@example.com", RuleDecisionBlock, "contact:email"},
+		{"external link", "see https://example.org/listing", RuleDecisionFlag, "link:domain"},
+		{"www link", "see www.example.org.", RuleDecisionFlag, "link:domain"},
+		{"allowed link", "see https://my-property.com/p/12", "", ""},
+		{"allowed subdomain", "see https://app.my-property.com/p/12", "", ""},
+		{"lookalike domain", "see https://my-property.com.evil.io/p/12", RuleDecisionFlag, "link:domain"},
+		{"block wins over flag", "https://example.org or owner@example.com", RuleDecisionBlock, "contact:email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := engine.CheckContent(tt.content)
+			if verdict.Decision != tt.decision || verdict.RuleID != tt.ruleID {
+				t.Errorf("CheckContent(%q) = %q %q, want %q %q", tt.content, verdict.Decision, verdict.RuleID, tt.decision, tt.ruleID)
+			}
+		})
+	}
+}
+
+func TestModerationRuleEngineContactRules(t *testing.T) {
+	engine := NewModerationRuleEngine(ModerationRuleConfig{})
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"valid iban", "pay to GB82 WEST 1234 5698 7654 32", []string{"contact:phone", "contact:iban"}},
+		{"compact valid iban", "pay to IE29AIBK93115212345678", []string{"contact:phone", "contact:iban"}},
+		{"invalid iban checksum", "pay to GB00 WEST 1234 5698 7654 32", []string{"contact:phone"}},
+		{"email and phone", "owner@example.com or 0501234567", []string{"contact:phone", "contact:email"}},
+		{"too many digits for a phone", "order 12345678901234567890", nil},
+		{"nothing", "Is parking included?", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, verdict := range engine.contactRules(tt.content) {
+				got = append(got, verdict.RuleID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("contactRules(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerationRuleEngineActions(t *testing.T) {
+	engine := NewModerationRuleEngine(ModerationRuleConfig{ContactAction: RuleDecisionFlag, LinkAction: RuleDecisionBlock})
+
+	if verdict := engine.CheckContent("call 0501234567"); verdict.Decision != RuleDecisionFlag {
+		t.Errorf("phone with contact_action flag = %q, want %q", verdict.Decision, RuleDecisionFlag)
+	}
+	if verdict := engine.CheckContent("see https://example.org"); verdict.Decision != RuleDecisionBlock {
+		t.Errorf("link with link_action block = %q, want %q", verdict.Decision, RuleDecisionBlock)
+	}
+	if verdict := engine.CheckContent("call 0501234567"); !verdict.Response().Allowed || !verdict.Response().Flagged {
+		t.Errorf("flag verdict converted to %+v, want allowed and flagged", verdict.Response())
+	}
+}
+
+func TestModerationRuleEngineRateSpike(t *testing.T) {
+	engine := NewModerationRuleEngine(ModerationRuleConfig{RateLimitMessages: 3, RateLimitWindow: "50ms"})
+	sender := ParticipantRef{UserID: 1, UserType: "user"}
+	other := ParticipantRef{UserID: 1, UserType: "company"}
+
+	for i := 1; i <= 3; i++ {
+		if verdict := engine.Check(sender, "hello"); verdict.RuleID == "rate:spike" {
+			t.Fatalf("message %d within the limit was blocked", i)
+		}
+	}
+	if verdict := engine.Check(sender, "hello"); verdict.Decision != RuleDecisionBlock || verdict.RuleID != "rate:spike" {
+		t.Errorf("message over the limit = %q %q, want a rate:spike block", verdict.Decision, verdict.RuleID)
+	}
+	// The limit is per sender, and a user and a company with the same id are different senders
+	if verdict := engine.Check(other, "hello"); verdict.RuleID == "rate:spike" {
+		t.Error("another sender was blocked by the first sender's rate")
+	}
+	// CheckContent does not count towards the rate
+	if verdict := engine.CheckContent("hello"); verdict.RuleID == "rate:spike" {
+		t.Error("CheckContent applied the rate limit")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if verdict := engine.Check(sender, "hello"); verdict.RuleID == "rate:spike" {
+		t.Error("sender was still blocked after the window passed")
+	}
+}
+
+func TestFoldDigits(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"0123456789", "0123456789"},
+		{"٠١٢٣٤٥٦٧٨٩", "0123456789"},
+		{"۰۱۲۳۴۵۶۷۸۹", "0123456789"},
+		{"０１２３４５６７８９", "0123456789"},
+		{"call ٠٥٠ ١٢٣", "call 050 123"},
+	}
+
+	for _, tt := range tests {
+		if got := foldDigits(tt.in); got != tt.want {
+			t.Errorf("foldDigits(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
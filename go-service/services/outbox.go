@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"my-property/go-service/models"
+	"my-property/go-service/utils"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox statuses
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+)
+
+const (
+	outboxRelayLockID      = 7301 // Postgres advisory lock held by the replica that is relaying
+	outboxMaxBackoff       = 10 * time.Minute
+	outboxSentRetention    = 7 * 24 * time.Hour
+	outboxDefaultBatchSize = 100
+)
+
+// enqueueEvent writes an event to the outbox as part of tx, so it is published if and only if tx commits.
+// An empty idempotencyKey gets a random one; a key that is already queued is ignored.
+func enqueueEvent(tx *gorm.DB, topic, partitionKey, eventType, idempotencyKey string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", eventType, err)
+	}
+	if idempotencyKey == "" {
+		idempotencyKey = eventType + ":" + uuid.NewString()
+	}
+
+	event := &models.OutboxEvent{
+		IdempotencyKey: idempotencyKey,
+		Topic:          topic,
+		PartitionKey:   partitionKey,
+		EventType:      eventType,
+		SchemaVersion:  utils.Events.Version(eventType),
+		Payload:        string(data),
+		TraceParent:    utils.NewTraceContext()["traceparent"],
+		Status:         OutboxStatusPending,
+		NextAttemptAt:  time.Now(),
+		CreatedAt:      time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "idempotency_key"}}, DoNothing: true}).
+		Create(event).Error
+}
+
+// enqueueRoomEvent queues an event on the chat event stream, keyed by room so the room's events stay in order
+func enqueueRoomEvent(tx *gorm.DB, roomID uint, eventType, idempotencyKey string, payload interface{}) error {
+	return enqueueEvent(tx, utils.ChatEventsTopic, strconv.FormatUint(uint64(roomID), 10), eventType, idempotencyKey, payload)
+}
+
+// OutboxRelay publishes queued outbox events to the message broker. Delivery is at-least-once: an event is published
+// again if marking it as sent fails, and consumers drop duplicates by the event id, recorded in a ProcessedEventStore.
+type OutboxRelay struct {
+	db        *gorm.DB
+	broker    utils.Broker
+	batchSize int
+}
+
+func NewOutboxRelay(db *gorm.DB, broker utils.Broker) *OutboxRelay {
+	return &OutboxRelay{
+		db:        db,
+		broker:    broker,
+		batchSize: outboxDefaultBatchSize,
+	}
+}
+
+// StartRelayJob relays pending events every interval until ctx is cancelled
+func (r *OutboxRelay) StartRelayJob(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var lastPrune time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sent, err := r.RelayPending()
+				if err != nil {
+					log.Printf("Outbox relay error: %v", err)
+				}
+				if sent > 0 {
+					log.Printf("Outbox relay published %d events", sent)
+				}
+				if time.Since(lastPrune) >= time.Hour {
+					if err := r.pruneSent(); err != nil {
+						log.Printf("Outbox prune error: %v", err)
+					}
+					lastPrune = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// RelayPending publishes up to one batch of due events in id order. Only one replica relays at a time, and
+// an event waiting for a retry holds back later events with the same partition key, which keeps them in order.
+// No transaction is held while publishing: each event is marked in its own statement once the broker has it.
+func (r *OutboxRelay) RelayPending() (int, error) {
+	sent := 0
+	// The lock belongs to the database session, so the relay keeps one connection until it is released
+	err := r.db.Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", outboxRelayLockID).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		defer func() {
+			if err := conn.Exec("SELECT pg_advisory_unlock(?)", outboxRelayLockID).Error; err != nil {
+				log.Printf("Failed to release outbox relay lock: %v", err)
+			}
+		}()
+
+		now := time.Now()
+		var events []models.OutboxEvent
+		err := conn.Where("status = ? AND next_attempt_at <= ?", OutboxStatusPending, now).
+			Where(`(outbox_events.partition_key = '' OR NOT EXISTS (
+				SELECT 1 FROM outbox_events earlier
+				WHERE earlier.topic = outbox_events.topic AND earlier.partition_key = outbox_events.partition_key
+					AND earlier.status = ? AND earlier.id < outbox_events.id AND earlier.next_attempt_at > ?))`, OutboxStatusPending, now).
+			Order("id ASC").
+			Limit(r.batchSize).
+			Find(&events).Error
+		if err != nil {
+			return err
+		}
+
+		held := make(map[string]bool)
+		for i := range events {
+			event := &events[i]
+			partition := event.Topic + "/" + event.PartitionKey
+			if event.PartitionKey != "" && held[partition] {
+				continue
+			}
+
+			publishErr := r.broker.PublishEvent(event.Topic, event.PartitionKey, outboxEnvelope(event))
+			if publishErr != nil {
+				held[partition] = true
+				if err := r.scheduleRetry(conn, event, publishErr); err != nil {
+					return err
+				}
+				continue
+			}
+
+			sentAt := time.Now()
+			err := conn.Model(event).Updates(map[string]interface{}{
+				"status":   OutboxStatusSent,
+				"attempts": event.Attempts + 1,
+				"sent_at":  &sentAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// outboxEnvelope wraps a queued event; the producer and, if the row has none, the tenant are filled in on publish
+func outboxEnvelope(event *models.OutboxEvent) *utils.EventEnvelope {
+	envelope := &utils.EventEnvelope{
+		ID:            event.IdempotencyKey,
+		Type:          event.EventType,
+		SchemaVersion: event.SchemaVersion,
+		OccurredAt:    event.CreatedAt,
+		Tenant:        event.Tenant,
+		Payload:       json.RawMessage(event.Payload),
+	}
+	if event.TraceParent != "" {
+		envelope.TraceContext = map[string]string{"traceparent": event.TraceParent}
+	}
+	return envelope
+}
+
+// scheduleRetry backs off exponentially, up to outboxMaxBackoff
+func (r *OutboxRelay) scheduleRetry(db *gorm.DB, event *models.OutboxEvent, publishErr error) error {
+	attempts := event.Attempts + 1
+	backoff := outboxMaxBackoff
+	if attempts < 10 {
+		backoff = min(time.Duration(1<<attempts)*time.Second, outboxMaxBackoff)
+	}
+
+	return db.Model(event).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      publishErr.Error(),
+		"next_attempt_at": time.Now().Add(backoff),
+	}).Error
+}
+
+// pruneSent deletes events published longer than outboxSentRetention ago, and the records of events handled
+// that long ago, which are no longer redelivered
+func (r *OutboxRelay) pruneSent() error {
+	cutoff := time.Now().Add(-outboxSentRetention)
+	err := r.db.Where("status = ? AND sent_at < ?", OutboxStatusSent, cutoff).
+		Delete(&models.OutboxEvent{}).Error
+	if err != nil {
+		return err
+	}
+	return r.db.Where("processed_at < ?", cutoff).Delete(&models.ProcessedEvent{}).Error
+}
+
+// ProcessedEventStore keeps the events handled by the shared consumer group in the processed_events table
+type ProcessedEventStore struct {
+	db *gorm.DB
+}
+
+func NewProcessedEventStore(db *gorm.DB) *ProcessedEventStore {
+	return &ProcessedEventStore{db: db}
+}
+
+func (p *ProcessedEventStore) IsProcessed(consumer, eventID string) (bool, error) {
+	var count int64
+	err := p.db.Model(&models.ProcessedEvent{}).
+		Where("consumer = ? AND idempotency_key = ?", consumer, eventID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (p *ProcessedEventStore) MarkProcessed(consumer, eventID string) error {
+	event := &models.ProcessedEvent{
+		Consumer:       consumer,
+		IdempotencyKey: eventID,
+		ProcessedAt:    time.Now(),
+	}
+	return p.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "consumer"}, {Name: "idempotency_key"}},
+		DoNothing: true,
+	}).Create(event).Error
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Platform roles, held across all rooms
+const (
+	PlatformRoleModerator = "moderator"
+	PlatformRoleAdmin     = "admin"
+)
+
+// ErrPlatformRoleRequired is returned when an operation needs a platform role the caller does not hold
+var ErrPlatformRoleRequired = errors.New("platform role required")
+
+var platformRoleRank = map[string]int{
+	PlatformRoleModerator: 1,
+	PlatformRoleAdmin:     2,
+}
+
+// PlatformRoles knows which users administer or moderate the whole platform
+type PlatformRoles struct {
+	roles map[ParticipantRef]string
+}
+
+// NewPlatformRolesFromEnv reads PLATFORM_ADMINS and PLATFORM_MODERATORS, comma-separated lists of "type:id"
+// such as "user:1,company:7". Without them nobody holds a platform role.
+func NewPlatformRolesFromEnv() *PlatformRoles {
+	roles := &PlatformRoles{roles: make(map[ParticipantRef]string)}
+	roles.parse(os.Getenv("PLATFORM_MODERATORS"), PlatformRoleModerator)
+	roles.parse(os.Getenv("PLATFORM_ADMINS"), PlatformRoleAdmin)
+	return roles
+}
+
+func (p *PlatformRoles) parse(value, role string) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		userType, id, ok := strings.Cut(entry, ":")
+		userID, err := strconv.ParseUint(id, 10, 32)
+		if !ok || err != nil || !participantTypes[userType] {
+			log.Printf("Ignoring invalid platform %s %q", role, entry)
+			continue
+		}
+		p.roles[ParticipantRef{UserID: uint(userID), UserType: userType}] = role
+	}
+}
+
+// Has reports whether a user holds role or a higher one
+func (p *PlatformRoles) Has(userID uint, userType, role string) bool {
+	held, ok := p.roles[ParticipantRef{UserID: userID, UserType: userType}]
+	return ok && platformRoleRank[held] >= platformRoleRank[role]
+}
+
+// RequirePlatformRole fails unless the caller holds role or a higher one on the platform
+func (s *ChatService) RequirePlatformRole(userID uint, userType, role string) error {
+	if !s.platformRoles.Has(userID, userType, role) {
+		return fmt.Errorf("%w: %s", ErrPlatformRoleRequired, role)
+	}
+	return nil
+}
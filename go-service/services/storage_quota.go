@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"my-property/go-service/models"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Quota owner types
+const (
+	QuotaOwnerCompany   = "company"
+	QuotaOwnerDeveloper = "developer"
+	QuotaOwnerRoom      = "room"
+)
+
+// ErrQuotaExceeded is returned when an upload would take an owner over its storage limit
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaOwner identifies who an upload is charged to
+type QuotaOwner struct {
+	Type string
+	ID   uint
+}
+
+// StorageQuotaService enforces per-owner storage limits and keeps usage counters current
+type StorageQuotaService struct {
+	db            *gorm.DB
+	defaultLimits map[string]int64
+}
+
+func NewStorageQuotaService(db *gorm.DB) *StorageQuotaService {
+	return &StorageQuotaService{
+		db: db,
+		defaultLimits: map[string]int64{
+			QuotaOwnerCompany:   megabytesFromEnv("COMPANY_STORAGE_QUOTA_MB"),
+			QuotaOwnerDeveloper: megabytesFromEnv("DEVELOPER_STORAGE_QUOTA_MB"),
+			QuotaOwnerRoom:      megabytesFromEnv("ROOM_STORAGE_QUOTA_MB"),
+		},
+	}
+}
+
+// Reserve charges size bytes to every owner, failing without charging anyone if one would go over its limit
+func (q *StorageQuotaService) Reserve(owners []QuotaOwner, size int64) error {
+	return q.db.Transaction(func(tx *gorm.DB) error {
+		for _, owner := range owners {
+			if err := q.ensureQuota(tx, owner); err != nil {
+				return err
+			}
+
+			result := tx.Model(&models.StorageQuota{}).
+				Where("owner_type = ? AND owner_id = ?", owner.Type, owner.ID).
+				Where("limit_bytes = 0 OR used_bytes + ? <= limit_bytes", size).
+				Updates(map[string]interface{}{
+					"used_bytes": gorm.Expr("used_bytes + ?", size),
+					"file_count": gorm.Expr("file_count + 1"),
+					"updated_at": time.Now(),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("%w for %s %d", ErrQuotaExceeded, owner.Type, owner.ID)
+			}
+		}
+		return nil
+	})
+}
+
+// Release returns size bytes to every owner after a file is deleted
+func (q *StorageQuotaService) Release(tx *gorm.DB, owners []QuotaOwner, size int64) error {
+	for _, owner := range owners {
+		err := tx.Model(&models.StorageQuota{}).
+			Where("owner_type = ? AND owner_id = ?", owner.Type, owner.ID).
+			Updates(map[string]interface{}{
+				"used_bytes": gorm.Expr("GREATEST(used_bytes - ?, 0)", size),
+				"file_count": gorm.Expr("GREATEST(file_count - 1, 0)"),
+				"updated_at": time.Now(),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetQuota configures an owner's limit in bytes; 0 removes the limit
+func (q *StorageQuotaService) SetQuota(ownerType string, ownerID uint, limitBytes int64) (*models.StorageQuota, error) {
+	if _, ok := q.defaultLimits[ownerType]; !ok {
+		return nil, fmt.Errorf("invalid quota owner type: %s", ownerType)
+	}
+	if limitBytes < 0 {
+		return nil, fmt.Errorf("quota limit cannot be negative")
+	}
+
+	owner := QuotaOwner{Type: ownerType, ID: ownerID}
+	if err := q.ensureQuota(q.db, owner); err != nil {
+		return nil, err
+	}
+
+	err := q.db.Model(&models.StorageQuota{}).
+		Where("owner_type = ? AND owner_id = ?", ownerType, ownerID).
+		Updates(map[string]interface{}{
+			"limit_bytes": limitBytes,
+			"updated_at":  time.Now(),
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return q.GetUsage(ownerType, ownerID)
+}
+
+// GetUsage returns an owner's limit and current usage
+func (q *StorageQuotaService) GetUsage(ownerType string, ownerID uint) (*models.StorageQuota, error) {
+	var quota models.StorageQuota
+	err := q.db.Where("owner_type = ? AND owner_id = ?", ownerType, ownerID).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Owners that never uploaded have no row yet
+		return &models.StorageQuota{
+			OwnerType:  ownerType,
+			OwnerID:    ownerID,
+			LimitBytes: q.defaultLimits[ownerType],
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (q *StorageQuotaService) ensureQuota(tx *gorm.DB, owner QuotaOwner) error {
+	quota := &models.StorageQuota{
+		OwnerType:  owner.Type,
+		OwnerID:    owner.ID,
+		LimitBytes: q.defaultLimits[owner.Type],
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(quota).Error
+}
+
+// ChatQuotaOwners returns who a chat upload is charged to: the room, plus the sender when it is a company or developer
+func ChatQuotaOwners(roomID, senderID uint, senderType string) []QuotaOwner {
+	owners := []QuotaOwner{{Type: QuotaOwnerRoom, ID: roomID}}
+	if senderType == QuotaOwnerCompany || senderType == QuotaOwnerDeveloper {
+		owners = append(owners, QuotaOwner{Type: senderType, ID: senderID})
+	}
+	return owners
+}
+
+// BuildingQuotaOwners returns the company and developer a listing upload is charged to
+func BuildingQuotaOwners(building models.Building) []QuotaOwner {
+	var owners []QuotaOwner
+	if building.CompanyID != 0 {
+		owners = append(owners, QuotaOwner{Type: QuotaOwnerCompany, ID: building.CompanyID})
+	}
+	if building.DeveloperID != 0 {
+		owners = append(owners, QuotaOwner{Type: QuotaOwnerDeveloper, ID: building.DeveloperID})
+	}
+	return owners
+}
+
+func megabytesFromEnv(key string) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value * 1024 * 1024
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailGenerator renders small JPEG previews of images and the first page of PDFs
+type ThumbnailGenerator struct {
+	maxDimension int
+	maxPixels    int
+	pdfRenderer  string
+	timeout      time.Duration
+}
+
+func NewThumbnailGenerator() *ThumbnailGenerator {
+	pdfRenderer := os.Getenv("PDFTOPPM_PATH")
+	if pdfRenderer == "" {
+		pdfRenderer = "pdftoppm"
+	}
+
+	return &ThumbnailGenerator{
+		maxDimension: 320,
+		maxPixels:    50 * 1000 * 1000,
+		pdfRenderer:  pdfRenderer,
+		timeout:      20 * time.Second,
+	}
+}
+
+// Generate writes a JPEG thumbnail of sourcePath to destPath.
+// It returns false when the content type has no preview or the PDF renderer is not installed.
+func (g *ThumbnailGenerator) Generate(sourcePath, contentType, destPath string) (bool, error) {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return true, g.fromImage(sourcePath, destPath)
+	case contentType == "application/pdf":
+		return g.fromPDF(sourcePath, destPath)
+	default:
+		return false, nil
+	}
+}
+
+func (g *ThumbnailGenerator) fromImage(sourcePath, destPath string) error {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Check dimensions first so a decompression bomb is never fully decoded
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return fmt.Errorf("failed to read image header: %v", err)
+	}
+	if config.Width*config.Height > g.maxPixels {
+		return fmt.Errorf("image too large for thumbnail: %dx%d", config.Width, config.Height)
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	return g.writeScaled(src, destPath)
+}
+
+func (g *ThumbnailGenerator) fromPDF(sourcePath, destPath string) (bool, error) {
+	renderer, err := exec.LookPath(g.pdfRenderer)
+	if err != nil {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+
+	// pdftoppm appends the extension to the output prefix
+	outputPrefix := strings.TrimSuffix(destPath, filepath.Ext(destPath)) + "_page"
+	pagePath := outputPrefix + ".png"
+	defer os.Remove(pagePath)
+
+	cmd := exec.CommandContext(ctx, renderer,
+		"-f", "1", "-l", "1",
+		"-png", "-singlefile",
+		"-scale-to", fmt.Sprintf("%d", g.maxDimension*2),
+		sourcePath, outputPrefix,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return true, fmt.Errorf("failed to render pdf preview: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return true, g.fromImage(pagePath, destPath)
+}
+
+func (g *ThumbnailGenerator) writeScaled(src image.Image, destPath string) error {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return fmt.Errorf("image has no pixels")
+	}
+
+	// Fit within the bounding box, never upscaling
+	scale := float64(g.maxDimension) / float64(width)
+	if heightScale := float64(g.maxDimension) / float64(height); heightScale < scale {
+		scale = heightScale
+	}
+	if scale > 1 {
+		scale = 1
+	}
+	targetWidth := int(float64(width) * scale)
+	targetHeight := int(float64(height) * scale)
+	if targetWidth < 1 {
+		targetWidth = 1
+	}
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+
+	// JPEG has no alpha, so composite transparent images onto white
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return jpeg.Encode(out, dst, &jpeg.Options{Quality: 80})
+}
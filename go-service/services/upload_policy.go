@@ -0,0 +1,230 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"my-property/go-service/models"
+	"my-property/go-service/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Upload contexts select which policy applies to a file
+const (
+	UploadContextChat    = "chat"
+	UploadContextListing = "listing"
+)
+
+const (
+	mimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var (
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	ErrFileTooLarge       = errors.New("file exceeds size limit")
+	ErrFileInfected       = errors.New("file failed malware scan")
+)
+
+// UploadPolicy lists the detected content types and maximum size accepted for a context
+type UploadPolicy struct {
+	AllowedTypes []string
+	MaxSize      int64
+}
+
+// UploadInspection is the outcome of checking a file against its policy
+type UploadInspection struct {
+	ContentType string
+	Size        int64
+}
+
+// UploadPolicyEngine validates uploads by their actual content instead of client-supplied headers
+type UploadPolicyEngine struct {
+	db                *gorm.DB
+	encryptionService *utils.EncryptionService
+	scanner           utils.VirusScanner
+	policies          map[string]UploadPolicy
+	quarantineDir     string
+}
+
+func NewUploadPolicyEngine(db *gorm.DB, encryptionService *utils.EncryptionService, scanner utils.VirusScanner) *UploadPolicyEngine {
+	quarantineDir := "./uploads/quarantine"
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		panic(fmt.Sprintf("Failed to create quarantine directory: %v", err))
+	}
+
+	images := []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	documents := []string{"application/pdf", mimeDOCX}
+
+	return &UploadPolicyEngine{
+		db:                db,
+		encryptionService: encryptionService,
+		scanner:           scanner,
+		policies: map[string]UploadPolicy{
+			UploadContextChat: {
+				AllowedTypes: append(append([]string{"text/plain", mimeXLSX}, images...), documents...),
+				MaxSize:      25 * 1024 * 1024,
+			},
+			UploadContextListing: {
+				AllowedTypes: images,
+				MaxSize:      10 * 1024 * 1024,
+			},
+		},
+		quarantineDir: quarantineDir,
+	}
+}
+
+// SetPolicy overrides the policy for an upload context
+func (e *UploadPolicyEngine) SetPolicy(uploadContext string, policy UploadPolicy) {
+	e.policies[uploadContext] = policy
+}
+
+// Inspect checks the file at filePath against the policy for uploadContext and scans it for malware.
+// Infected files are moved to quarantine, so callers must not store filePath when ErrFileInfected is returned.
+func (e *UploadPolicyEngine) Inspect(uploadContext, referenceID, fileName, filePath string) (*UploadInspection, error) {
+	policy, ok := e.policies[uploadContext]
+	if !ok {
+		return nil, fmt.Errorf("no upload policy for context %q", uploadContext)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxSize > 0 && info.Size() > policy.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrFileTooLarge, info.Size(), policy.MaxSize)
+	}
+
+	contentType, err := DetectFileType(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(policy.AllowedTypes, contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrFileTypeNotAllowed, contentType)
+	}
+
+	inspection := &UploadInspection{
+		ContentType: contentType,
+		Size:        info.Size(),
+	}
+
+	if e.scanner == nil {
+		return inspection, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.scanner.Scan(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("malware scan error: %v", err)
+	}
+
+	if result.Infected {
+		if err := e.quarantine(uploadContext, referenceID, fileName, filePath, inspection, result.Signature); err != nil {
+			os.Remove(filePath)
+			return nil, fmt.Errorf("failed to quarantine infected file: %v", err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrFileInfected, result.Signature)
+	}
+
+	return inspection, nil
+}
+
+// quarantine encrypts an infected file into the quarantine directory and records it
+func (e *UploadPolicyEngine) quarantine(uploadContext, referenceID, fileName, filePath string, inspection *UploadInspection, signature string) error {
+	defer os.Remove(filePath)
+
+	hash, err := utils.HashFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	encryptedPath := filepath.Join(e.quarantineDir, fmt.Sprintf("%s_%d.enc", hash, time.Now().UnixNano()))
+	encryptedKey, err := e.encryptionService.EncryptFile(filePath, encryptedPath)
+	if err != nil {
+		return err
+	}
+
+	record := &models.QuarantinedFile{
+		Context:       uploadContext,
+		ReferenceID:   referenceID,
+		FileName:      fileName,
+		ContentType:   inspection.ContentType,
+		FileSize:      inspection.Size,
+		ContentHash:   hash,
+		Signature:     signature,
+		EncryptedPath: encryptedPath,
+		EncryptedKey:  encryptedKey,
+		CreatedAt:     time.Now(),
+	}
+
+	return e.db.Create(record).Error
+}
+
+// DetectFileType identifies a file's MIME type from its leading bytes
+func DetectFileType(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+
+	// Office documents are ZIP containers, so look inside for their marker parts
+	if contentType == "application/zip" {
+		if officeType := detectOfficeType(filePath); officeType != "" {
+			return officeType, nil
+		}
+	}
+
+	return contentType, nil
+}
+
+func detectOfficeType(filePath string) string {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return ""
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		switch {
+		case entry.Name == "word/document.xml":
+			return mimeDOCX
+		case entry.Name == "xl/workbook.xml":
+			return mimeXLSX
+		}
+	}
+
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
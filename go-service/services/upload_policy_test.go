@@ -0,0 +1,70 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zipWith builds a ZIP archive holding empty entries with the given names
+func zipWith(t *testing.T, names ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := archive.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectFileType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"pdf", []byte("%PDF-1.7\n%âãÏÓ\n"), "application/pdf"},
+		{"text drops the charset", []byte("plain notes about the flat"), "text/plain"},
+		{"html", []byte("<!DOCTYPE html><html><body>hi</body></html>"), "text/html"},
+		{"docx", zipWith(t, "[Content_Types].xml", "word/document.xml"), mimeDOCX},
+		{"xlsx", zipWith(t, "[Content_Types].xml", "xl/workbook.xml"), mimeXLSX},
+		{"plain zip", zipWith(t, "notes.txt"), "application/zip"},
+		{"executable renamed", []byte("MZ\x90\x00\x03\x00\x00\x00"), "application/octet-stream"},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Names carry no extension, so only the content can decide the type
+			path := filepath.Join(dir, string(rune('a'+i)))
+			if err := os.WriteFile(path, tt.content, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := DetectFileType(path)
+			if err != nil {
+				t.Fatalf("DetectFileType: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFileType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFileTypeMissingFile(t *testing.T) {
+	if _, err := DetectFileType(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("DetectFileType of a missing file returned no error")
+	}
+}
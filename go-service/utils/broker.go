@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Broker publishes events to topics and delivers them to subscribers. Events with the same key are delivered in
+// the order they were published. Delivery is at least once: a failing handler is retried under its policy and the
+// event is dead-lettered if it keeps failing, and after a restart an event may be delivered again.
+type Broker interface {
+	// PublishEvent publishes an event envelope, filling in a missing schema version, producer, tenant or trace context
+	PublishEvent(topic, key string, envelope *EventEnvelope) error
+	// SubscribeHandler adds a named handler to the group shared by all replicas, so each event is handled once
+	SubscribeHandler(topic, name string, handler EventHandler, retry RetryPolicy) error
+	// BroadcastToTopic adds a handler that receives every chat message of the topic on this replica
+	BroadcastToTopic(topic string, handler MessageHandler) error
+	// EnsureTopic creates a topic unless it exists
+	EnsureTopic(topic string) error
+	// ConsumeDeadLetters passes every message of the dead-letter topics to handler, retrying until it succeeds
+	ConsumeDeadLetters(topics []string, handler func(message *DeadLetterMessage) error) error
+	// ReplayDeadLetter publishes a dead-lettered message to its original topic, only for handler if one is named
+	ReplayDeadLetter(topic string, key, value []byte, handler string) error
+	// UseProcessedEventStore makes handlers subscribed afterwards with SubscribeHandler skip events the store
+	// records as handled, and record the events they handle
+	UseProcessedEventStore(store ProcessedEventStore)
+	Close() error
+}
+
+// ProcessedEventStore persists which handlers handled which events, so redelivered events are skipped across
+// restarts and replicas. It must be safe for concurrent use.
+type ProcessedEventStore interface {
+	// IsProcessed reports whether consumer has handled the event
+	IsProcessed(consumer, eventID string) (bool, error)
+	// MarkProcessed records that consumer handled the event
+	MarkProcessed(consumer, eventID string) error
+}
+
+// NewBrokerFromEnv returns the broker named by MESSAGE_BROKER: "kafka", the default, or "memory", which keeps
+// events in this process for local development and tests. Both are configured by KafkaConfigFromEnv.
+func NewBrokerFromEnv() (Broker, error) {
+	config := KafkaConfigFromEnv()
+
+	switch strings.ToLower(os.Getenv("MESSAGE_BROKER")) {
+	case "", "kafka":
+		kafkaService, err := NewKafkaServiceWithConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return kafkaService, nil
+	case "memory":
+		return NewMemoryBroker(config), nil
+	default:
+		return nil, fmt.Errorf("unknown message broker %q", os.Getenv("MESSAGE_BROKER"))
+	}
+}
+
+// Record is a message read from a topic partition
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+// recordHandler processes one record and returns false if ctx ended first, in which case the record is not committed
+type recordHandler func(ctx context.Context, record *Record) bool
+
+// completeEnvelope fills in what the publisher of an envelope left out
+func completeEnvelope(envelope *EventEnvelope, producer, tenant string) {
+	if envelope.SchemaVersion == 0 {
+		envelope.SchemaVersion = Events.Version(envelope.Type)
+	}
+	if envelope.OccurredAt.IsZero() {
+		envelope.OccurredAt = time.Now()
+	}
+	if envelope.Producer == "" {
+		envelope.Producer = producer
+	}
+	if envelope.Tenant == "" {
+		envelope.Tenant = tenant
+	}
+	if envelope.TraceContext == nil {
+		envelope.TraceContext = NewTraceContext()
+	}
+}
+
+// eventHeaders lets consumers route and trace an event without decoding it
+func eventHeaders(envelope *EventEnvelope) map[string]string {
+	headers := map[string]string{
+		"event-id":       envelope.ID,
+		"event-type":     envelope.Type,
+		"schema-version": strconv.Itoa(envelope.SchemaVersion),
+	}
+	if traceparent := envelope.TraceContext["traceparent"]; traceparent != "" {
+		headers["traceparent"] = traceparent
+	}
+	return headers
+}
+
+// chatMessages adapts a MessageHandler to an EventHandler that only receives chat messages
+func chatMessages(handler MessageHandler) EventHandler {
+	return func(event *Event) error {
+		message, ok := event.Payload.(*ChatMessage)
+		if !ok {
+			return nil
+		}
+		return handler(message)
+	}
+}
+
+// subscription is a handler registered for a group and topic
+type subscription struct {
+	name    string
+	handler EventHandler
+	retry   RetryPolicy
+}
+
+// run calls the handler until it succeeds, its retry policy is exhausted or ctx ends
+func (sub subscription) run(ctx context.Context, event *Event) (int, error) {
+	backoff := sub.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := sub.handler(event)
+		if err == nil {
+			return attempt, nil
+		}
+		if sub.retry.MaxAttempts > 0 && attempt >= sub.retry.MaxAttempts {
+			return attempt, err
+		}
+		log.Printf("Handler %s failed (attempt %d): %v", sub.name, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(backoff):
+		}
+		backoff = sub.retry.next(backoff)
+	}
+}
+
+// registerSubscription adds sub to the handlers of a group and topic, naming it if it has no name
+func registerSubscription(handlers map[string][]subscription, key, topic string, sub subscription) error {
+	if sub.name == "" {
+		sub.name = fmt.Sprintf("handler-%d", len(handlers[key])+1)
+	}
+	for _, existing := range handlers[key] {
+		if existing.name == sub.name {
+			return fmt.Errorf("handler %s is already subscribed to %s", sub.name, topic)
+		}
+	}
+
+	handlers[key] = append(handlers[key], sub)
+	return nil
+}
+
+// dispatcher runs the handlers subscribed with one group to one topic
+type dispatcher struct {
+	group      string
+	subs       func() []subscription
+	handled    *recentIDs          // Handler and event id pairs already handled, so redelivered events are skipped
+	processed  ProcessedEventStore // Handled events kept across restarts; nil for groups of a single replica
+	deadLetter func(ctx context.Context, record *Record, handler string, cause error, attempts int) bool
+}
+
+// handle runs each handler for one record under its retry policy and dead-letters the record for handlers
+// that kept failing. A replayed record only runs the handler it was dead-lettered for.
+func (d *dispatcher) handle(ctx context.Context, record *Record) bool {
+	event, err := Events.Decode(record.Value)
+	if errors.Is(err, ErrUnknownEventType) {
+		// Event types added by newer producers are not for this consumer
+		return true
+	}
+	if err != nil {
+		// Retrying cannot fix a payload that does not parse; replay it once a consumer that can is deployed
+		return d.deadLetter(ctx, record, "", err, 1)
+	}
+	id := event.Envelope.ID
+
+	target := record.Headers[HeaderReplayHandler]
+	for _, sub := range d.subs() {
+		if target != "" && sub.name != target {
+			continue
+		}
+		if d.handled.contains(sub.name+"/"+id) || d.isProcessed(sub.name, id) {
+			continue
+		}
+
+		attempts, err := sub.run(ctx, event)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			log.Printf("Handler %s gave up at %s/%d offset %d after %d attempts: %v", sub.name, record.Topic, record.Partition, record.Offset, attempts, err)
+			if !d.deadLetter(ctx, record, sub.name, err, attempts) {
+				return false
+			}
+			continue
+		}
+		d.handled.add(sub.name + "/" + id)
+		d.markProcessed(sub.name, id)
+	}
+	return true
+}
+
+// isProcessed asks the store whether a handler of the group handled an event. If the store cannot answer
+// the event is handled again, which at-least-once delivery allows.
+func (d *dispatcher) isProcessed(handler, id string) bool {
+	if d.processed == nil || id == "" {
+		return false
+	}
+
+	processed, err := d.processed.IsProcessed(d.group+"/"+handler, id)
+	if err != nil {
+		log.Printf("Failed to check whether %s handled event %s: %v", handler, id, err)
+		return false
+	}
+	return processed
+}
+
+func (d *dispatcher) markProcessed(handler, id string) {
+	if d.processed == nil || id == "" {
+		return
+	}
+
+	if err := d.processed.MarkProcessed(d.group+"/"+handler, id); err != nil {
+		log.Printf("Failed to record that %s handled event %s: %v", handler, id, err)
+	}
+}
+
+// recentIDs remembers the last size event ids; events without an id are never considered handled
+type recentIDs struct {
+	mu    sync.Mutex
+	size  int
+	ids   map[string]bool
+	order []string
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{size: size, ids: make(map[string]bool)}
+}
+
+func (r *recentIDs) contains(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ids[id]
+}
+
+func (r *recentIDs) add(id string) {
+	if id == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ids[id] {
+		return
+	}
+	r.ids[id] = true
+	r.order = append(r.order, id)
+	if len(r.order) > r.size {
+		delete(r.ids, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// RoomRouter fans chat messages out to the subscribers of their room connected to this replica.
+// All rooms share one broadcast subscription to ChatEventsTopic, made when the first room is subscribed to.
+type RoomRouter struct {
+	broker      Broker
+	mu          sync.RWMutex
+	routing     bool
+	nextSubID   uint64
+	subscribers map[uint]map[uint64]MessageHandler // Local subscribers by room
+}
+
+func NewRoomRouter(broker Broker) *RoomRouter {
+	return &RoomRouter{
+		broker:      broker,
+		subscribers: make(map[uint]map[uint64]MessageHandler),
+	}
+}
+
+// SubscribeToRoom registers a local subscriber for a room's messages and returns a function that removes it.
+// Handlers are called with the subscriber list locked and must not block.
+func (r *RoomRouter) SubscribeToRoom(roomID uint, handler MessageHandler) (func(), error) {
+	if err := r.startRouting(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.nextSubID++
+	id := r.nextSubID
+	if r.subscribers[roomID] == nil {
+		r.subscribers[roomID] = make(map[uint64]MessageHandler)
+	}
+	r.subscribers[roomID][id] = handler
+	r.mu.Unlock()
+
+	unsubscribe := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.subscribers[roomID], id)
+		if len(r.subscribers[roomID]) == 0 {
+			delete(r.subscribers, roomID)
+		}
+	}
+	return unsubscribe, nil
+}
+
+// startRouting subscribes this replica to ChatEventsTopic the first time a room is subscribed to
+func (r *RoomRouter) startRouting() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.routing {
+		return nil
+	}
+	if err := r.broker.BroadcastToTopic(ChatEventsTopic, r.route); err != nil {
+		return err
+	}
+	r.routing = true
+	return nil
+}
+
+// route fans a message out to the local subscribers of its room
+func (r *RoomRouter) route(message *ChatMessage) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, handler := range r.subscribers[message.RoomID] {
+		if err := handler(message); err != nil {
+			log.Printf("Room %d subscriber error: %v", message.RoomID, err)
+		}
+	}
+	return nil
+}
@@ -215,4 +215,20 @@ func GenerateRandomKey() (string, error) {
 		return "", err
 	}
 	return hex.EncodeToString(key), nil
-} 
\ No newline at end of file
+}
+
+// HashFile returns the hex-encoded SHA-256 digest of a file's contents
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
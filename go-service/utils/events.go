@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published to Kafka
+const (
+	EventChatMessage                  = "chat_message"
+	EventChatMessageEdited            = "chat_message_edited"
+	EventChatMessageDeleted           = "chat_message_deleted"
+	EventChatReactionAdded            = "chat_reaction_added"
+	EventChatReactionRemoved          = "chat_reaction_removed"
+	EventSaleTransactionCreated       = "sale_transaction_created"
+	EventSaleTransactionStatusChanged = "sale_transaction_status_changed"
+	EventLeaseContractCreated         = "lease_contract_created"
+	EventLeaseStatusChanged           = "lease_status_changed"
+)
+
+// FinancialEventsTopic carries sale transaction and lease events, keyed by transaction or lease id
+const FinancialEventsTopic = "financial.events"
+
+// EventEnvelope wraps every event published to Kafka. Messages from before the envelope had only id, type and
+// payload; they decode as schema version 1.
+type EventEnvelope struct {
+	ID            string            `json:"id"` // Idempotency key; a redelivered event carries the same id
+	Type          string            `json:"type"`
+	SchemaVersion int               `json:"schema_version"`
+	OccurredAt    time.Time         `json:"occurred_at"`
+	Producer      string            `json:"producer"`
+	TraceContext  map[string]string `json:"trace_context,omitempty"` // W3C "traceparent" and "tracestate"
+	Tenant        string            `json:"tenant,omitempty"`
+	Payload       json.RawMessage   `json:"payload"`
+}
+
+// Event is a decoded envelope with its payload upcast to the current schema version
+type Event struct {
+	Envelope EventEnvelope
+	Payload  interface{} // Pointer to the type registered for Envelope.Type
+}
+
+// ErrUnknownEventType is returned when decoding an event type that is not registered
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Upcaster converts a payload from one schema version to the next
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type eventSchema struct {
+	version    int
+	newPayload func() interface{}
+	upcasters  map[int]Upcaster // By the version they convert from
+}
+
+// EventRegistry maps event types to their Go payload types and current schema versions
+type EventRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*eventSchema
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{schemas: make(map[string]*eventSchema)}
+}
+
+// Register sets the current schema version of an event type and the payload type it decodes into
+func (r *EventRegistry) Register(eventType string, version int, newPayload func() interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schema, ok := r.schemas[eventType]
+	if !ok {
+		schema = &eventSchema{upcasters: make(map[int]Upcaster)}
+		r.schemas[eventType] = schema
+	}
+	schema.version = version
+	schema.newPayload = newPayload
+}
+
+// RegisterUpcaster adds the conversion of an event type's payload from fromVersion to fromVersion+1
+func (r *EventRegistry) RegisterUpcaster(eventType string, fromVersion int, upcast Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schema, ok := r.schemas[eventType]
+	if !ok {
+		schema = &eventSchema{upcasters: make(map[int]Upcaster)}
+		r.schemas[eventType] = schema
+	}
+	schema.upcasters[fromVersion] = upcast
+}
+
+// Version returns the current schema version of an event type, or 0 if it is not registered
+func (r *EventRegistry) Version(eventType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if schema, ok := r.schemas[eventType]; ok {
+		return schema.version
+	}
+	return 0
+}
+
+// Decode parses an envelope and its payload, upcasting older schema versions one step at a time.
+// Unregistered types fail with ErrUnknownEventType; versions newer than the registered one fail too,
+// since this consumer cannot know what they changed.
+func (r *EventRegistry) Decode(data []byte) (*Event, error) {
+	var envelope EventEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %v", err)
+	}
+	if envelope.SchemaVersion == 0 {
+		envelope.SchemaVersion = 1
+	}
+
+	r.mu.RLock()
+	schema, ok := r.schemas[envelope.Type]
+	r.mu.RUnlock()
+	if !ok || schema.newPayload == nil {
+		return &Event{Envelope: envelope}, fmt.Errorf("%w: %s", ErrUnknownEventType, envelope.Type)
+	}
+	if envelope.SchemaVersion > schema.version {
+		return &Event{Envelope: envelope}, fmt.Errorf("%s schema version %d is newer than the supported version %d", envelope.Type, envelope.SchemaVersion, schema.version)
+	}
+
+	payload := envelope.Payload
+	for version := envelope.SchemaVersion; version < schema.version; version++ {
+		r.mu.RLock()
+		upcast, ok := schema.upcasters[version]
+		r.mu.RUnlock()
+		if !ok {
+			return &Event{Envelope: envelope}, fmt.Errorf("no upcaster for %s from schema version %d", envelope.Type, version)
+		}
+
+		var err error
+		if payload, err = upcast(payload); err != nil {
+			return &Event{Envelope: envelope}, fmt.Errorf("failed to upcast %s from schema version %d: %v", envelope.Type, version, err)
+		}
+	}
+
+	value := schema.newPayload()
+	if err := json.Unmarshal(payload, value); err != nil {
+		return &Event{Envelope: envelope}, fmt.Errorf("failed to parse %s payload: %v", envelope.Type, err)
+	}
+
+	envelope.SchemaVersion = schema.version
+	envelope.Payload = payload
+	return &Event{Envelope: envelope, Payload: value}, nil
+}
+
+// Events is the registry of every event type this service publishes
+var Events = newDefaultEventRegistry()
+
+func newDefaultEventRegistry() *EventRegistry {
+	registry := NewEventRegistry()
+
+	registry.Register(EventChatMessage, 1, func() interface{} { return &ChatMessage{} })
+	registry.Register(EventChatMessageEdited, 1, func() interface{} { return &ChatMessageEditedEvent{} })
+	registry.Register(EventChatMessageDeleted, 1, func() interface{} { return &ChatMessageDeletedEvent{} })
+	registry.Register(EventChatReactionAdded, 1, func() interface{} { return &ChatReactionEvent{} })
+	registry.Register(EventChatReactionRemoved, 1, func() interface{} { return &ChatReactionEvent{} })
+	registry.Register(EventSaleTransactionCreated, 1, func() interface{} { return &SaleTransactionEvent{} })
+	registry.Register(EventSaleTransactionStatusChanged, 1, func() interface{} { return &SaleTransactionEvent{} })
+	registry.Register(EventLeaseContractCreated, 1, func() interface{} { return &LeaseContractEvent{} })
+	registry.Register(EventLeaseStatusChanged, 1, func() interface{} { return &LeaseStatusEvent{} })
+
+	return registry
+}
+
+// NewTraceContext starts a W3C trace for an event that is not part of one yet
+func NewTraceContext() map[string]string {
+	traceID := make([]byte, 16)
+	spanID := make([]byte, 8)
+	rand.Read(traceID)
+	rand.Read(spanID)
+	return map[string]string{"traceparent": fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(traceID), hex.EncodeToString(spanID))}
+}
+
+type ChatMessageEditedEvent struct {
+	MessageID  uint      `json:"message_id"`
+	RoomID     uint      `json:"room_id"`
+	Content    string    `json:"content"`
+	EditedBy   uint      `json:"edited_by"`
+	EditorType string    `json:"editor_type"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type ChatMessageDeletedEvent struct {
+	MessageID  uint      `json:"message_id"`
+	RoomID     uint      `json:"room_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type ChatReactionEvent struct {
+	ReactionID uint      `json:"reaction_id"`
+	MessageID  uint      `json:"message_id"`
+	RoomID     uint      `json:"room_id"`
+	UserID     uint      `json:"user_id"`
+	UserType   string    `json:"user_type"`
+	Emoji      string    `json:"emoji,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type SaleTransactionEvent struct {
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	BuildingID    uuid.UUID  `json:"building_id"`
+	BuyerID       uuid.UUID  `json:"buyer_id"`
+	SellerID      uuid.UUID  `json:"seller_id"`
+	AgentID       *uuid.UUID `json:"agent_id,omitempty"`
+	Status        string     `json:"status"`
+	TotalAmount   float64    `json:"total_amount"`
+	OccurredAt    time.Time  `json:"occurred_at"`
+}
+
+type LeaseContractEvent struct {
+	LeaseID     uuid.UUID  `json:"lease_id"`
+	PropertyID  uuid.UUID  `json:"property_id"`
+	TenantID    uuid.UUID  `json:"tenant_id"`
+	LandlordID  uuid.UUID  `json:"landlord_id"`
+	AgentID     *uuid.UUID `json:"agent_id,omitempty"`
+	StartDate   time.Time  `json:"start_date"`
+	EndDate     time.Time  `json:"end_date"`
+	MonthlyRent float64    `json:"monthly_rent"`
+	Status      string     `json:"status"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+}
+
+type LeaseStatusEvent struct {
+	LeaseID        uuid.UUID  `json:"lease_id"`
+	PropertyID     uuid.UUID  `json:"property_id"`
+	TenantID       uuid.UUID  `json:"tenant_id"`
+	LandlordID     uuid.UUID  `json:"landlord_id"`
+	AgentID        *uuid.UUID `json:"agent_id,omitempty"`
+	Status         string     `json:"status"`
+	PreviousStatus string     `json:"previous_status"`
+	OccurredAt     time.Time  `json:"occurred_at"`
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testPayload is version 3 of a test event: version 2 renamed version 1's "name" to "title" and version 3 added tags
+type testPayload struct {
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+}
+
+func newTestRegistry() *EventRegistry {
+	registry := NewEventRegistry()
+	registry.Register("test_event", 3, func() interface{} { return &testPayload{} })
+	registry.RegisterUpcaster("test_event", 1, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v1 map[string]interface{}
+		if err := json.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+		v1["title"] = v1["name"]
+		delete(v1, "name")
+		return json.Marshal(v1)
+	})
+	registry.RegisterUpcaster("test_event", 2, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v2 map[string]interface{}
+		if err := json.Unmarshal(payload, &v2); err != nil {
+			return nil, err
+		}
+		if _, ok := v2["tags"]; !ok {
+			v2["tags"] = []string{"migrated"}
+		}
+		return json.Marshal(v2)
+	})
+	return registry
+}
+
+func TestEventRegistryDecode(t *testing.T) {
+	registry := newTestRegistry()
+
+	tests := []struct {
+		name    string
+		data    string
+		want    testPayload
+		wantErr string // Substring of the expected error, empty for success
+	}{
+		{"current version", `{"id":"1","type":"test_event","schema_version":3,"payload":{"title":"a","tags":["x"]}}`,
+			testPayload{Title: "a", Tags: []string{"x"}}, ""},
+		{"upcast from version 2", `{"id":"1","type":"test_event","schema_version":2,"payload":{"title":"a"}}`,
+			testPayload{Title: "a", Tags: []string{"migrated"}}, ""},
+		{"upcast from version 1", `{"id":"1","type":"test_event","schema_version":1,"payload":{"name":"a"}}`,
+			testPayload{Title: "a", Tags: []string{"migrated"}}, ""},
+		{"pre-envelope message is version 1", `{"id":"1","type":"test_event","payload":{"name":"a"}}`,
+			testPayload{Title: "a", Tags: []string{"migrated"}}, ""},
+		{"newer version", `{"id":"1","type":"test_event","schema_version":4,"payload":{}}`,
+			testPayload{}, "newer than the supported version 3"},
+		{"unknown type", `{"id":"1","type":"other_event","payload":{}}`, testPayload{}, "unknown event type"},
+		{"malformed envelope", `{"id":`, testPayload{}, "failed to unmarshal event"},
+		{"malformed payload", `{"id":"1","type":"test_event","schema_version":3,"payload":{"title":7}}`,
+			testPayload{}, "failed to parse test_event payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := registry.Decode([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			payload, ok := event.Payload.(*testPayload)
+			if !ok {
+				t.Fatalf("payload is %T, want *testPayload", event.Payload)
+			}
+			if payload.Title != tt.want.Title || strings.Join(payload.Tags, ",") != strings.Join(tt.want.Tags, ",") {
+				t.Errorf("payload = %+v, want %+v", *payload, tt.want)
+			}
+			if event.Envelope.SchemaVersion != 3 {
+				t.Errorf("envelope schema version = %d, want 3", event.Envelope.SchemaVersion)
+			}
+		})
+	}
+}
+
+func TestEventRegistryMissingUpcaster(t *testing.T) {
+	registry := NewEventRegistry()
+	registry.Register("test_event", 3, func() interface{} { return &testPayload{} })
+	registry.RegisterUpcaster("test_event", 2, func(payload json.RawMessage) (json.RawMessage, error) {
+		return payload, nil
+	})
+
+	_, err := registry.Decode([]byte(`{"id":"1","type":"test_event","schema_version":1,"payload":{}}`))
+	if err == nil || !strings.Contains(err.Error(), "no upcaster for test_event from schema version 1") {
+		t.Errorf("Decode error = %v, want the missing upcaster from version 1", err)
+	}
+
+	if _, err := registry.Decode([]byte(`{"id":"1","type":"test_event","schema_version":2,"payload":{}}`)); err != nil {
+		t.Errorf("Decode from version 2: %v", err)
+	}
+}
+
+func TestEventRegistryUnknownTypeKeepsEnvelope(t *testing.T) {
+	event, err := NewEventRegistry().Decode([]byte(`{"id":"event-1","type":"other_event","payload":{}}`))
+	if !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("Decode error = %v, want ErrUnknownEventType", err)
+	}
+	// Consumers still need the id to dead-letter or skip the event
+	if event == nil || event.Envelope.ID != "event-1" {
+		t.Errorf("Decode returned %+v, want the envelope of event-1", event)
+	}
+}
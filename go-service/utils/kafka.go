@@ -1,81 +1,223 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/google/uuid"
 )
 
 type KafkaService struct {
-	producer sarama.SyncProducer
-	consumer sarama.Consumer
-	brokers  []string
-	mu       sync.RWMutex
-	handlers map[string][]MessageHandler
+	producer  sarama.SyncProducer
+	config    KafkaConfig
+	mu        sync.RWMutex
+	handlers  map[string][]subscription
+	processed ProcessedEventStore
+	consumers map[string]sarama.ConsumerGroup
+	cancel    context.CancelFunc
+	ctx       context.Context
+}
+
+// ChatEventsTopic carries the events of all rooms, keyed by room id so each room's events stay in order
+const ChatEventsTopic = "chat.events"
+
+// legacyRoomTopicPrefix names the per-room topics used before ChatEventsTopic
+const legacyRoomTopicPrefix = "chat_room_"
+
+// KafkaConfig configures the brokers, consumer groups and defaults for new topics
+type KafkaConfig struct {
+	Brokers           []string
+	GroupID           string // Consumer group shared by all replicas; each partition is handled by one of them
+	InstanceID        string // Identifies this replica for subscriptions every replica must receive, defaults to the hostname
+	InitialOffset     int64  // sarama.OffsetNewest or sarama.OffsetOldest, used when a group has no committed offset yet
+	Partitions        int32
+	ReplicationFactor int16
+	LegacyRoomTopics  bool        // Also publish to the per-room topics, for replicas that have not been upgraded yet
+	Retry             RetryPolicy // Default retry policy for handlers
+	Producer          string      // Names this service in event envelopes
+	Tenant            string      // Tenant set in event envelopes that do not name one
+}
+
+// KafkaConfigFromEnv reads KAFKA_BROKERS, KAFKA_CONSUMER_GROUP, KAFKA_INSTANCE_ID, KAFKA_START_OFFSET ("newest" or "oldest"),
+// KAFKA_TOPIC_PARTITIONS, KAFKA_REPLICATION_FACTOR, KAFKA_LEGACY_ROOM_TOPICS and the handler retry policy in
+// KAFKA_HANDLER_MAX_ATTEMPTS, KAFKA_HANDLER_BACKOFF and KAFKA_HANDLER_MAX_BACKOFF, and EVENT_PRODUCER and EVENT_TENANT
+func KafkaConfigFromEnv() KafkaConfig {
+	config := DefaultKafkaConfig([]string{"kafka:9092"})
+
+	if value := os.Getenv("KAFKA_BROKERS"); value != "" {
+		config.Brokers = nil
+		for _, broker := range strings.Split(value, ",") {
+			if broker = strings.TrimSpace(broker); broker != "" {
+				config.Brokers = append(config.Brokers, broker)
+			}
+		}
+	}
+	if value := os.Getenv("KAFKA_CONSUMER_GROUP"); value != "" {
+		config.GroupID = value
+	}
+	if value := os.Getenv("KAFKA_INSTANCE_ID"); value != "" {
+		config.InstanceID = value
+	}
+	switch strings.ToLower(os.Getenv("KAFKA_START_OFFSET")) {
+	case "oldest", "earliest":
+		config.InitialOffset = sarama.OffsetOldest
+	case "newest", "latest":
+		config.InitialOffset = sarama.OffsetNewest
+	}
+	if value, err := strconv.ParseInt(os.Getenv("KAFKA_TOPIC_PARTITIONS"), 10, 32); err == nil && value > 0 {
+		config.Partitions = int32(value)
+	}
+	if value, err := strconv.ParseInt(os.Getenv("KAFKA_REPLICATION_FACTOR"), 10, 16); err == nil && value > 0 {
+		config.ReplicationFactor = int16(value)
+	}
+	if value, err := strconv.ParseBool(os.Getenv("KAFKA_LEGACY_ROOM_TOPICS")); err == nil {
+		config.LegacyRoomTopics = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("KAFKA_HANDLER_MAX_ATTEMPTS")); err == nil {
+		config.Retry.MaxAttempts = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("KAFKA_HANDLER_BACKOFF")); err == nil && value > 0 {
+		config.Retry.InitialBackoff = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("KAFKA_HANDLER_MAX_BACKOFF")); err == nil && value > 0 {
+		config.Retry.MaxBackoff = value
+	}
+	if value := os.Getenv("EVENT_PRODUCER"); value != "" {
+		config.Producer = value
+	}
+	config.Tenant = os.Getenv("EVENT_TENANT")
+
+	return config
+}
+
+// DefaultKafkaConfig returns a single-partition, single-replica setup that starts new groups at the newest offset
+func DefaultKafkaConfig(brokers []string) KafkaConfig {
+	instanceID, err := os.Hostname()
+	if err != nil || instanceID == "" {
+		instanceID = strconv.Itoa(os.Getpid())
+	}
+
+	return KafkaConfig{
+		Brokers:           brokers,
+		GroupID:           "go-service",
+		InstanceID:        instanceID,
+		InitialOffset:     sarama.OffsetNewest,
+		Partitions:        1,
+		ReplicationFactor: 1,
+		Producer:          "go-service",
+		Retry: RetryPolicy{
+			MaxAttempts:    5,
+			InitialBackoff: 200 * time.Millisecond,
+			MaxBackoff:     30 * time.Second,
+		},
+	}
 }
 
 type MessageHandler func(message *ChatMessage) error
 
+// EventHandler handles a decoded event of any registered type
+type EventHandler func(event *Event) error
+
 type ChatMessage struct {
-	ID          uint      `json:"id"`
-	RoomID      uint      `json:"room_id"`
-	SenderID    uint      `json:"sender_id"`
-	SenderType  string    `json:"sender_type"`
-	Content     string    `json:"content"`
-	MessageType string    `json:"message_type"`
-	ReplyToID   *uint     `json:"reply_to_id"`
-	ReferenceID *uint     `json:"reference_id"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID           uint      `json:"id"`
+	RoomID       uint      `json:"room_id"`
+	SenderID     uint      `json:"sender_id"`
+	SenderType   string    `json:"sender_type"`
+	Content      string    `json:"content"`
+	MessageType  string    `json:"message_type"`
+	ReplyToID    *uint     `json:"reply_to_id"`
+	ReferenceID  *uint     `json:"reference_id"`
+	ThreadRootID *uint     `json:"thread_root_id,omitempty"`
+	ThreadOnly   bool      `json:"thread_only,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
-type KafkaMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+func NewKafkaService(brokers []string) (*KafkaService, error) {
+	return NewKafkaServiceWithConfig(DefaultKafkaConfig(brokers))
 }
 
-func NewKafkaService(brokers []string) (*KafkaService, error) {
+func NewKafkaServiceWithConfig(kafkaConfig KafkaConfig) (*KafkaService, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 5
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	producer, err := sarama.NewSyncProducer(kafkaConfig.Brokers, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create producer: %v", err)
 	}
 
-	consumer, err := sarama.NewConsumer(brokers, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer: %v", err)
-	}
-
+	ctx, cancel := context.WithCancel(context.Background())
 	return &KafkaService{
-		producer: producer,
-		consumer: consumer,
-		brokers:  brokers,
-		handlers: make(map[string][]MessageHandler),
+		producer:  producer,
+		config:    kafkaConfig,
+		handlers:  make(map[string][]subscription),
+		consumers: make(map[string]sarama.ConsumerGroup),
+		ctx:       ctx,
+		cancel:    cancel,
 	}, nil
 }
 
 // PublishMessage publishes a message to a specific topic
 func (k *KafkaService) PublishMessage(topic string, message *ChatMessage) error {
-	msg := KafkaMessage{
-		Type:    "chat_message",
-		Payload: message,
+	return k.PublishKeyedMessage(topic, "", message)
+}
+
+// PublishKeyedMessage publishes a message with a partition key; messages with the same key keep their order
+func (k *KafkaService) PublishKeyedMessage(topic, key string, message *ChatMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 
-	jsonData, err := json.Marshal(msg)
+	return k.PublishEvent(topic, key, &EventEnvelope{
+		ID:         uuid.NewString(),
+		Type:       EventChatMessage,
+		OccurredAt: message.CreatedAt,
+		Payload:    payload,
+	})
+}
+
+// PublishEvent publishes an event envelope. The envelope id is the event's idempotency key, which consumers use
+// to drop events they have already handled. A missing schema version, producer, tenant or trace context is filled in.
+func (k *KafkaService) PublishEvent(topic, key string, envelope *EventEnvelope) error {
+	completeEnvelope(envelope, k.config.Producer, k.config.Tenant)
+
+	if err := k.send(topic, key, envelope); err != nil {
+		return err
+	}
+
+	// Room events are keyed by room id
+	if k.config.LegacyRoomTopics && topic == ChatEventsTopic && envelope.Type == EventChatMessage {
+		if err := k.send(legacyRoomTopicPrefix+key, "", envelope); err != nil {
+			log.Printf("Failed to publish to legacy room topic: %v", err)
+		}
+	}
+	return nil
+}
+
+func (k *KafkaService) send(topic, key string, envelope *EventEnvelope) error {
+	jsonData, err := json.Marshal(envelope)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 
 	producerMessage := &sarama.ProducerMessage{
-		Topic: topic,
-		Value: sarama.StringEncoder(jsonData),
+		Topic:   topic,
+		Value:   sarama.StringEncoder(jsonData),
+		Headers: kafkaHeaders(eventHeaders(envelope)),
+	}
+	if key != "" {
+		producerMessage.Key = sarama.StringEncoder(key)
 	}
 
 	partition, offset, err := k.producer.SendMessage(producerMessage)
@@ -87,102 +229,272 @@ func (k *KafkaService) PublishMessage(topic string, message *ChatMessage) error
 	return nil
 }
 
-// SubscribeToTopic subscribes to a topic and handles messages
+// SubscribeToTopic joins the service's consumer group for a topic. Partitions are balanced across all
+// replicas in the group, so each message is handled by one replica.
 func (k *KafkaService) SubscribeToTopic(topic string, handler MessageHandler) error {
-	partitionConsumer, err := k.consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
+	return k.subscribe(k.config.GroupID, topic, subscription{handler: chatMessages(handler), retry: k.config.Retry})
+}
+
+// SubscribeHandler joins the service's consumer group for a topic with a named handler and its own retry policy.
+// The name identifies the handler in dead-lettered messages and when they are replayed, so it must be stable.
+// It receives events of every registered type.
+func (k *KafkaService) SubscribeHandler(topic, name string, handler EventHandler, retry RetryPolicy) error {
+	return k.subscribe(k.config.GroupID, topic, subscription{name: name, handler: handler, retry: retry})
+}
+
+// BroadcastToTopic subscribes with a consumer group of this replica alone, so every replica receives
+// every message of the topic. It is meant for fanning messages out to locally connected clients.
+func (k *KafkaService) BroadcastToTopic(topic string, handler MessageHandler) error {
+	return k.subscribe(k.config.GroupID+"."+k.config.InstanceID, topic, subscription{handler: chatMessages(handler), retry: k.config.Retry})
+}
+
+// UseProcessedEventStore records the events handled by the shared consumer group in store
+func (k *KafkaService) UseProcessedEventStore(store ProcessedEventStore) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.processed = store
+}
+
+// subscribe registers a handler and starts one consumer group session loop per group and topic
+func (k *KafkaService) subscribe(groupID, topic string, sub subscription) error {
+	key := groupID + "/" + topic
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := registerSubscription(k.handlers, key, topic, sub); err != nil {
+		return err
+	}
+	if _, ok := k.consumers[key]; ok {
+		return nil
+	}
+
+	group, err := k.newConsumerGroup(groupID, k.config.InitialOffset)
 	if err != nil {
-		return fmt.Errorf("failed to create partition consumer: %v", err)
+		k.handlers[key] = k.handlers[key][:len(k.handlers[key])-1]
+		return err
 	}
+	k.consumers[key] = group
 
-	k.mu.Lock()
-	k.handlers[topic] = append(k.handlers[topic], handler)
-	k.mu.Unlock()
+	d := &dispatcher{
+		group: groupID,
+		subs: func() []subscription {
+			k.mu.RLock()
+			defer k.mu.RUnlock()
+			return append([]subscription(nil), k.handlers[key]...)
+		},
+		handled:    newRecentIDs(10000),
+		deadLetter: k.deadLetter,
+	}
+	// Broadcast groups belong to one replica and only fan out to its clients, so they are not recorded
+	if groupID == k.config.GroupID {
+		d.processed = k.processed
+	}
+	go k.consume(group, groupID, []string{topic}, &groupHandler{process: d.handle})
+
+	return nil
+}
+
+func (k *KafkaService) newConsumerGroup(groupID string, initialOffset int64) (sarama.ConsumerGroup, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = initialOffset
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+
+	group, err := sarama.NewConsumerGroup(k.config.Brokers, groupID, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer group: %v", err)
+	}
 
 	go func() {
-		defer partitionConsumer.Close()
-		for {
+		for err := range group.Errors() {
+			log.Printf("Consumer group %s error: %v", groupID, err)
+		}
+	}()
+
+	return group, nil
+}
+
+// consume runs consumer group sessions until the service is closed
+func (k *KafkaService) consume(group sarama.ConsumerGroup, groupID string, topics []string, handler sarama.ConsumerGroupHandler) {
+	for {
+		// Consume returns on every rebalance and must be called again to rejoin the group
+		if err := group.Consume(k.ctx, topics, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			log.Printf("Consumer group %s failed on topics %v: %v", groupID, topics, err)
 			select {
-			case msg := <-partitionConsumer.Messages():
-				var kafkaMsg KafkaMessage
-				if err := json.Unmarshal(msg.Value, &kafkaMsg); err != nil {
-					log.Printf("Failed to unmarshal message: %v", err)
-					continue
-				}
-
-				if kafkaMsg.Type == "chat_message" {
-					chatMsg, ok := kafkaMsg.Payload.(*ChatMessage)
-					if !ok {
-						// Try to unmarshal from JSON
-						jsonData, _ := json.Marshal(kafkaMsg.Payload)
-						if err := json.Unmarshal(jsonData, &chatMsg); err != nil {
-							log.Printf("Failed to parse chat message: %v", err)
-							continue
-						}
-					}
-
-					k.mu.RLock()
-					handlers := k.handlers[topic]
-					k.mu.RUnlock()
-
-					for _, h := range handlers {
-						if err := h(chatMsg); err != nil {
-							log.Printf("Handler error: %v", err)
-						}
-					}
-				}
-			case err := <-partitionConsumer.Errors():
-				log.Printf("Consumer error: %v", err)
+			case <-k.ctx.Done():
+				return
+			case <-time.After(time.Second):
 			}
 		}
-	}()
+		if k.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// groupHandler passes the messages of claimed partitions to a record handler
+type groupHandler struct {
+	process recordHandler
+}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Consumer group claimed partitions %v", session.Claims())
+	return nil
+}
 
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-// CreateTopic creates a new Kafka topic
+// ConsumeClaim marks a message, and so lets its offset be committed, once it has been processed: for events, once
+// every handler either succeeded or had the message dead-lettered. Retries hold back the partition to keep messages
+// in order; if the session ends first the message is redelivered from the last committed offset.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !h.process(session.Context(), kafkaRecord(msg)) {
+				return nil
+			}
+			session.MarkMessage(msg, "")
+		}
+	}
+}
+
+// CreateTopic creates a new Kafka topic with the configured partitions and replication factor
 func (k *KafkaService) CreateTopic(topic string) error {
-	admin, err := sarama.NewClusterAdmin(k.brokers, nil)
+	return k.CreateTopicWithDetail(topic, k.config.Partitions, k.config.ReplicationFactor)
+}
+
+// CreateTopicWithDetail creates a new Kafka topic
+func (k *KafkaService) CreateTopicWithDetail(topic string, partitions int32, replicationFactor int16) error {
+	admin, err := sarama.NewClusterAdmin(k.config.Brokers, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create cluster admin: %v", err)
 	}
 	defer admin.Close()
 
 	err = admin.CreateTopic(topic, &sarama.TopicDetail{
-		NumPartitions:     1,
-		ReplicationFactor: 1,
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
 	}, false)
 	if err != nil {
-		return fmt.Errorf("failed to create topic: %v", err)
+		return fmt.Errorf("failed to create topic: %w", err)
 	}
 
 	return nil
 }
 
-// Close closes the Kafka service
+// EnsureTopic creates a topic with the configured partitions and replication factor unless it exists
+func (k *KafkaService) EnsureTopic(topic string) error {
+	if err := k.CreateTopic(topic); err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+// Close stops all consumer groups, committing marked offsets, and closes the producer
 func (k *KafkaService) Close() error {
+	k.cancel()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for key, group := range k.consumers {
+		if err := group.Close(); err != nil {
+			return fmt.Errorf("failed to close consumer group %s: %v", key, err)
+		}
+		delete(k.consumers, key)
+	}
 	if err := k.producer.Close(); err != nil {
 		return fmt.Errorf("failed to close producer: %v", err)
 	}
-	if err := k.consumer.Close(); err != nil {
-		return fmt.Errorf("failed to close consumer: %v", err)
-	}
 	return nil
 }
 
-// PublishRoomMessage publishes a message to a specific room
+// PublishRoomMessage publishes a room's message to ChatEventsTopic, keyed by room id
 func (k *KafkaService) PublishRoomMessage(roomID uint, message *ChatMessage) error {
-	topic := fmt.Sprintf("chat_room_%d", roomID)
-	return k.PublishMessage(topic, message)
+	if err := k.PublishKeyedMessage(ChatEventsTopic, strconv.FormatUint(uint64(roomID), 10), message); err != nil {
+		return err
+	}
+
+	if k.config.LegacyRoomTopics {
+		if err := k.PublishMessage(legacyRoomTopic(roomID), message); err != nil {
+			log.Printf("Failed to publish to legacy room topic: %v", err)
+		}
+	}
+	return nil
 }
 
-// SubscribeToRoom subscribes to messages from a specific room
-func (k *KafkaService) SubscribeToRoom(roomID uint, handler MessageHandler) error {
-	topic := fmt.Sprintf("chat_room_%d", roomID)
+// DeleteLegacyRoomTopics removes the per-room topics used before ChatEventsTopic and returns how many were deleted.
+// They only carried real-time notifications of messages already stored in the database, so nothing is copied.
+// Run it once every replica publishes to ChatEventsTopic and KAFKA_LEGACY_ROOM_TOPICS is off.
+func (k *KafkaService) DeleteLegacyRoomTopics() (int, error) {
+	admin, err := sarama.NewClusterAdmin(k.config.Brokers, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cluster admin: %v", err)
+	}
+	defer admin.Close()
 
-	// Create topic if it doesn't exist
-	if err := k.CreateTopic(topic); err != nil {
-		log.Printf("Topic creation failed (might already exist): %v", err)
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list topics: %v", err)
+	}
+
+	deleted := 0
+	for topic := range topics {
+		suffix, ok := strings.CutPrefix(topic, legacyRoomTopicPrefix)
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseUint(suffix, 10, 32); err != nil {
+			continue
+		}
+		if err := admin.DeleteTopic(topic); err != nil {
+			return deleted, fmt.Errorf("failed to delete topic %s: %v", topic, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func legacyRoomTopic(roomID uint) string {
+	return fmt.Sprintf("%s%d", legacyRoomTopicPrefix, roomID)
+}
+
+// kafkaRecord converts a consumed message; of repeated headers the last wins
+func kafkaRecord(msg *sarama.ConsumerMessage) *Record {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
 	}
 
-	return k.SubscribeToTopic(topic, handler)
+	return &Record{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+func kafkaHeaders(headers map[string]string) []sarama.RecordHeader {
+	recordHeaders := make([]sarama.RecordHeader, 0, len(headers))
+	for name, value := range headers {
+		recordHeaders = append(recordHeaders, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+	}
+	return recordHeaders
 }
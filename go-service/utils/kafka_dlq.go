@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers added to dead-lettered messages, and to replayed ones
+const (
+	HeaderDLQTopic      = "dlq-original-topic"
+	HeaderDLQPartition  = "dlq-original-partition"
+	HeaderDLQOffset     = "dlq-original-offset"
+	HeaderDLQHandler    = "dlq-handler" // Empty when the payload could not be decoded
+	HeaderDLQError      = "dlq-error"
+	HeaderDLQAttempts   = "dlq-attempts"
+	HeaderDLQFailedAt   = "dlq-failed-at" // RFC 3339
+	HeaderReplayHandler = "replay-handler"
+)
+
+// RetryPolicy controls how often a failing handler is called before its message is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts    int // 0 retries forever and never dead-letters
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (p RetryPolicy) next(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	backoff *= 2
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
+
+// DeadLetterTopic names the topic that messages of topic go to once their handler gives up
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// DeadLetterMessage is a message read from a dead-letter topic
+type DeadLetterMessage struct {
+	Topic             string // Dead-letter topic
+	Partition         int32
+	Offset            int64
+	OriginalTopic     string
+	OriginalPartition int32
+	OriginalOffset    int64
+	Key               []byte
+	Value             []byte
+	Handler           string
+	Error             string
+	Attempts          int
+	FailedAt          time.Time
+}
+
+// deadLetter copies a record to its topic's dead-letter topic with the failure in its headers. Publishing is retried
+// until it succeeds, so nothing is lost; it returns false if ctx ended first.
+func (k *KafkaService) deadLetter(ctx context.Context, record *Record, handler string, cause error, attempts int) bool {
+	producerMessage := &sarama.ProducerMessage{
+		Topic:   DeadLetterTopic(record.Topic),
+		Value:   sarama.ByteEncoder(record.Value),
+		Headers: kafkaHeaders(deadLetterHeaders(record, handler, cause, attempts)),
+	}
+	if record.Key != nil {
+		producerMessage.Key = sarama.ByteEncoder(record.Key)
+	}
+
+	backoff := k.config.Retry.InitialBackoff
+	for {
+		_, _, err := k.producer.SendMessage(producerMessage)
+		if err == nil {
+			log.Printf("Dead-lettered %s/%d offset %d for handler %q: %v", record.Topic, record.Partition, record.Offset, handler, cause)
+			return true
+		}
+		log.Printf("Failed to dead-letter %s/%d offset %d: %v", record.Topic, record.Partition, record.Offset, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = k.config.Retry.next(backoff)
+	}
+}
+
+// ConsumeDeadLetters reads dead-letter topics from the oldest message with a consumer group of its own.
+// A message is committed once handler succeeds; failures are retried with backoff.
+func (k *KafkaService) ConsumeDeadLetters(topics []string, handler func(message *DeadLetterMessage) error) error {
+	groupID := k.config.GroupID + ".dlq"
+	group, err := k.newConsumerGroup(groupID, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.consumers[groupID+"/"+strings.Join(topics, ",")] = group
+	k.mu.Unlock()
+
+	go k.consume(group, groupID, topics, &groupHandler{process: deadLetterConsumer(handler, k.config.Retry)})
+	return nil
+}
+
+// ReplayDeadLetter publishes a dead-lettered message to its original topic again. With a handler name only that
+// handler runs it, so handlers that already succeeded do not see it twice.
+func (k *KafkaService) ReplayDeadLetter(topic string, key, value []byte, handler string) error {
+	producerMessage := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if len(key) > 0 {
+		producerMessage.Key = sarama.ByteEncoder(key)
+	}
+	if handler != "" {
+		producerMessage.Headers = []sarama.RecordHeader{{Key: []byte(HeaderReplayHandler), Value: []byte(handler)}}
+	}
+
+	if _, _, err := k.producer.SendMessage(producerMessage); err != nil {
+		return fmt.Errorf("failed to replay message: %v", err)
+	}
+	return nil
+}
+
+// deadLetterHeaders keeps a record's headers and adds the failure. A record dead-lettered again only carries its
+// latest failure.
+func deadLetterHeaders(record *Record, handler string, cause error, attempts int) map[string]string {
+	headers := make(map[string]string, len(record.Headers)+7)
+	for name, value := range record.Headers {
+		if strings.HasPrefix(name, "dlq-") || name == HeaderReplayHandler {
+			continue
+		}
+		headers[name] = value
+	}
+	headers[HeaderDLQTopic] = record.Topic
+	headers[HeaderDLQPartition] = strconv.Itoa(int(record.Partition))
+	headers[HeaderDLQOffset] = strconv.FormatInt(record.Offset, 10)
+	headers[HeaderDLQHandler] = handler
+	headers[HeaderDLQError] = cause.Error()
+	headers[HeaderDLQAttempts] = strconv.Itoa(attempts)
+	headers[HeaderDLQFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	return headers
+}
+
+// deadLetterConsumer passes dead-lettered records to a handler, retrying until it succeeds
+func deadLetterConsumer(handler func(message *DeadLetterMessage) error, retry RetryPolicy) recordHandler {
+	return func(ctx context.Context, record *Record) bool {
+		message := parseDeadLetter(record)
+		backoff := retry.InitialBackoff
+		for {
+			err := handler(message)
+			if err == nil {
+				return true
+			}
+			log.Printf("Dead letter handler error at %s/%d offset %d: %v", record.Topic, record.Partition, record.Offset, err)
+
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(backoff):
+			}
+			backoff = retry.next(backoff)
+		}
+	}
+}
+
+func parseDeadLetter(record *Record) *DeadLetterMessage {
+	message := &DeadLetterMessage{
+		Topic:         record.Topic,
+		Partition:     record.Partition,
+		Offset:        record.Offset,
+		OriginalTopic: record.Headers[HeaderDLQTopic],
+		Key:           record.Key,
+		Value:         record.Value,
+		Handler:       record.Headers[HeaderDLQHandler],
+		Error:         record.Headers[HeaderDLQError],
+		FailedAt:      record.Timestamp,
+	}
+	if message.OriginalTopic == "" {
+		message.OriginalTopic = strings.TrimSuffix(record.Topic, ".dlq")
+	}
+	if partition, err := strconv.ParseInt(record.Headers[HeaderDLQPartition], 10, 32); err == nil {
+		message.OriginalPartition = int32(partition)
+	}
+	if offset, err := strconv.ParseInt(record.Headers[HeaderDLQOffset], 10, 64); err == nil {
+		message.OriginalOffset = offset
+	}
+	if attempts, err := strconv.Atoi(record.Headers[HeaderDLQAttempts]); err == nil {
+		message.Attempts = attempts
+	}
+	if failedAt, err := time.Parse(time.RFC3339, record.Headers[HeaderDLQFailedAt]); err == nil {
+		message.FailedAt = failedAt
+	}
+	return message
+}
@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// memoryRetention is how many records a partition keeps once no group needs them, for groups that start at the oldest
+const memoryRetention = 10000
+
+// MemoryBroker is a Broker that keeps topics in this process, for local development and tests. Topics are split
+// into partitions by key and each group reads a partition one record at a time, committing it once it has been
+// processed, so it orders and retries events the way KafkaService does. Shared and broadcast groups both
+// deliver every event, since there is only one replica; nothing survives a restart.
+type MemoryBroker struct {
+	config    KafkaConfig
+	mu        sync.Mutex
+	topics    map[string]*memoryTopic
+	handlers  map[string][]subscription
+	processed ProcessedEventStore
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+type memoryTopic struct {
+	partitions []*memoryPartition
+	groups     map[string]*memoryGroup
+	next       int // Partition of the next record without a key
+}
+
+type memoryPartition struct {
+	records  []*Record
+	first    int64         // Offset of records[0]
+	next     int64         // Offset of the next record
+	appended chan struct{} // Closed and replaced whenever a record is appended
+}
+
+// memoryGroup holds a group's committed offset in each partition of a topic
+type memoryGroup struct {
+	offsets []int64
+}
+
+// NewMemoryBroker uses the group ids, start offset, partition count, retry policy, producer and tenant of config
+func NewMemoryBroker(config KafkaConfig) *MemoryBroker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MemoryBroker{
+		config:   config,
+		topics:   make(map[string]*memoryTopic),
+		handlers: make(map[string][]subscription),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (m *MemoryBroker) PublishEvent(topic, key string, envelope *EventEnvelope) error {
+	completeEnvelope(envelope, m.config.Producer, m.config.Tenant)
+
+	jsonData, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	var recordKey []byte
+	if key != "" {
+		recordKey = []byte(key)
+	}
+	return m.append(topic, recordKey, jsonData, eventHeaders(envelope))
+}
+
+// SubscribeToTopic joins the shared group for a topic
+func (m *MemoryBroker) SubscribeToTopic(topic string, handler MessageHandler) error {
+	return m.subscribe(m.config.GroupID, topic, subscription{handler: chatMessages(handler), retry: m.config.Retry})
+}
+
+func (m *MemoryBroker) SubscribeHandler(topic, name string, handler EventHandler, retry RetryPolicy) error {
+	return m.subscribe(m.config.GroupID, topic, subscription{name: name, handler: handler, retry: retry})
+}
+
+func (m *MemoryBroker) BroadcastToTopic(topic string, handler MessageHandler) error {
+	return m.subscribe(m.config.GroupID+"."+m.config.InstanceID, topic, subscription{handler: chatMessages(handler), retry: m.config.Retry})
+}
+
+func (m *MemoryBroker) EnsureTopic(topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.topic(topic)
+	return nil
+}
+
+// ConsumeDeadLetters reads dead-letter topics from the oldest record with a group of its own
+func (m *MemoryBroker) ConsumeDeadLetters(topics []string, handler func(message *DeadLetterMessage) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := m.join(m.config.GroupID+".dlq", topic, sarama.OffsetOldest, deadLetterConsumer(handler, m.config.Retry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBroker) ReplayDeadLetter(topic string, key, value []byte, handler string) error {
+	headers := make(map[string]string)
+	if handler != "" {
+		headers[HeaderReplayHandler] = handler
+	}
+	return m.append(topic, key, value, headers)
+}
+
+func (m *MemoryBroker) UseProcessedEventStore(store ProcessedEventStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.processed = store
+}
+
+// Close stops delivery; records not yet committed are dropped with the broker
+func (m *MemoryBroker) Close() error {
+	m.cancel()
+	return nil
+}
+
+// subscribe registers a handler and starts delivering to its group the first time the group subscribes to the topic
+func (m *MemoryBroker) subscribe(groupID, topic string, sub subscription) error {
+	key := groupID + "/" + topic
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := registerSubscription(m.handlers, key, topic, sub); err != nil {
+		return err
+	}
+	if _, ok := m.topic(topic).groups[groupID]; ok {
+		return nil
+	}
+
+	d := &dispatcher{
+		group: groupID,
+		subs: func() []subscription {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return append([]subscription(nil), m.handlers[key]...)
+		},
+		handled:    newRecentIDs(10000),
+		deadLetter: m.deadLetter,
+	}
+	if groupID == m.config.GroupID {
+		d.processed = m.processed
+	}
+	return m.join(groupID, topic, m.config.InitialOffset, d.handle)
+}
+
+// join adds a group to a topic and starts one delivery loop per partition. m.mu must be held.
+func (m *MemoryBroker) join(groupID, topicName string, initialOffset int64, process recordHandler) error {
+	topic := m.topic(topicName)
+	if _, ok := topic.groups[groupID]; ok {
+		return fmt.Errorf("group %s is already consuming %s", groupID, topicName)
+	}
+
+	group := &memoryGroup{offsets: make([]int64, len(topic.partitions))}
+	for i, partition := range topic.partitions {
+		group.offsets[i] = partition.next
+		if initialOffset == sarama.OffsetOldest {
+			group.offsets[i] = partition.first
+		}
+	}
+	topic.groups[groupID] = group
+
+	for i := range topic.partitions {
+		go m.deliver(topic, int32(i), group, process)
+	}
+	return nil
+}
+
+// deliver passes a partition's records to process in order, committing each once it has been processed
+func (m *MemoryBroker) deliver(topic *memoryTopic, partition int32, group *memoryGroup, process recordHandler) {
+	p := topic.partitions[partition]
+	for {
+		m.mu.Lock()
+		offset := group.offsets[partition]
+		if offset < p.next {
+			record := p.records[offset-p.first]
+			m.mu.Unlock()
+
+			if !process(m.ctx, record) {
+				return
+			}
+
+			m.mu.Lock()
+			group.offsets[partition] = offset + 1
+			m.trim(topic, partition)
+			m.mu.Unlock()
+			continue
+		}
+		appended := p.appended
+		m.mu.Unlock()
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-appended:
+		}
+	}
+}
+
+// append adds a record to the partition of its key, or to the next partition in turn if it has none
+func (m *MemoryBroker) append(topicName string, key, value []byte, headers map[string]string) error {
+	if m.ctx.Err() != nil {
+		return fmt.Errorf("failed to send message: broker is closed")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic := m.topic(topicName)
+	var partition int32
+	if key != nil {
+		hash := fnv.New32a()
+		hash.Write(key)
+		partition = int32(hash.Sum32() % uint32(len(topic.partitions)))
+	} else {
+		partition = int32(topic.next)
+		topic.next = (topic.next + 1) % len(topic.partitions)
+	}
+
+	p := topic.partitions[partition]
+	p.records = append(p.records, &Record{
+		Topic:     topicName,
+		Partition: partition,
+		Offset:    p.next,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: time.Now(),
+	})
+	p.next++
+	close(p.appended)
+	p.appended = make(chan struct{})
+	m.trim(topic, partition)
+
+	return nil
+}
+
+// deadLetter appends a record to its topic's dead-letter topic with the failure in its headers
+func (m *MemoryBroker) deadLetter(ctx context.Context, record *Record, handler string, cause error, attempts int) bool {
+	if err := m.append(DeadLetterTopic(record.Topic), record.Key, record.Value, deadLetterHeaders(record, handler, cause, attempts)); err != nil {
+		log.Printf("Failed to dead-letter %s/%d offset %d: %v", record.Topic, record.Partition, record.Offset, err)
+		return false
+	}
+	log.Printf("Dead-lettered %s/%d offset %d for handler %q: %v", record.Topic, record.Partition, record.Offset, handler, cause)
+	return true
+}
+
+// topic returns a topic, creating it with the configured partitions. m.mu must be held.
+func (m *MemoryBroker) topic(name string) *memoryTopic {
+	if topic, ok := m.topics[name]; ok {
+		return topic
+	}
+
+	partitions := int(m.config.Partitions)
+	if partitions < 1 {
+		partitions = 1
+	}
+	topic := &memoryTopic{groups: make(map[string]*memoryGroup)}
+	for i := 0; i < partitions; i++ {
+		topic.partitions = append(topic.partitions, &memoryPartition{appended: make(chan struct{})})
+	}
+	m.topics[name] = topic
+	return topic
+}
+
+// trim drops the records every group has committed, or beyond memoryRetention if no group reads the topic.
+// m.mu must be held.
+func (m *MemoryBroker) trim(topic *memoryTopic, partition int32) {
+	p := topic.partitions[partition]
+
+	keep := p.next - memoryRetention
+	if len(topic.groups) > 0 {
+		keep = p.next
+		for _, group := range topic.groups {
+			if group.offsets[partition] < keep {
+				keep = group.offsets[partition]
+			}
+		}
+	}
+	if keep <= p.first {
+		return
+	}
+
+	p.records = p.records[keep-p.first:]
+	p.first = keep
+}
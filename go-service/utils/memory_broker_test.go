@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const testTopic = "test.events"
+
+var testRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func newTestBroker(t *testing.T, partitions int32, initialOffset int64) *MemoryBroker {
+	t.Helper()
+
+	config := DefaultKafkaConfig(nil)
+	config.InstanceID = "test"
+	config.Partitions = partitions
+	config.InitialOffset = initialOffset
+	config.Retry = testRetry
+
+	broker := NewMemoryBroker(config)
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+// publishTestMessage publishes a chat message event whose id and message id are seq
+func publishTestMessage(t *testing.T, broker Broker, key string, seq uint) {
+	t.Helper()
+
+	payload, err := json.Marshal(&ChatMessage{ID: seq, Content: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &EventEnvelope{ID: fmt.Sprintf("event-%d", seq), Type: EventChatMessage, Payload: payload}
+	if err := broker.PublishEvent(testTopic, key, envelope); err != nil {
+		t.Fatalf("PublishEvent: %v", err)
+	}
+}
+
+// collector records the chat messages a handler receives, in order
+type collector struct {
+	mu       sync.Mutex
+	messages []*ChatMessage
+	received chan struct{}
+}
+
+func newCollector() *collector {
+	return &collector{received: make(chan struct{}, 1000)}
+}
+
+func (c *collector) handle(event *Event) error {
+	message, ok := event.Payload.(*ChatMessage)
+	if !ok {
+		return fmt.Errorf("unexpected payload %T", event.Payload)
+	}
+
+	c.mu.Lock()
+	c.messages = append(c.messages, message)
+	c.mu.Unlock()
+	c.received <- struct{}{}
+	return nil
+}
+
+// wait blocks until n messages in total have been received
+func (c *collector) wait(t *testing.T, n int) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		c.mu.Lock()
+		count := len(c.messages)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+
+		select {
+		case <-c.received:
+		case <-timeout:
+			t.Fatalf("received %d messages, want %d", count, n)
+		}
+	}
+}
+
+// ids returns the ids of the received messages, waiting briefly so that unexpected extra deliveries show up
+func (c *collector) ids() []uint {
+	time.Sleep(50 * time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]uint, len(c.messages))
+	for i, message := range c.messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+func TestMemoryBrokerKeepsPerKeyOrder(t *testing.T) {
+	broker := newTestBroker(t, 4, sarama.OffsetNewest)
+	received := newCollector()
+	if err := broker.SubscribeHandler(testTopic, "ordered", received.handle, testRetry); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"room-1", "room-2", "room-3", "room-4", "room-5"}
+	const perKey = 40
+	for i := 0; i < perKey*len(keys); i++ {
+		publishTestMessage(t, broker, keys[i%len(keys)], uint(i+1))
+	}
+	received.wait(t, perKey*len(keys))
+
+	last := make(map[string]uint)
+	counts := make(map[string]int)
+	received.mu.Lock()
+	defer received.mu.Unlock()
+	for _, message := range received.messages {
+		if message.ID <= last[message.Content] {
+			t.Fatalf("key %s: message %d delivered after %d", message.Content, message.ID, last[message.Content])
+		}
+		last[message.Content] = message.ID
+		counts[message.Content]++
+	}
+	for _, key := range keys {
+		if counts[key] != perKey {
+			t.Errorf("key %s: received %d messages, want %d", key, counts[key], perKey)
+		}
+	}
+}
+
+func TestMemoryBrokerDeadLettersAfterRetries(t *testing.T) {
+	broker := newTestBroker(t, 2, sarama.OffsetNewest)
+
+	var mu sync.Mutex
+	calls := 0
+	fixed := false
+	failing := func(event *Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if !fixed {
+			return errors.New("handler is broken")
+		}
+		return nil
+	}
+	if err := broker.SubscribeHandler(testTopic, "failing", failing, testRetry); err != nil {
+		t.Fatal(err)
+	}
+	healthy := newCollector()
+	if err := broker.SubscribeHandler(testTopic, "healthy", healthy.handle, testRetry); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters := make(chan *DeadLetterMessage, 10)
+	err := broker.ConsumeDeadLetters([]string{DeadLetterTopic(testTopic)}, func(message *DeadLetterMessage) error {
+		deadLetters <- message
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishTestMessage(t, broker, "room-1", 1)
+
+	var message *DeadLetterMessage
+	select {
+	case message = <-deadLetters:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not dead-lettered")
+	}
+	if message.OriginalTopic != testTopic || message.Handler != "failing" {
+		t.Errorf("dead letter from %s for %q, want %s for %q", message.OriginalTopic, message.Handler, testTopic, "failing")
+	}
+	if message.Attempts != testRetry.MaxAttempts {
+		t.Errorf("dead letter after %d attempts, want %d", message.Attempts, testRetry.MaxAttempts)
+	}
+	if !strings.Contains(message.Error, "handler is broken") {
+		t.Errorf("dead letter error %q does not name the cause", message.Error)
+	}
+	mu.Lock()
+	if calls != testRetry.MaxAttempts {
+		t.Errorf("failing handler called %d times, want %d", calls, testRetry.MaxAttempts)
+	}
+	fixed = true
+	mu.Unlock()
+	// A handler that gives up does not hold back the others
+	if ids := healthy.ids(); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("healthy handler received %v, want [1]", ids)
+	}
+
+	// A replay only reaches the handler the event was dead-lettered for
+	if err := broker.ReplayDeadLetter(message.OriginalTopic, message.Key, message.Value, message.Handler); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := calls == testRetry.MaxAttempts+1
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replayed event did not reach the failing handler")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if ids := healthy.ids(); len(ids) != 1 {
+		t.Errorf("healthy handler received %v after the replay, want only the original delivery", ids)
+	}
+	select {
+	case message := <-deadLetters:
+		t.Errorf("replayed event was dead-lettered again for %q", message.Handler)
+	default:
+	}
+}
+
+func TestMemoryBrokerGroupOffsets(t *testing.T) {
+	t.Run("new group starts at newest", func(t *testing.T) {
+		broker := newTestBroker(t, 2, sarama.OffsetNewest)
+		publishTestMessage(t, broker, "room-1", 1)
+		publishTestMessage(t, broker, "room-2", 2)
+
+		received := newCollector()
+		if err := broker.SubscribeHandler(testTopic, "late", received.handle, testRetry); err != nil {
+			t.Fatal(err)
+		}
+		publishTestMessage(t, broker, "room-1", 3)
+		received.wait(t, 1)
+
+		if ids := received.ids(); len(ids) != 1 || ids[0] != 3 {
+			t.Errorf("received %v, want [3]", ids)
+		}
+	})
+
+	t.Run("new group starts at oldest", func(t *testing.T) {
+		broker := newTestBroker(t, 2, sarama.OffsetOldest)
+		publishTestMessage(t, broker, "room-1", 1)
+		publishTestMessage(t, broker, "room-1", 2)
+
+		received := newCollector()
+		if err := broker.SubscribeHandler(testTopic, "late", received.handle, testRetry); err != nil {
+			t.Fatal(err)
+		}
+		received.wait(t, 2)
+
+		if ids := received.ids(); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Errorf("received %v, want [1 2]", ids)
+		}
+	})
+
+	t.Run("committed offsets are not redelivered", func(t *testing.T) {
+		broker := newTestBroker(t, 1, sarama.OffsetOldest)
+		first := newCollector()
+		if err := broker.SubscribeHandler(testTopic, "first", first.handle, testRetry); err != nil {
+			t.Fatal(err)
+		}
+		publishTestMessage(t, broker, "room-1", 1)
+		publishTestMessage(t, broker, "room-1", 2)
+		first.wait(t, 2)
+
+		// A handler joining the group continues from the group's committed offset
+		second := newCollector()
+		if err := broker.SubscribeHandler(testTopic, "second", second.handle, testRetry); err != nil {
+			t.Fatal(err)
+		}
+		publishTestMessage(t, broker, "room-1", 3)
+		first.wait(t, 3)
+		second.wait(t, 1)
+
+		if ids := first.ids(); len(ids) != 3 {
+			t.Errorf("first handler received %v, want [1 2 3]", ids)
+		}
+		if ids := second.ids(); len(ids) != 1 || ids[0] != 3 {
+			t.Errorf("second handler received %v, want [3]", ids)
+		}
+	})
+
+	t.Run("groups keep their own offsets", func(t *testing.T) {
+		broker := newTestBroker(t, 1, sarama.OffsetNewest)
+		shared := newCollector()
+		if err := broker.SubscribeHandler(testTopic, "shared", shared.handle, testRetry); err != nil {
+			t.Fatal(err)
+		}
+		broadcast := newCollector()
+		err := broker.BroadcastToTopic(testTopic, func(message *ChatMessage) error {
+			return broadcast.handle(&Event{Payload: message})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publishTestMessage(t, broker, "room-1", 1)
+		publishTestMessage(t, broker, "room-1", 2)
+		shared.wait(t, 2)
+		broadcast.wait(t, 2)
+
+		if ids := shared.ids(); len(ids) != 2 {
+			t.Errorf("shared group received %v, want [1 2]", ids)
+		}
+		if ids := broadcast.ids(); len(ids) != 2 {
+			t.Errorf("broadcast group received %v, want [1 2]", ids)
+		}
+	})
+}
+
+// processedSet is a ProcessedEventStore kept in memory
+type processedSet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (p *processedSet) IsProcessed(consumer, eventID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ids[consumer+"|"+eventID], nil
+}
+
+func (p *processedSet) MarkProcessed(consumer, eventID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids[consumer+"|"+eventID] = true
+	return nil
+}
+
+func TestMemoryBrokerSkipsProcessedEvents(t *testing.T) {
+	broker := newTestBroker(t, 1, sarama.OffsetNewest)
+	// Event 1 was handled before a restart
+	store := &processedSet{ids: map[string]bool{broker.config.GroupID + "/handler|event-1": true}}
+	broker.UseProcessedEventStore(store)
+
+	received := newCollector()
+	if err := broker.SubscribeHandler(testTopic, "handler", received.handle, testRetry); err != nil {
+		t.Fatal(err)
+	}
+	broadcast := newCollector()
+	err := broker.BroadcastToTopic(testTopic, func(message *ChatMessage) error {
+		return broadcast.handle(&Event{Payload: message})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishTestMessage(t, broker, "room-1", 1)
+	publishTestMessage(t, broker, "room-1", 2)
+	received.wait(t, 1)
+	broadcast.wait(t, 2)
+
+	if ids := received.ids(); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("shared handler received %v, want [2]", ids)
+	}
+	if processed, _ := store.IsProcessed(broker.config.GroupID+"/handler", "event-2"); !processed {
+		t.Error("handled event was not recorded")
+	}
+	// Broadcast groups belong to one replica and ignore the store
+	if ids := broadcast.ids(); len(ids) != 2 {
+		t.Errorf("broadcast handler received %v, want [1 2]", ids)
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// VirusScanner scans file content for malware
+type VirusScanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+type ScanResult struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ClamAVScanner talks to a clamd daemon using the INSTREAM command
+type ClamAVScanner struct {
+	address   string
+	timeout   time.Duration
+	chunkSize int
+}
+
+func NewClamAVScanner(address string, timeout time.Duration) *ClamAVScanner {
+	return &ClamAVScanner{
+		address:   address,
+		timeout:   timeout,
+		chunkSize: 32 * 1024,
+	}
+}
+
+// NewVirusScannerFromEnv returns a clamd client for CLAMAV_ADDRESS, or nil when scanning is not configured
+func NewVirusScannerFromEnv() VirusScanner {
+	address := os.Getenv("CLAMAV_ADDRESS")
+	if address == "" {
+		return nil
+	}
+
+	timeout := 30 * time.Second
+	if value := os.Getenv("CLAMAV_TIMEOUT"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			timeout = parsed
+		}
+	}
+
+	return NewClamAVScanner(address, timeout)
+}
+
+// Ping checks that clamd is reachable
+func (c *ClamAVScanner) Ping() error {
+	reply, err := c.command([]byte("zPING\x00"), nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	return nil
+}
+
+// Scan streams the content to clamd and parses the verdict
+func (c *ClamAVScanner) Scan(r io.Reader) (*ScanResult, error) {
+	reply, err := c.command([]byte("zINSTREAM\x00"), r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies look like "stream: OK", "stream: <signature> FOUND" or "<reason> ERROR"
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return &ScanResult{Infected: false}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &ScanResult{
+			Infected:  true,
+			Signature: strings.TrimSuffix(reply, " FOUND"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("clamd scan failed: %s", reply)
+	}
+}
+
+func (c *ClamAVScanner) command(cmd []byte, body io.Reader) (string, error) {
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write(cmd); err != nil {
+		return "", fmt.Errorf("failed to send clamd command: %v", err)
+	}
+
+	if body != nil {
+		// INSTREAM chunks are prefixed with their length and terminated by a zero-length chunk
+		buffer := make([]byte, c.chunkSize)
+		size := make([]byte, 4)
+		for {
+			n, readErr := body.Read(buffer)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size, uint32(n))
+				if _, err := conn.Write(size); err != nil {
+					return "", fmt.Errorf("failed to stream to clamd: %v", err)
+				}
+				if _, err := conn.Write(buffer[:n]); err != nil {
+					return "", fmt.Errorf("failed to stream to clamd: %v", err)
+				}
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				return "", readErr
+			}
+		}
+		if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+			return "", fmt.Errorf("failed to stream to clamd: %v", err)
+		}
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}